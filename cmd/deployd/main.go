@@ -20,10 +20,12 @@ import (
 	mycontent_base "github.com/desain-gratis/common/delivery/mycontent-api/mycontent/base"
 	blob_s3 "github.com/desain-gratis/common/delivery/mycontent-api/storage/blob/s3"
 	content_chraft "github.com/desain-gratis/common/delivery/mycontent-api/storage/content/clickhouse-raft"
+	"github.com/desain-gratis/common/lib/notifier"
 	notifier_api "github.com/desain-gratis/common/lib/notifier/api"
 	notifier_impl "github.com/desain-gratis/common/lib/notifier/impl"
 	raft_runner "github.com/desain-gratis/common/lib/raft/runner"
 
+	"github.com/desain-gratis/deployd/internal/src/artifactd"
 	deployjobintegration "github.com/desain-gratis/deployd/internal/src/deploy-job"
 	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
 	"github.com/desain-gratis/deployd/internal/src/systemd"
@@ -61,6 +63,9 @@ var (
 
 	// deploy job client
 	raftDeployjobUsecase *deployjob.Client
+
+	// systemd unit state; only if systemd module is enabled
+	systemdTopic notifier.Topic
 )
 
 var currentHost = &entity.Host{
@@ -80,6 +85,8 @@ func main() {
 
 	initConfig()
 
+	currentHost.Address = config.GetString("http.internal.address")
+
 	err := deployd.InjectSecretToViper(config.Viper)
 	if err != nil && !errors.Is(err, deployd.ErrNotConfigured) {
 		log.Panic().Msgf("failed to merge config with secret: %v", err)
@@ -146,6 +153,7 @@ func enableUI(_ context.Context, router *httprouter.Router) {
 
 func enableSystemdModule(ctx context.Context, router *httprouter.Router) {
 	topic := notifier_impl.NewStandardTopic()
+	systemdTopic = topic
 
 	integration := systemd.New(ctx, topic)
 	httpIntegration := systemd.Http(integration)
@@ -165,10 +173,21 @@ func enableJobModule(ctx context.Context, router *httprouter.Router) {
 	// IMPORTANT: start often forgotten. Start before replica started to make sure no message is lost
 	subscription.Start()
 
+	// per namespace, eg. deployjob.required_approvals.production: 2
+	requiredApprovals := make(map[string]uint)
+	for ns := range config.GetStringMap("deployjob.required_approvals") {
+		requiredApprovals[ns] = config.GetUint("deployjob.required_approvals." + ns)
+	}
+
 	ctx, err = raft_runner.RunReplica[any](
 		ctx,
 		"deploy-job-v1",
-		deployjob.New(jobTopic),
+		deployjob.New(jobTopic, deployjob.Config{
+			PortRangeStart: uint16(config.GetUint("deployjob.port_range.start")),
+			PortRangeEnd:   uint16(config.GetUint("deployjob.port_range.end")),
+
+			RequiredApprovals: requiredApprovals,
+		}),
 	)
 	if err != nil {
 		log.Fatal().Msgf("failed to run deploy-job-v1 raft: %v", err)
@@ -183,6 +202,26 @@ func enableJobModule(ctx context.Context, router *httprouter.Router) {
 		[]string{"service"},
 	)
 
+	// service instance of each host; maintained by the deploy-job raft
+	serviceDeploymentStore := content_chraft.NewStorageClient(ctx, deployjob.TableServiceInstanceHost)
+	serviceDeploymentUsecase = mycontent_base.New[*entity.ServiceInstanceHost](serviceDeploymentStore, 1)
+	serviceDeploymentHandler := mycontentapi.New(
+		serviceDeploymentUsecase,
+		publicBaseURL+"/deployd/deployment",
+		[]string{"service"},
+	)
+
+	// audit trail of every applied job command; written by the deploy-job raft
+	jobAuditStore := content_chraft.NewStorageClient(ctx, deployjob.TableJobAudit)
+	jobAuditUsecase := mycontent_base.New[*entity.JobAudit](jobAuditStore, 2)
+
+	// current freeze of each namespace; modified through the job module
+	freezeHandler := mycontentapi.New(
+		mycontent_base.New[*entity.Freeze](content_chraft.NewStorageClient(ctx, deployjob.TableFreeze), 0),
+		publicBaseURL+"/deployd/freeze",
+		nil,
+	)
+
 	// more advanced
 	rClient, err := raft_runner.NewClient(ctx)
 	if err != nil {
@@ -191,6 +230,15 @@ func enableJobModule(ctx context.Context, router *httprouter.Router) {
 
 	raftDeployjobUsecase = deployjob.NewClient(rClient)
 
+	// per namespace, eg. deployjob.artifact_policy.production.require_signature: true
+	artifactPolicies := make(map[string]deployjobintegration.ArtifactPolicy)
+	for ns := range config.GetStringMap("deployjob.artifact_policy") {
+		artifactPolicies[ns] = deployjobintegration.ArtifactPolicy{
+			RequireSignature: config.GetBool("deployjob.artifact_policy." + ns + ".require_signature"),
+			PublicKeys:       config.GetStringSlice("deployjob.artifact_policy." + ns + ".public_keys"),
+		}
+	}
+
 	integration := deployjobintegration.New(
 		ctx,
 		&deployjobintegration.Dependencies{
@@ -203,17 +251,48 @@ func enableJobModule(ctx context.Context, router *httprouter.Router) {
 			RaftJobUsecase:           raftDeployjobUsecase,
 			BuildArtifactUsecase:     buildArtifactUsecase,
 			JobUsecase:               jobUsecase,
+			JobAuditUsecase:          jobAuditUsecase,
+			SystemdTopic:             systemdTopic,
 		},
 		currentHost,
+		deployjobintegration.ControllerConfig{
+			Workers:   config.GetInt("deployjob.controller.workers"),
+			Downloads: config.GetInt("deployjob.controller.downloads"),
+
+			ArtifactPolicies: artifactPolicies,
+		},
 	)
 
 	router.POST("/deployd/job/submit", integration.Http.SubmitJob)
+	router.POST("/deployd/job/plan", integration.Http.PlanJob)
+	router.POST("/deployd/job/plan/local", integration.Http.LocalPlan) // called by the planning host
 	router.POST("/deployd/job/cancel/:service/:id", integration.Http.CancelJob)
-	router.POST("/deployd/job/confirm-deployment", integration.Http.ConfirmDeployment)
+	router.POST("/deployd/job/rollback/:service", integration.Http.RollbackJob)
+	router.POST("/deployd/job/retry/:service/:id/:host", integration.Http.RetryHost)
+	router.POST("/deployd/job/remove-service/:service", integration.Http.RemoveService)
+	router.POST("/deployd/job/confirm-deployment/:service/:id", integration.Http.ConfirmDeployment)
 
 	router.GET("/deployd/job", jobHandler.Get)
 
+	// ?reason=<reason>&until=<RFC3339>; the submit request can still override it (override_freeze) for emergency fixes
+	router.POST("/deployd/freeze", integration.Http.FreezeNamespace)
+	router.DELETE("/deployd/freeze", integration.Http.UnfreezeNamespace)
+	router.GET("/deployd/freeze", freezeHandler.Get)
+
+	// Deployd deployment: what is running on which host
+	// Because it is modified by server, we will not expose the Post & Delete interface
+	router.GET("/deployd/deployment", serviceDeploymentHandler.Get)
+
+	// per job: ?service=<service>&job_id=<id>[&before=<id>]; per namespace: without params (latest first). ?limit=<n> for all
+	router.GET("/deployd/job/audit", integration.Http.JobAudit)
+
 	integration.Event.StartConsumer(jobTopic, subscription)
+	integration.Event.Recover(ctx) // resume jobs interrupted by the last shutdown
+	integration.Leader.StartWatcher(ctx)
+	integration.Reconciler.Start(ctx, deployjobintegration.ReconcilerConfig{
+		Interval: config.GetDuration("deployjob.reconciler.interval"),
+		Repair:   config.GetBool("deployjob.reconciler.repair"),
+	})
 
 	handler := notifier_api.NewTopicAPI(jobTopic, topicRender)
 	router.GET("/deployd/job/tail", handler.Tail)
@@ -310,9 +389,6 @@ func enableDeploydModule(ctx context.Context, router *httprouter.Router) {
 		nil,
 	)
 
-	serviceDeploymentStorage := content_chraft.NewStorageClient(ctx, "deployd_service_deployment")
-	serviceDeploymentUsecase = mycontent_base.New[*entity.ServiceInstanceHost](serviceDeploymentStorage, 2) // TODO: delete
-
 	raftHostHandler := mycontentapi.NewFromStorage[*entity.RaftHost](
 		publicBaseURL+"/deployd/raft/host",
 		[]string{"service"},
@@ -338,11 +414,7 @@ func enableDeploydModule(ctx context.Context, router *httprouter.Router) {
 	router.POST("/deployd/service", serviceDefinitionHandler.Post)
 	router.DELETE("/deployd/service", serviceDefinitionHandler.Delete)
 
-	// Service deployment
-	// router.GET("/deployd/deployment", serviceDeploymentHandler.Get)
-	// cannot be edited by user
-	// router.POST("/deployd/deployment", serviceDeploymentHandler.Post)
-	// router.DELETE("/deployd/deployment", serviceDeploymentHandler.Delete)
+	// Service deployment: see enableJobModule, it is maintained by the deploy-job raft
 
 	// Replica registry for each service.
 	// We get the source of truth from application that use deployd library.
@@ -355,9 +427,6 @@ func enableDeploydModule(ctx context.Context, router *httprouter.Router) {
 	router.GET("/deployd/raft/host", raftHostHandler.Get)
 	router.DELETE("/deployd/raft/host", raftHostHandler.Delete)
 
-	// Deployd deployment: service definition that has been deployed
-	// Because it is modified by server, we will not expose the Post & Delete interface
-	// router.GET("/deployd/deployment", raftHostHandler.Get)
 }
 
 // enableArtifactDienableArtifactdModulescoveryModule enables upload artifact discovery / metadata query
@@ -409,7 +478,7 @@ func enableArtifactdModule(ctx context.Context, router *httprouter.Router) {
 	buildArtifactUsecase = mycontent_base.NewAttachment(
 		content_chraft.NewStorageClient(ctx, "artifactd_archive"),
 		2,
-		buildArtifactBlob,
+		artifactd.WithDigest(buildArtifactBlob),
 		false,
 		"artifactd/archive",
 	)
@@ -0,0 +1,89 @@
+package artifactd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/desain-gratis/common/delivery/mycontent-api/storage/blob"
+	common_entity "github.com/desain-gratis/common/types/entity"
+	"github.com/rs/zerolog/log"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+var _ blob.Repository = &digestRepository{}
+
+// digestRepository record the sha-256 digest of the uploaded archive into the attachment hash,
+// so the host can verify the archive before installing it.
+type digestRepository struct {
+	blob.Repository
+}
+
+// WithDigest wraps the archive blob storage
+func WithDigest(repo blob.Repository) blob.Repository {
+	return &digestRepository{Repository: repo}
+}
+
+func (d *digestRepository) Upload(ctx context.Context, path string, attachment *common_entity.Attachment, payload io.Reader) (*blob.Data, error) {
+	signature := entity.ArchiveSignature(attachment.Tags)
+	if signature != "" {
+		decoded, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil || len(decoded) != ed25519.SignatureSize {
+			return nil, fmt.Errorf("invalid archive signature: expected base64 ed25519 signature")
+		}
+	}
+
+	// uploader can send the expected digest; reject the archive if it's corrupted on the way.
+	// the mismatch is found before anything is written to the blob storage, so the stored archive is never replaced.
+	// note: Attach still delete the metadata row when the upload fails; re-uploading an existing archive
+	// with a wrong digest remove it from the listing until it is uploaded again.
+	if attachment.Hash != "" {
+		return d.uploadVerified(ctx, path, attachment, payload)
+	}
+
+	hash := sha256.New()
+	data, err := d.Repository.Upload(ctx, path, attachment, io.TeeReader(payload, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	attachment.Hash = entity.ArchiveDigest(hash.Sum(nil))
+
+	return data, nil
+}
+
+// uploadVerified spool the archive to a local temporary file while hashing, and only upload it if the digest is matching.
+// the archive is written twice (local disk, then blob storage), but only uploaded once.
+func (d *digestRepository) uploadVerified(ctx context.Context, path string, attachment *common_entity.Attachment, payload io.Reader) (*blob.Data, error) {
+	f, err := os.CreateTemp("", "artifactd-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary archive: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			log.Warn().Err(err).Msgf("failed to delete temporary archive %v", f.Name())
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), payload); err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	digest := entity.ArchiveDigest(hash.Sum(nil))
+	if attachment.Hash != digest {
+		return nil, fmt.Errorf("archive digest not matching! expected %v got %v", attachment.Hash, digest)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read temporary archive: %w", err)
+	}
+
+	return d.Repository.Upload(ctx, path, attachment, f)
+}
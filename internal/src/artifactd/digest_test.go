@@ -0,0 +1,123 @@
+package artifactd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"testing"
+
+	"github.com/desain-gratis/common/delivery/mycontent-api/storage/blob"
+	common_entity "github.com/desain-gratis/common/types/entity"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+var _ blob.Repository = &memoryBlob{}
+
+// memoryBlob is a blob storage in memory that count the uploads
+type memoryBlob struct {
+	data    map[string][]byte
+	uploads int
+}
+
+func newMemoryBlob() *memoryBlob {
+	return &memoryBlob{data: make(map[string][]byte)}
+}
+
+func (m *memoryBlob) Upload(_ context.Context, path string, _ *common_entity.Attachment, payload io.Reader) (*blob.Data, error) {
+	m.uploads++
+	data, err := io.ReadAll(payload)
+	if err != nil {
+		return nil, err
+	}
+	m.data[path] = data
+	return &blob.Data{Path: path, ContentSize: int64(len(data))}, nil
+}
+
+func (m *memoryBlob) Delete(_ context.Context, path string) (*blob.Data, error) {
+	delete(m.data, path)
+	return &blob.Data{Path: path}, nil
+}
+
+func (m *memoryBlob) Get(_ context.Context, path string) (io.ReadCloser, *blob.Data, error) {
+	return io.NopCloser(bytes.NewReader(m.data[path])), &blob.Data{Path: path}, nil
+}
+
+func digestOf(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return entity.ArchiveDigest(sum[:])
+}
+
+func TestDigestRecorded(t *testing.T) {
+	store := newMemoryBlob()
+	payload := []byte("release archive")
+
+	attachment := &common_entity.Attachment{}
+	if _, err := WithDigest(store).Upload(context.Background(), "archive", attachment, bytes.NewReader(payload)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if attachment.Hash != digestOf(payload) {
+		t.Fatalf("expected digest %v, got %v", digestOf(payload), attachment.Hash)
+	}
+	if !bytes.Equal(store.data["archive"], payload) {
+		t.Fatal("archive is not stored")
+	}
+}
+
+func TestDigestVerified(t *testing.T) {
+	store := newMemoryBlob()
+	payload := []byte("release archive")
+
+	attachment := &common_entity.Attachment{Hash: digestOf(payload)}
+	if _, err := WithDigest(store).Upload(context.Background(), "archive", attachment, bytes.NewReader(payload)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if store.uploads != 1 {
+		t.Fatalf("expected a single upload, got %v", store.uploads)
+	}
+	if !bytes.Equal(store.data["archive"], payload) {
+		t.Fatal("archive is not stored")
+	}
+}
+
+func TestDigestMismatchKeepStoredArchive(t *testing.T) {
+	store := newMemoryBlob()
+	store.data["archive"] = []byte("previous archive")
+
+	attachment := &common_entity.Attachment{Hash: digestOf([]byte("release archive"))}
+	_, err := WithDigest(store).Upload(context.Background(), "archive", attachment, bytes.NewReader([]byte("corrupted archive")))
+	if err == nil {
+		t.Fatal("expected digest mismatch error")
+	}
+
+	if store.uploads != 0 {
+		t.Fatalf("expected nothing uploaded, got %v uploads", store.uploads)
+	}
+	if string(store.data["archive"]) != "previous archive" {
+		t.Fatalf("stored archive is replaced: %q", store.data["archive"])
+	}
+}
+
+func TestDigestInvalidSignature(t *testing.T) {
+	for name, signature := range map[string]string{
+		"not base64": "not-base64!",
+		"wrong size": base64.StdEncoding.EncodeToString([]byte("short")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := newMemoryBlob()
+			attachment := &common_entity.Attachment{Tags: []string{entity.ArchiveSignaturePrefix + signature}}
+
+			_, err := WithDigest(store).Upload(context.Background(), "archive", attachment, bytes.NewReader([]byte("release archive")))
+			if err == nil {
+				t.Fatal("expected invalid signature error")
+			}
+			if store.uploads != 0 {
+				t.Fatalf("expected nothing uploaded, got %v uploads", store.uploads)
+			}
+		})
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"log/slog"
 	"os"
+	"sync"
 
 	mycontent_base "github.com/desain-gratis/common/delivery/mycontent-api/mycontent/base"
+	"github.com/desain-gratis/common/lib/notifier"
 	"github.com/desain-gratis/deployd/src/entity"
 
 	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
@@ -33,11 +35,17 @@ type Dependencies struct {
 
 	JobUsecase *mycontent_base.Handler[*entity.DeploymentJob]
 
+	// audit trail of the job commands; written by the deploy-job raft
+	JobAuditUsecase *mycontent_base.Handler[*entity.JobAudit]
+
 	// attachment
 	BuildArtifactUsecase *mycontent_base.HandlerWithAttachment
 
 	// deploy job client
 	RaftJobUsecase *deployjob.Client
+
+	// optional; unit state streamed by the systemd module. If empty, we poll systemd directly
+	SystemdTopic notifier.Topic
 }
 
 // or interface
@@ -47,21 +55,52 @@ type integration struct {
 
 	// In process interface exposed for consuming events;
 	Event *eventHandler
+
+	// Process that only run on the raft leader (eg. timeout)
+	Leader *leaderHandler
+
+	// Process that check this host still match the latest deployment
+	Reconciler *reconcilerHandler
 }
 
-func New(ctx context.Context, deps *Dependencies, host *entity.Host) *integration {
+func New(ctx context.Context, deps *Dependencies, host *entity.Host, config ControllerConfig) *integration {
 	jobsController := &jobsController{
 		dependencies:      deps,
 		host:              host,
 		deploymentJobPool: make(map[string]*deploymentJob),
+		serviceLocks:      make(map[string]*sync.Mutex),
+		workers:           newSemaphore(config.Workers, defaultWorkers),
+		downloads:         newSemaphore(config.Downloads, defaultDownloads),
+		artifactPolicies:  config.ArtifactPolicies,
 		log: slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})).
 			With("type", "controller").
 			With("job", "deployment-controller"),
 	}
 
 	i := &integration{
-		Http:  &httpHandler{jobsController: jobsController, dependencies: deps},
-		Event: &eventHandler{jobsController: jobsController, dependencies: deps},
+		Http: &httpHandler{jobsController: jobsController, dependencies: deps},
+		Event: &eventHandler{
+			jobsController: jobsController,
+			dependencies:   deps,
+			log: slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})).
+				With("type", "recovery").
+				With("job", "deployment-recovery"),
+			recovered: make(chan entity.DeploymentJob),
+		},
+		Leader: &leaderHandler{
+			jobsController: jobsController,
+			dependencies:   deps,
+			log: slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})).
+				With("type", "leader").
+				With("job", "deployment-leader"),
+		},
+		Reconciler: &reconcilerHandler{
+			jobsController: jobsController,
+			dependencies:   deps,
+			log: slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})).
+				With("type", "reconciler").
+				With("job", "drift-reconciler"),
+		},
 	}
 
 	return i
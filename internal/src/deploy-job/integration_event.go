@@ -1,24 +1,47 @@
 package deployjob
 
 import (
+	"context"
+	"log/slog"
+
 	"github.com/desain-gratis/common/lib/notifier"
 
 	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
 )
 
 type eventHandler struct {
 	jobsController *jobsController
 	dependencies   *Dependencies
+	log            *slog.Logger
+
+	// jobs found by Recover; handled by the consumer so the job pool is only modified from there
+	recovered chan entity.DeploymentJob
 }
 
 // StartConsumer exposed to main program
 func (w *eventHandler) StartConsumer(topic notifier.Topic, subscription notifier.Subscription) {
 	go func() {
-		for event := range subscription.Listen() {
+		events := subscription.Listen()
+		for {
+			var event any
+			select {
+			case job := <-w.recovered:
+				w.jobsController.recoverJob(topic, job)
+				continue
+			case value, ok := <-events:
+				if !ok {
+					return
+				}
+				event = value
+			}
+
 			switch value := event.(type) {
 			case deployjob.EventDeploymentJobCreated:
 				w.jobsController.configureHost(topic, value.Job)
 			case deployjob.EventDeploymentJobCancelled:
+				w.jobsController.cancelJob(topic, value)
+			case deployjob.EventDeploymentJobTimedOut:
 				w.jobsController.cancelDeployment(topic, value.Job)
 			case deployjob.EventRestartConfirmed:
 				w.jobsController.restartService(topic, value)
@@ -26,9 +49,41 @@ func (w *eventHandler) StartConsumer(topic notifier.Topic, subscription notifier
 				w.jobsController.confirmDeploymentAsUserIfEnabled(topic, value)
 			case deployjob.EventServiceRestarted:
 				w.jobsController.continueRestartServiceAsUserIfEnabled(topic, value)
+			case deployjob.EventCanaryStarted:
+				w.jobsController.watchCanary(topic, value)
+			case deployjob.EventHostRetry:
+				w.jobsController.retryHost(topic, value)
+			case deployjob.EventDecommissionStarted:
+				w.jobsController.decommissionHost(topic, value)
+			case deployjob.EventCleanupStarted:
+				w.jobsController.cleanupHost(topic, value)
+			case deployjob.EventCleanupFinished:
+				w.jobsController.finishJob(topic, value.Job)
 
 			default:
 			}
 		}
 	}()
 }
+
+// Recover exposed to main program. Resume the jobs that are still in progress in this host
+// (eg. deployd restarted in the middle of a job). Must be called after StartConsumer.
+func (w *eventHandler) Recover(ctx context.Context) {
+	go func() {
+		jobs, err := w.jobsController.waitInFlightJobs(ctx)
+		if err != nil {
+			w.log.Error("failed to recover in-flight jobs", "error", err)
+			return
+		}
+
+		for _, job := range jobs {
+			select {
+			case <-ctx.Done():
+				return
+			case w.recovered <- job:
+			}
+		}
+
+		w.log.Info("in-flight jobs recovered", "count", len(jobs))
+	}()
+}
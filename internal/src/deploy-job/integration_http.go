@@ -2,11 +2,14 @@ package deployjob
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/desain-gratis/common/delivery/mycontent-api/mycontent"
 	"github.com/desain-gratis/common/lib/notifier"
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/zerolog/log"
@@ -27,76 +30,114 @@ func (h *httpHandler) SubmitJob(w http.ResponseWriter, r *http.Request, p httpro
 
 	ctx := r.Context()
 
+	dj, ok := h.parseSubmitRequest(w, r)
+	if !ok {
+		return
+	}
+
+	modifySecret := "generate secret"
+	dj.ModifyKey = &modifySecret // TODO: nice to have; only user that have the secret can update this state
+	// or authorized at higher level (eg. based on namespace); but this one of the basic tool we can use
+
+	result, err := h.dependencies.RaftJobUsecase.SubmitJob(ctx, dj)
+	if err != nil {
+		writeError(w, err, "failed to submit job")
+		return
+	}
+
+	// TODO: handle error based on status
+	if result.SubmitJobStatus == deployjob.SubmitJobStatusNeedRetry {
+		// TODO: need retry!!!
+	}
+
+	fmt.Fprintf(w, `{"success": "job submitted with id: %v (%v)"}`, result.Job.Id, result.SubmitJobStatus)
+}
+
+// parseSubmitRequest parse the submit job request and fill in the service definition; write the error if any
+func (h *httpHandler) parseSubmitRequest(w http.ResponseWriter, r *http.Request) (entity.SubmitDeploymentJobRequest, bool) {
 	limitR := http.MaxBytesReader(w, r.Body, 100000000)
 	payload, err := io.ReadAll(limitR)
 	if err != nil {
-		fmt.Fprintf(w, `{"error": "failed to parse data"}`) // TODO: more appropriate
-		return
+		writeError(w, badRequest("failed to parse data"), "")
+		return entity.SubmitDeploymentJobRequest{}, false
 	}
 
 	var dj entity.SubmitDeploymentJobRequest
 	err = json.Unmarshal(payload, &dj)
 	if err != nil {
-		fmt.Fprintf(w, `{"error": "failed to parse data"}`) // TODO: more appropriate
-		return
+		writeError(w, badRequest("failed to parse data"), "")
+		return entity.SubmitDeploymentJobRequest{}, false
 	}
 
 	if dj.Ns == "" {
-		fmt.Fprintf(w, `{"error": "Namespace is required"}`) // TODO: more appropriate
-		return
+		writeError(w, badRequest("Namespace is required"), "")
+		return entity.SubmitDeploymentJobRequest{}, false
 	}
 
 	if dj.Service.Id == "" {
-		fmt.Fprintf(w, `{"error": "service id is required. the rest of service configuration can be left empty"}`) // TODO: more appropriate
-		return
+		writeError(w, badRequest("service id is required. the rest of service configuration can be left empty"), "")
+		return entity.SubmitDeploymentJobRequest{}, false
 	}
 
 	// check if valid service
-	services, err := h.dependencies.ServiceDefinitionUsecase.Get(ctx, dj.Ns, nil, dj.Service.Id)
+	services, err := h.dependencies.ServiceDefinitionUsecase.Get(r.Context(), dj.Ns, nil, dj.Service.Id)
 	if err != nil {
-		fmt.Fprintf(w, `{"error": "error get service definition: %v"}`, err) // TODO: more appropriate
-		return
+		writeError(w, err, "error get service definition")
+		return entity.SubmitDeploymentJobRequest{}, false
+	}
+	if len(services) == 0 {
+		writeError(w, &deployjob.Error{Code: deployjob.ErrorCodeNotFound, Message: "service not found"}, "")
+		return entity.SubmitDeploymentJobRequest{}, false
 	}
-	service := services[0]
 
-	// check the latest job for this service
-	// we can get the latest as long as the base storage uses "Incremental ID" type
-	jobs, err := h.dependencies.JobUsecase.Get(ctx, dj.Ns, []string{dj.Service.Id}, "")
-	if err != nil {
-		fmt.Fprintf(w, `{"error": "failed to parse data: %v"}`, err) // TODO: more appropriate
+	// active job / queue is validated inside raft
+
+	dj.Service = *services[0]
+	dj.PublishedAt = time.Now()
+
+	return dj, true
+}
+
+// PlanJob returns what the submitted job would do (dry run), with the view of each host; nothing is proposed to raft
+func (h *httpHandler) PlanJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	ctx := r.Context()
+
+	dj, ok := h.parseSubmitRequest(w, r)
+	if !ok {
 		return
 	}
 
-	if len(jobs) > 0 {
-		// extra validation for > 0 job
-		latestJob := jobs[0]
-
-		// if too soon, possible duplicate!
-		if time.Since(latestJob.PublishedAt) < time.Duration(10*time.Second) {
-			fmt.Fprintf(w, `{"error": "too fast, please wait"}`) // TODO: more appropriate
-			return
-		}
+	plan, err := h.dependencies.RaftJobUsecase.Plan(ctx, dj)
+	if err != nil {
+		writeError(w, err, "failed to plan job")
+		return
 	}
 
-	dj.Service = *service
-	dj.PublishedAt = time.Now()
+	plan.Unit = BuildUnit(dj.Ns, dj.Service.Id, dj.Service.Description, dj.Service.ExecutablePath)
+	h.jobsController.addLocalViews(ctx, &plan, dj)
 
-	modifySecret := "generate secret"
-	dj.ModifyKey = &modifySecret // TODO: nice to have; only user that have the secret can update this state
-	// or authorized at higher level (eg. based on namespace); but this one of the basic tool we can use
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(plan)
+}
 
-	result, err := h.dependencies.RaftJobUsecase.SubmitJob(ctx, dj)
+// LocalPlan returns what is installed for the service in this host; called by the host that is planning the job
+func (h *httpHandler) LocalPlan(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	limitR := http.MaxBytesReader(w, r.Body, 100000000)
+	payload, err := io.ReadAll(limitR)
 	if err != nil {
-		fmt.Fprintf(w, `{"error": "failed to submit job: %v"}`, err) // TODO: more appropriate
+		writeError(w, badRequest("failed to parse data"), "")
 		return
 	}
 
-	// TODO: handle error based on status
-	if result.SubmitJobStatus == deployjob.SubmitJobStatusNeedRetry {
-		// TODO: need retry!!!
+	var dj entity.SubmitDeploymentJobRequest
+	err = json.Unmarshal(payload, &dj)
+	if err != nil {
+		writeError(w, badRequest("failed to parse data"), "")
+		return
 	}
 
-	fmt.Fprintf(w, `{"success": "job submitted with id: %v (%v)"}`, result.Job.Id, result.SubmitJobStatus)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.jobsController.localPlan(dj))
 }
 
 func (h *httpHandler) CancelJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -110,7 +151,11 @@ func (h *httpHandler) CancelJob(w http.ResponseWriter, r *http.Request, p httpro
 	// we can get the latest as long as the base storage uses "Incremental ID" type
 	jobs, err := h.dependencies.JobUsecase.Get(ctx, ns, []string{service}, jobID)
 	if err != nil {
-		fmt.Fprintf(w, `{"error": "failed to get existing job: %v"}`, err) // TODO: more appropriate
+		writeError(w, err, "failed to get existing job")
+		return
+	}
+	if len(jobs) == 0 {
+		writeError(w, &deployjob.Error{Code: deployjob.ErrorCodeNotFound, Message: "job not found"}, "")
 		return
 	}
 	job := jobs[0]
@@ -123,32 +168,312 @@ func (h *httpHandler) CancelJob(w http.ResponseWriter, r *http.Request, p httpro
 
 	// if latestJob.Request.Service.
 
-	_, err = h.dependencies.RaftJobUsecase.CancelJob(ctx, entity.CancelJobRequest{
-		Ns:      ns,
-		Id:      jobID,
-		Service: service,
+	_, err = h.dependencies.RaftJobUsecase.CancelJob(ctx, deployjob.CancelJobRequest{
+		Ns:        ns,
+		JobId:     jobID,
+		Service:   service,
+		Reason:    r.URL.Query().Get("reason"),
+		Agent:     r.Header.Get("X-Agent"),
+		Rollback:  r.URL.Query().Get("rollback") == "true",
+		CreatedAt: time.Now(),
 	})
 	if err != nil {
-		fmt.Fprintf(w, `{"error": "failed to submit job: %v"}`, err) // TODO: more appropriate
+		writeError(w, err, "failed to submit job")
 		return
 	}
 
 	// we can make it sync by subscribing to the topic.. but can be done later..
 
-	fmt.Fprintf(w, `{"error": "cancelling job.."}`)
+	fmt.Fprintf(w, `{"success": "cancelling job.."}`)
+}
+
+func (h *httpHandler) RollbackJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	service := p.ByName("service")
+	ns := r.Header.Get("X-Namespace")
+
+	ctx := r.Context()
+
+	if ns == "" {
+		writeError(w, badRequest("Namespace is required"), "")
+		return
+	}
+
+	limitR := http.MaxBytesReader(w, r.Body, 1000000)
+	payload, err := io.ReadAll(limitR)
+	if err != nil {
+		writeError(w, badRequest("failed to parse data"), "")
+		return
+	}
+
+	// body is optional; only to override timeout / believe / agent
+	var request deployjob.RollbackJobRequest
+	if len(payload) > 0 {
+		err = json.Unmarshal(payload, &request)
+		if err != nil {
+			writeError(w, badRequest("failed to parse data"), "")
+			return
+		}
+	}
+
+	request.Ns = ns
+	request.Service = service
+	request.CreatedAt = time.Now()
+
+	result, err := h.dependencies.RaftJobUsecase.RollbackJob(ctx, request)
+	if err != nil {
+		writeError(w, err, "failed to submit rollback job")
+		return
+	}
+
+	fmt.Fprintf(w, `{"success": "rollback job submitted with id: %v, rollback to job %v (%v)"}`,
+		result.Job.Id, result.Job.Request.RollbackOf, result.SubmitJobStatus)
+}
+
+func (h *httpHandler) RetryHost(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	ns := r.Header.Get("X-Namespace")
+
+	ctx := r.Context()
+
+	if ns == "" {
+		writeError(w, badRequest("Namespace is required"), "")
+		return
+	}
+
+	result, err := h.dependencies.RaftJobUsecase.RetryHost(ctx, deployjob.RetryHostRequest{
+		Ns:        ns,
+		JobId:     p.ByName("id"),
+		Service:   p.ByName("service"),
+		HostName:  p.ByName("host"),
+		Agent:     r.Header.Get("X-Agent"),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		writeError(w, err, "failed to retry host")
+		return
+	}
+
+	fmt.Fprintf(w, `{"success": "retrying %v of host %v"}`, result.Phase, result.TargetHost)
+}
+
+func (h *httpHandler) RemoveService(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	ns := r.Header.Get("X-Namespace")
+
+	ctx := r.Context()
+
+	if ns == "" {
+		writeError(w, badRequest("Namespace is required"), "")
+		return
+	}
+
+	result, err := h.dependencies.RaftJobUsecase.RemoveService(ctx, deployjob.RemoveServiceRequest{
+		Ns:        ns,
+		Service:   p.ByName("service"),
+		Agent:     r.Header.Get("X-Agent"),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		writeError(w, err, "failed to remove service")
+		return
+	}
+
+	fmt.Fprintf(w, `{"success": "service %v removed from %v host(s)"}`, result.Service, len(result.Instances))
+}
+
+// auditResponse is a page of the audit trail, latest first
+type auditResponse struct {
+	Success []*entity.JobAudit `json:"success"`
+
+	// the storage only returns this many of the latest entries
+	Limit int `json:"limit"`
+
+	// there might be older entries than returned
+	Truncated bool `json:"truncated"`
+
+	// per job; the "before" param to get the older entries
+	Before string `json:"before,omitempty"`
+}
+
+// JobAudit returns the audit trail, latest first. Per job: ?service=<service>&job_id=<id>, per service: ?service=<service>,
+// per namespace: without params. Only the latest entries are returned (see auditResponse);
+// the audit ID is incremental per job, so the older entries of a job are paged with ?before=<id>.
+func (h *httpHandler) JobAudit(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	ns := r.Header.Get("X-Namespace")
+	query := r.URL.Query()
+	service, jobID := query.Get("service"), query.Get("job_id")
+
+	ctx := r.Context()
+
+	if ns == "" {
+		writeError(w, badRequest("Namespace is required"), "")
+		return
+	}
+
+	if jobID != "" && service == "" {
+		writeError(w, badRequest("job_id requires service"), "")
+		return
+	}
+
+	limit := deployjob.JobAuditGetLimit
+	if param := query.Get("limit"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil || value <= 0 || value > deployjob.JobAuditGetLimit {
+			writeError(w, badRequest(fmt.Sprintf("limit must be between 1 and %v", deployjob.JobAuditGetLimit)), "")
+			return
+		}
+		limit = value
+	}
+
+	var refIDs []string
+	if service != "" {
+		refIDs = append(refIDs, service)
+	}
+	if jobID != "" {
+		refIDs = append(refIDs, jobID)
+	}
+
+	resp := auditResponse{Limit: limit}
+
+	if param := query.Get("before"); param != "" {
+		before, err := strconv.ParseUint(param, 10, 64)
+		if err != nil || jobID == "" {
+			writeError(w, badRequest("before must be an audit ID of the job (service & job_id)"), "")
+			return
+		}
+
+		for id := before; id > 0 && len(resp.Success) < limit; id-- {
+			entries, err := h.dependencies.JobAuditUsecase.Get(ctx, ns, refIDs, strconv.FormatUint(id-1, 10))
+			if err != nil && !errors.Is(err, mycontent.ErrNotFound) {
+				writeError(w, err, "failed to get audit")
+				return
+			}
+			resp.Success = append(resp.Success, entries...)
+		}
+	} else {
+		entries, err := h.dependencies.JobAuditUsecase.Get(ctx, ns, refIDs, "")
+		if err != nil && !errors.Is(err, mycontent.ErrNotFound) {
+			writeError(w, err, "failed to get audit")
+			return
+		}
+		resp.Truncated = len(entries) >= deployjob.JobAuditGetLimit || len(entries) > limit
+		resp.Success = entries[:min(len(entries), limit)]
+	}
+
+	if jobID != "" && len(resp.Success) > 0 {
+		last := resp.Success[len(resp.Success)-1].Id
+		if last != "0" {
+			resp.Truncated = true
+			resp.Before = last
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
+// ConfirmDeployment approve the restart of a CONFIGURED job, or continue the next batch of a DEPLOYING job.
+// The rollout only starts once the job has enough distinct approvers (X-Agent), other than the submitter.
+// deployd has no authentication of its own: X-Agent is trusted as-is, so it should be set by an authenticating proxy.
 func (h *httpHandler) ConfirmDeployment(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	fmt.Fprintf(w, "deployment confirmed")
+	service := p.ByName("service")
+	jobID := p.ByName("id")
+	ns := r.Header.Get("X-Namespace")
+	agent := r.Header.Get("X-Agent")
+
+	if ns == "" {
+		writeError(w, badRequest("Namespace is required"), "")
+		return
+	}
+
+	if agent == "" {
+		writeError(w, badRequest("X-Agent header is required; it is the approver"), "")
+		return
+	}
+
+	result, err := h.dependencies.RaftJobUsecase.ConfirmRestartService(r.Context(), deployjob.RestartConfirmation{
+		Ns:        ns,
+		JobId:     jobID,
+		Service:   service,
+		Message:   r.URL.Query().Get("message"),
+		Agent:     agent,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		writeError(w, err, "failed to confirm deployment")
+		return
+	}
+
+	message := result.Message
+	if message == "" {
+		message = fmt.Sprintf("restarting %v", result.TargetHosts)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(successResponse{Success: message, Status: result.Job.Status})
+}
+
+// FreezeNamespace stops new jobs & restarts of the namespace; eg. ?reason=end-of-year&until=2026-01-02T00:00:00Z
+// Without "until", the freeze lasts until unfrozen.
+func (h *httpHandler) FreezeNamespace(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	ns := r.Header.Get("X-Namespace")
+
+	if ns == "" {
+		writeError(w, badRequest("Namespace is required"), "")
+		return
+	}
+
+	var until *time.Time
+	if param := r.URL.Query().Get("until"); param != "" {
+		t, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			writeError(w, badRequest("until must be in RFC3339 format"), "")
+			return
+		}
+		until = &t
+	}
+
+	result, err := h.dependencies.RaftJobUsecase.Freeze(r.Context(), deployjob.FreezeRequest{
+		Ns:        ns,
+		Reason:    r.URL.Query().Get("reason"),
+		Until:     until,
+		Agent:     r.Header.Get("X-Agent"),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		writeError(w, err, "failed to freeze namespace")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(successResponse{Success: fmt.Sprintf("namespace %v is frozen (%v)", result.Ns, result.Reason)})
+}
+
+func (h *httpHandler) UnfreezeNamespace(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	ns := r.Header.Get("X-Namespace")
+
+	if ns == "" {
+		writeError(w, badRequest("Namespace is required"), "")
+		return
+	}
+
+	result, err := h.dependencies.RaftJobUsecase.Unfreeze(r.Context(), deployjob.UnfreezeRequest{
+		Ns:        ns,
+		Agent:     r.Header.Get("X-Agent"),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		writeError(w, err, "failed to unfreeze namespace")
+		return
+	}
+
+	fmt.Fprintf(w, `{"success": "namespace %v is unfrozen"}`, result.Ns)
 }
 
 func (h *httpHandler) StreamLog(topic notifier.Topic) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		jobParam := p.ByName("active-job")
-		_, ok := h.jobsController.deploymentJobPool[jobParam]
+		_, ok := h.jobsController.getJob(jobParam)
 		if !ok {
-			// todo: 404 not found
-			fmt.Fprintf(w, `{"error": "active job not found"}`)
+			writeError(w, &deployjob.Error{Code: deployjob.ErrorCodeNotFound, Message: "active job not found"}, "")
 			return
 		}
 
@@ -156,3 +481,51 @@ func (h *httpHandler) StreamLog(topic notifier.Topic) httprouter.Handle {
 
 	}
 }
+
+// successResponse is the envelope of the command result; encoded, because the message can contain user input
+type successResponse struct {
+	Success string                     `json:"success"`
+	Status  entity.DeploymentJobStatus `json:"status,omitempty"`
+}
+
+// errorResponse is the error envelope of the http interface; "code" is stable for tooling
+type errorResponse struct {
+	Error string              `json:"error"`
+	Code  deployjob.ErrorCode `json:"code"`
+}
+
+// writeError write the error envelope with the status code based on the error kind.
+// Error that is not *deployjob.Error is treated as internal error.
+func writeError(w http.ResponseWriter, err error, message string) {
+	var appErr *deployjob.Error
+	if !errors.As(err, &appErr) {
+		appErr = &deployjob.Error{Code: deployjob.ErrorCodeUnknown, Message: err.Error()}
+	}
+
+	status := http.StatusInternalServerError
+	switch appErr.Code {
+	case deployjob.ErrorCodeNotFound:
+		status = http.StatusNotFound
+	case deployjob.ErrorCodeInvalidState, deployjob.ErrorCodeConflict:
+		status = http.StatusConflict
+	case deployjob.ErrorCodeValidation:
+		status = http.StatusBadRequest
+	case deployjob.ErrorCodeNotLeader:
+		status = http.StatusServiceUnavailable
+	case deployjob.ErrorCodeFrozen:
+		status = http.StatusLocked
+	}
+
+	resp := errorResponse{Error: appErr.Message, Code: appErr.Code}
+	if message != "" {
+		resp.Error = message + ": " + appErr.Message
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func badRequest(message string) error {
+	return &deployjob.Error{Code: deployjob.ErrorCodeValidation, Message: message}
+}
@@ -0,0 +1,165 @@
+package deployjob
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	raft_runner "github.com/desain-gratis/common/lib/raft/runner"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+const leaderCheckInterval = 10 * time.Second
+
+// leaderHandler contains process that only run on the deploy-job raft leader.
+// The state machine cannot use a clock, so anything time based (eg. timeout, canary promotion) is decided here
+// and then proposed to raft.
+type leaderHandler struct {
+	jobsController *jobsController
+	dependencies   *Dependencies
+	log            *slog.Logger
+}
+
+// StartWatcher exposed to main program.
+// ctx must be the deploy-job raft replica context (the one returned by raft_runner.RunReplica).
+func (l *leaderHandler) StartWatcher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(leaderCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if !isLeader(ctx) {
+				continue
+			}
+
+			l.checkJobs(ctx)
+		}
+	}()
+}
+
+func (l *leaderHandler) checkJobs(ctx context.Context) {
+	services, err := l.dependencies.ServiceDefinitionUsecase.Get(ctx, "*", nil, "")
+	if err != nil {
+		l.log.Warn("failed to get service definition", "error", err)
+		return
+	}
+
+	now := time.Now()
+
+	for _, service := range services {
+		// the latest jobs of the service are enough; old one should already be finished
+		jobs, err := l.dependencies.JobUsecase.Get(ctx, service.Ns, []string{service.Id}, "")
+		if err != nil {
+			l.log.Warn("failed to get service jobs", "namespace", service.Ns, "service", service.Id, "error", err)
+			continue
+		}
+
+		for _, job := range jobs {
+			l.checkJob(ctx, job, now)
+		}
+	}
+}
+
+func (l *leaderHandler) checkJob(ctx context.Context, job *entity.DeploymentJob, now time.Time) {
+	if job.Status.IsTerminal() {
+		return
+	}
+
+	if job.Deadline != nil && !now.Before(*job.Deadline) {
+		l.timeoutJob(ctx, job, now)
+		return
+	}
+
+	// restart confirmation held by the deploy window
+	if job.PendingSchedule != nil {
+		if !now.Before(job.PendingSchedule.OpenAt) {
+			l.releaseSchedule(ctx, job, now)
+		}
+		return
+	}
+
+	if job.Status == entity.DeploymentJobStatusCanary && canAutoPromote(job, now) {
+		l.promoteCanary(ctx, job, now)
+	}
+}
+
+func canAutoPromote(job *entity.DeploymentJob, now time.Time) bool {
+	strategy := job.Request.Strategy
+	if strategy == nil || strategy.Canary == nil || !strategy.Canary.AutoPromote {
+		return false
+	}
+
+	return job.Deployment.BakeUntil != nil && !now.Before(*job.Deployment.BakeUntil)
+}
+
+func (l *leaderHandler) promoteCanary(ctx context.Context, job *entity.DeploymentJob, now time.Time) {
+	log := l.log.With("namespace", job.Ns, "service", job.Request.Service.Id, "job_id", job.Id)
+
+	log.Info("canary bake finished; promoting", "bake_until", job.Deployment.BakeUntil)
+	_, err := l.dependencies.RaftJobUsecase.ConfirmRestartService(ctx, deployjob.RestartConfirmation{
+		Ns:        job.Ns,
+		JobId:     job.Id,
+		Service:   job.Request.Service.Id,
+		Message:   "canary promoted",
+		Agent:     "deployd-leader:" + l.jobsController.host.Host,
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Warn("failed to promote canary", "error", err)
+	}
+}
+
+func (l *leaderHandler) releaseSchedule(ctx context.Context, job *entity.DeploymentJob, now time.Time) {
+	log := l.log.With("namespace", job.Ns, "service", job.Request.Service.Id, "job_id", job.Id)
+
+	log.Info("deploy window opened; confirming held restart", "open_at", job.PendingSchedule.OpenAt, "confirmed_by", job.PendingSchedule.ConfirmedBy)
+	_, err := l.dependencies.RaftJobUsecase.ConfirmRestartService(ctx, deployjob.RestartConfirmation{
+		Ns:        job.Ns,
+		JobId:     job.Id,
+		Service:   job.Request.Service.Id,
+		Message:   "deploy window opened",
+		Agent:     "deployd-leader:" + l.jobsController.host.Host,
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Warn("failed to confirm held restart", "error", err)
+	}
+}
+
+func (l *leaderHandler) timeoutJob(ctx context.Context, job *entity.DeploymentJob, now time.Time) {
+	log := l.log.With("namespace", job.Ns, "service", job.Request.Service.Id, "job_id", job.Id)
+
+	log.Info("job deadline passed; proposing timeout", "status", job.Status, "deadline", job.Deadline)
+	_, err := l.dependencies.RaftJobUsecase.TimeoutJob(ctx, deployjob.JobTimeoutRequest{
+		Ns:        job.Ns,
+		JobId:     job.Id,
+		Service:   job.Request.Service.Id,
+		Agent:     "deployd-leader:" + l.jobsController.host.Host,
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Warn("failed to time out job", "error", err)
+	}
+}
+
+func isLeader(ctx context.Context) bool {
+	raftCtx, err := raft_runner.GetRaftContext(ctx)
+	if err != nil {
+		return false
+	}
+
+	leaderID, _, valid, err := raftCtx.DHost.GetLeaderID(raftCtx.ShardID)
+	if err != nil || !valid {
+		return false
+	}
+
+	return leaderID == raftCtx.ReplicaID
+}
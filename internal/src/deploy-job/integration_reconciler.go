@@ -0,0 +1,283 @@
+package deployjob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// used when the reconciler interval is not configured
+const defaultReconcileInterval = time.Minute
+
+type ReconcilerConfig struct {
+	Interval time.Duration
+
+	// bring the host back to the latest deployment; otherwise the drift is only reported
+	Repair bool
+}
+
+// reconcilerHandler periodically compare the latest deployment of each service on this host
+// with the filesystem & systemd, and report the drift to the cluster.
+type reconcilerHandler struct {
+	jobsController *jobsController
+	dependencies   *Dependencies
+	log            *slog.Logger
+}
+
+// Start exposed to main program
+func (r *reconcilerHandler) Start(ctx context.Context, config ReconcilerConfig) {
+	if config.Interval <= 0 {
+		config.Interval = defaultReconcileInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			r.reconcile(ctx, config.Repair)
+		}
+	}()
+}
+
+func (r *reconcilerHandler) reconcile(ctx context.Context, repair bool) {
+	host := r.jobsController.host
+
+	services, err := r.dependencies.ServiceDefinitionUsecase.Get(ctx, "*", nil, "")
+	if err != nil {
+		r.log.Warn("failed to get service definition", "error", err)
+		return
+	}
+
+	for _, service := range services {
+		instances, err := r.dependencies.ServiceDeploymentUsecase.Get(ctx, service.Ns, []string{service.Id}, "")
+		if err != nil {
+			r.log.Warn("failed to get service instance", "namespace", service.Ns, "service", service.Id, "error", err)
+			continue
+		}
+
+		for _, instance := range instances {
+			if instance.Host == host.Host {
+				r.reconcileInstance(ctx, instance, repair)
+			}
+		}
+	}
+}
+
+func (r *reconcilerHandler) reconcileInstance(ctx context.Context, instance *entity.ServiceInstanceHost, repair bool) {
+	// a job is working on the host; the host is expected to be different
+	if instance.ActiveDeployment != nil || instance.LatestDeployment == nil || instance.LatestDeployment.DeploymentJob == nil {
+		return
+	}
+
+	// the job might not be reported to raft yet, or is still acknowledging a cancellation
+	if r.jobsController.hasServiceJob(instance.Ns, instance.Service) {
+		return
+	}
+
+	// a job is restarting, rolling back, decommissioning or cleaning up the service; check again next time
+	lock := r.jobsController.getServiceLock(instance.Ns, instance.Service)
+	if !lock.TryLock() {
+		return
+	}
+	defer lock.Unlock()
+
+	log := r.log.With("namespace", instance.Ns, "service", instance.Service)
+	request := instance.LatestDeployment.Request
+
+	items, err := checkDrift(ctx, request)
+	if err != nil {
+		log.Warn("failed to check drift", "error", err)
+		return
+	}
+
+	repaired := false
+	if len(items) > 0 && repair {
+		err = repairDrift(ctx, request, items)
+		if err != nil {
+			log.Warn("failed to repair drift", "drift", items, "error", err)
+		} else {
+			log.Info("drift repaired", "drift", items)
+			repaired = true
+		}
+	}
+
+	// only report the change
+	if !repaired && sameDrift(instance.Drift, items) {
+		return
+	}
+
+	_, err = r.dependencies.RaftJobUsecase.FeedHostDriftUpdate(ctx, deployjob.DriftUpdateRequest{
+		Ns:        instance.Ns,
+		Service:   instance.Service,
+		HostName:  instance.Host,
+		Items:     items,
+		Repaired:  repaired,
+		CheckedAt: time.Now(),
+	})
+	if err != nil {
+		log.Warn("failed to report drift to manager", "drift", items, "error", err)
+	}
+}
+
+// checkDrift compare the host with the deployed request
+func checkDrift(ctx context.Context, request entity.SubmitDeploymentJobRequest) ([]entity.DriftItem, error) {
+	serviceName := fmt.Sprintf("%v_%v", request.Ns, request.Service.Id)
+	basePath := filepath.Join("/opt", serviceName)
+
+	var items []entity.DriftItem
+
+	unit := BuildUnit(request.Ns, request.Service.Id, request.Service.Description, request.Service.ExecutablePath)
+	installed, err := os.ReadFile(filepath.Join("/etc/systemd/system", serviceName+".service"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if string(installed) != unit {
+		actual := "modified"
+		if len(installed) == 0 {
+			actual = "missing"
+		}
+		items = append(items, entity.DriftItem{Kind: entity.DriftKindUnit, Expected: "rendered unit", Actual: actual})
+	}
+
+	buildVersion := strconv.FormatUint(request.BuildVersion, 10)
+	if linked := linkedVersion(filepath.Join(basePath, "current")); linked != buildVersion {
+		items = append(items, entity.DriftItem{Kind: entity.DriftKindBuildLink, Expected: buildVersion, Actual: linked})
+	}
+
+	envVersion := strconv.FormatUint(request.EnvVersion, 10)
+	if linked := linkedVersion(filepath.Join("/etc", serviceName, "env")); linked != envVersion {
+		items = append(items, entity.DriftItem{Kind: entity.DriftKindEnvLink, Expected: envVersion, Actual: linked})
+	}
+
+	activeState, err := unitActiveState(ctx, serviceName+".service")
+	if err != nil {
+		return nil, err
+	}
+	if activeState != "active" {
+		items = append(items, entity.DriftItem{Kind: entity.DriftKindServiceState, Expected: "active", Actual: activeState})
+	}
+
+	return items, nil
+}
+
+// repairDrift write back the unit & symlinks, then (re)start the service
+func repairDrift(ctx context.Context, request entity.SubmitDeploymentJobRequest, items []entity.DriftItem) error {
+	serviceName := fmt.Sprintf("%v_%v", request.Ns, request.Service.Id)
+	basePath := filepath.Join("/opt", serviceName)
+	unitName := serviceName + ".service"
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	restart := false
+	for _, item := range items {
+		switch item.Kind {
+		case entity.DriftKindUnit:
+			unit := BuildUnit(request.Ns, request.Service.Id, request.Service.Description, request.Service.ExecutablePath)
+			final := filepath.Join("/etc/systemd/system", unitName)
+			if err := os.WriteFile(final+".tmp", []byte(unit), 0644); err != nil {
+				return err
+			}
+			if err := os.Rename(final+".tmp", final); err != nil {
+				return err
+			}
+			if err := conn.ReloadContext(ctx); err != nil {
+				return fmt.Errorf("failed to reload systemd: %w", err)
+			}
+			restart = true
+		case entity.DriftKindBuildLink:
+			// the release might be pruned already; then only a new deployment can fix it
+			releaseDir := filepath.Join(basePath, "build-release", item.Expected)
+			if _, err := os.Stat(releaseDir); err != nil {
+				return fmt.Errorf("release %v is not available: %w", item.Expected, err)
+			}
+			if err := switchSymlinkAtomic(filepath.Join(basePath, "current"), releaseDir); err != nil {
+				return err
+			}
+			restart = true
+		case entity.DriftKindEnvLink:
+			envReleaseDir := filepath.Join(basePath, "env-release", item.Expected)
+			if _, err := os.Stat(envReleaseDir); err != nil {
+				return fmt.Errorf("env release %v is not available: %w", item.Expected, err)
+			}
+			if err := switchSymlinkAtomic(filepath.Join("/etc", serviceName, "env"), envReleaseDir); err != nil {
+				return err
+			}
+			restart = true
+		case entity.DriftKindServiceState:
+			restart = true
+		}
+	}
+
+	if !restart {
+		return nil
+	}
+
+	if err := stopService(ctx, conn, unitName); err != nil {
+		return err
+	}
+	if err := startService(ctx, conn, unitName); err != nil {
+		return err
+	}
+
+	active, err := isActive(ctx, conn, unitName)
+	if err != nil {
+		return err
+	}
+	if !active {
+		return fmt.Errorf("service %v is not active after repaired", unitName)
+	}
+
+	return nil
+}
+
+func unitActiveState(ctx context.Context, unitName string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	props, err := conn.GetUnitPropertiesContext(ctx, unitName)
+	if err != nil {
+		return "", err
+	}
+
+	activeState, _ := props["ActiveState"].(string)
+	return activeState, nil
+}
+
+// sameDrift returns true if the reported drift is the same as the found one
+func sameDrift(reported *entity.HostDrift, items []entity.DriftItem) bool {
+	if reported == nil {
+		return len(items) == 0
+	}
+	return slices.Equal(reported.Items, items)
+}
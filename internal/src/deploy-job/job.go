@@ -9,6 +9,7 @@ const (
 	StatusFailed     Status = "failed"
 	StatusCancelled  Status = "cancelled"
 	StatusSuccess    Status = "success"
+	StatusSkipped    Status = "skipped" // nothing to do (eg. already installed)
 	StatusInvalid    Status = "invalid"
 )
 
@@ -31,6 +32,6 @@ type DAG struct {
 	// Vertices contains job
 	Vertices []Job `json:"vertices"`
 
-	// Edges contain vertices index; always len(Edges) == len(Vertices)*2
+	// Edges contain pairs of vertices index (from, to); "to" only run after "from" is finished
 	Edges []uint8 `json:"edges"`
 }
@@ -0,0 +1,152 @@
+package deployjob
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	common_entity "github.com/desain-gratis/common/types/entity"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// ArtifactPolicy is the artifact verification of a namespace
+type ArtifactPolicy struct {
+	// refuse to install the artifact without a valid signature
+	RequireSignature bool
+
+	// base64 ed25519 public keys; the artifact is valid if signed by one of them
+	PublicKeys []string
+}
+
+// verifyArtifact check the downloaded archive against the digest (and signature) recorded by artifactd
+func (a *configureHost) verifyArtifact(_ context.Context) error {
+	installed, err := a.isInstalled()
+	if err != nil {
+		return err
+	}
+	if installed {
+		return errSkipStep
+	}
+
+	policy := a.artifactPolicy
+	meta := a.artifact
+	if meta == nil {
+		return fmt.Errorf("artifact metadata is not available; download first")
+	}
+
+	if meta.Hash == "" {
+		if policy.RequireSignature {
+			return fmt.Errorf("artifact %v has no digest; namespace %v require signed artifact", meta.Id, a.Job.Ns)
+		}
+		a.log.Warn("artifact has no digest; uploaded before digest is recorded. skipping verification", "artifact", meta.Id)
+		return nil
+	}
+
+	a.log.Info("verifying artifact digest", "digest", meta.Hash)
+
+	digest, err := fileDigest(a.artifactPath())
+	if err != nil {
+		return fmt.Errorf("error while computing artifact digest: %w", err)
+	}
+
+	if digest != meta.Hash {
+		// don't reuse it on retry
+		_ = os.Remove(a.artifactPath())
+		return fmt.Errorf("artifact digest not matching! expected %v got %v", meta.Hash, digest)
+	}
+
+	signature := entity.ArchiveSignature(meta.Tags)
+	if signature == "" {
+		if policy.RequireSignature {
+			return fmt.Errorf("artifact %v is not signed; namespace %v require signed artifact", meta.Id, a.Job.Ns)
+		}
+		return nil
+	}
+
+	if len(policy.PublicKeys) == 0 {
+		if policy.RequireSignature {
+			return fmt.Errorf("no public key configured to verify artifact signature for namespace %v", a.Job.Ns)
+		}
+		a.log.Warn("artifact is signed but no public key configured; skipping signature verification", "artifact", meta.Id)
+		return nil
+	}
+
+	a.log.Info("verifying artifact signature")
+
+	if err := verifySignature(policy.PublicKeys, digest, signature); err != nil {
+		return fmt.Errorf("artifact %v: %w", meta.Id, err)
+	}
+
+	return nil
+}
+
+// resumeOffset returns the size of the partial download to continue from; 0 to start over.
+// only resumed if the blob reader can seek (range request) and the result can be verified by the digest.
+func (a *configureHost) resumeOffset(meta *common_entity.Attachment, reader io.Reader) int64 {
+	size := fileSize(a.partialArtifactPath())
+	if size <= 0 || size >= int64(meta.ContentSize) || meta.Hash == "" {
+		return 0
+	}
+
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return 0
+	}
+
+	if _, err := seeker.Seek(size, io.SeekStart); err != nil {
+		a.log.Warn("blob storage can't resume the download; starting over", "error", err)
+		return 0
+	}
+
+	return size
+}
+
+// fileSize returns -1 if the file is not exist
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// verifySignature returns nil if the digest is signed by one of the keys
+func verifySignature(publicKeys []string, digest string, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	for idx, key := range publicKeys {
+		publicKey, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid public key at index %v; expected base64 ed25519 public key", idx)
+		}
+
+		if ed25519.Verify(publicKey, []byte(digest), sig) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("signature is not valid for any of the configured public keys")
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return entity.ArchiveDigest(hash.Sum(nil)), nil
+}
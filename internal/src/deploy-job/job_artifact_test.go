@@ -0,0 +1,348 @@
+package deployjob
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	common_entity "github.com/desain-gratis/common/types/entity"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+var testArchive = []byte("release archive")
+
+// newTestConfigureHost returns a configure job with the install & download dir inside a temporary dir
+func newTestConfigureHost(t *testing.T, policy ArtifactPolicy) *configureHost {
+	t.Helper()
+
+	root := t.TempDir()
+	previousInstallDir, previousDownloadDir := installDir, downloadDir
+	installDir, downloadDir = filepath.Join(root, "opt"), filepath.Join(root, "tmp")
+	t.Cleanup(func() { installDir, downloadDir = previousInstallDir, previousDownloadDir })
+
+	log := slog.New(slog.DiscardHandler)
+	a := &configureHost{
+		deploymentJob: &deploymentJob{
+			jobBase:        &jobBase{Status: StatusPending},
+			log:            log,
+			artifactPolicy: policy,
+			Job: entity.DeploymentJob{
+				Ns: "test",
+				Request: entity.SubmitDeploymentJobRequest{
+					Ns:           "test",
+					Service:      entity.ServiceDefinition{Id: "svc"},
+					BuildVersion: 7,
+				},
+			},
+		},
+		ctx: context.Background(),
+		log: log,
+	}
+	a.progress = newProgressReporter(progressReportInterval, func(entity.HostProgress) {})
+	t.Cleanup(func() { a.progress.stop() })
+
+	for _, dir := range []string{a.buildReleasePath(), a.tmpPath()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create %v: %v", dir, err)
+		}
+	}
+
+	return a
+}
+
+// withArtifact write the downloaded archive & set the metadata recorded by artifactd
+func (a *configureHost) withArtifact(t *testing.T, payload []byte, hash string, tags ...string) *configureHost {
+	t.Helper()
+
+	if err := os.WriteFile(a.artifactPath(), payload, 0644); err != nil {
+		t.Fatalf("failed to write artifact: %v", err)
+	}
+	a.artifact = &common_entity.Attachment{Id: "archive", Hash: hash, Tags: tags, ContentSize: uint64(len(payload))}
+	return a
+}
+
+func digestOf(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return entity.ArchiveDigest(sum[:])
+}
+
+func newKey(t *testing.T) (string, ed25519.PrivateKey) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(public), private
+}
+
+func signatureTag(private ed25519.PrivateKey, digest string) string {
+	return entity.ArchiveSignaturePrefix + base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte(digest)))
+}
+
+func TestVerifyArtifact(t *testing.T) {
+	publicKey, privateKey := newKey(t)
+	otherKey, otherPrivateKey := newKey(t)
+	digest := digestOf(testArchive)
+
+	tests := []struct {
+		name      string
+		policy    ArtifactPolicy
+		hash      string
+		tags      []string
+		expectErr bool
+	}{
+		{name: "digest matching", hash: digest},
+		{name: "digest mismatch", hash: digestOf([]byte("other archive")), expectErr: true},
+		{name: "no digest", hash: ""},
+		{name: "no digest but signature required", policy: ArtifactPolicy{RequireSignature: true}, hash: "", expectErr: true},
+		{name: "not signed but signature required", policy: ArtifactPolicy{RequireSignature: true, PublicKeys: []string{publicKey}}, hash: digest, expectErr: true},
+		{name: "signed", policy: ArtifactPolicy{RequireSignature: true, PublicKeys: []string{otherKey, publicKey}}, hash: digest, tags: []string{signatureTag(privateKey, digest)}},
+		{name: "signed by unknown key", policy: ArtifactPolicy{PublicKeys: []string{publicKey}}, hash: digest, tags: []string{signatureTag(otherPrivateKey, digest)}, expectErr: true},
+		{name: "invalid signature", policy: ArtifactPolicy{PublicKeys: []string{publicKey}}, hash: digest, tags: []string{entity.ArchiveSignaturePrefix + "not-base64!"}, expectErr: true},
+		{name: "signed without key", hash: digest, tags: []string{signatureTag(privateKey, digest)}},
+		{name: "signature required without key", policy: ArtifactPolicy{RequireSignature: true}, hash: digest, tags: []string{signatureTag(privateKey, digest)}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestConfigureHost(t, tt.policy).withArtifact(t, testArchive, tt.hash, tt.tags...)
+
+			err := a.verifyArtifact(context.Background())
+			if tt.expectErr && err == nil {
+				t.Fatal("expected an error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyArtifactMismatchRemoveDownload(t *testing.T) {
+	a := newTestConfigureHost(t, ArtifactPolicy{}).withArtifact(t, testArchive, digestOf([]byte("other archive")))
+
+	if err := a.verifyArtifact(context.Background()); err == nil {
+		t.Fatal("expected digest mismatch error")
+	}
+
+	// must be downloaded again on retry
+	if _, err := os.Stat(a.artifactPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the corrupted artifact removed, got %v", err)
+	}
+}
+
+func TestVerifyArtifactSkip(t *testing.T) {
+	a := newTestConfigureHost(t, ArtifactPolicy{RequireSignature: true})
+
+	if err := a.verifyArtifact(context.Background()); err == nil {
+		t.Fatal("expected an error without downloaded artifact metadata")
+	}
+
+	// already installed; nothing to verify
+	if err := os.WriteFile(filepath.Join(a.buildReleasePath(), "app"), []byte("app"), 0755); err != nil {
+		t.Fatalf("failed to install: %v", err)
+	}
+	if err := a.verifyArtifact(context.Background()); !errors.Is(err, errSkipStep) {
+		t.Fatalf("expected step skipped, got %v", err)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	publicKey, privateKey := newKey(t)
+	digest := digestOf(testArchive)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(digest)))
+
+	if err := verifySignature([]string{publicKey}, digest, signature); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := verifySignature([]string{publicKey}, digestOf([]byte("other archive")), signature); err == nil {
+		t.Fatal("expected error for a signature of another digest")
+	}
+	if err := verifySignature([]string{publicKey}, digest, "not-base64!"); err == nil {
+		t.Fatal("expected error for an invalid signature encoding")
+	}
+	if err := verifySignature([]string{"not-a-key"}, digest, signature); err == nil {
+		t.Fatal("expected error for an invalid public key")
+	}
+	if err := verifySignature(nil, digest, signature); err == nil {
+		t.Fatal("expected error without public key")
+	}
+}
+
+func TestFileDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "release.tar.gz")
+	if err := os.WriteFile(path, testArchive, 0644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	digest, err := fileDigest(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if digest != digestOf(testArchive) {
+		t.Fatalf("expected %v, got %v", digestOf(testArchive), digest)
+	}
+
+	if _, err := fileDigest(path + ".missing"); err == nil {
+		t.Fatal("expected error for a missing file")
+	}
+}
+
+// seekableReader is a blob reader that support range request
+type seekableReader struct {
+	*strings.Reader
+	seekErr  error
+	seekedTo int64
+}
+
+func newSeekableReader(data string) *seekableReader {
+	return &seekableReader{Reader: strings.NewReader(data), seekedTo: -1}
+}
+
+func (r *seekableReader) Seek(offset int64, whence int) (int64, error) {
+	if r.seekErr != nil {
+		return 0, r.seekErr
+	}
+	r.seekedTo = offset
+	return r.Reader.Seek(offset, whence)
+}
+
+// failingReader fail the test if the archive is downloaded
+type failingReader struct {
+	t *testing.T
+}
+
+func (r failingReader) Read([]byte) (int, error) {
+	r.t.Fatal("artifact is downloaded again")
+	return 0, io.EOF
+}
+
+func (a *configureHost) withPartialArtifact(t *testing.T, payload string) *configureHost {
+	t.Helper()
+
+	if err := os.WriteFile(a.partialArtifactPath(), []byte(payload), 0644); err != nil {
+		t.Fatalf("failed to write partial artifact: %v", err)
+	}
+	return a
+}
+
+func archiveMeta(hash string) *common_entity.Attachment {
+	return &common_entity.Attachment{Id: "archive", Hash: hash, ContentSize: uint64(len(testArchive))}
+}
+
+func TestResumeOffset(t *testing.T) {
+	digest := digestOf(testArchive)
+
+	tests := []struct {
+		name     string
+		partial  string
+		meta     *common_entity.Attachment
+		reader   io.Reader
+		expected int64
+	}{
+		{name: "partial download", partial: "release", meta: archiveMeta(digest), reader: newSeekableReader(string(testArchive)), expected: 7},
+		{name: "no partial download", meta: archiveMeta(digest), reader: newSeekableReader(string(testArchive)), expected: 0},
+		{name: "partial as big as the archive", partial: string(testArchive), meta: archiveMeta(digest), reader: newSeekableReader(string(testArchive)), expected: 0},
+		{name: "no digest to verify the result", partial: "release", meta: archiveMeta(""), reader: newSeekableReader(string(testArchive)), expected: 0},
+		{name: "not seekable", partial: "release", meta: archiveMeta(digest), reader: struct{ io.Reader }{strings.NewReader(string(testArchive))}, expected: 0},
+		{name: "seek failed", partial: "release", meta: archiveMeta(digest), reader: &seekableReader{Reader: strings.NewReader(string(testArchive)), seekErr: errors.New("range not supported")}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestConfigureHost(t, ArtifactPolicy{})
+			if tt.partial != "" {
+				a.withPartialArtifact(t, tt.partial)
+			}
+
+			if offset := a.resumeOffset(tt.meta, tt.reader); offset != tt.expected {
+				t.Fatalf("expected offset %v, got %v", tt.expected, offset)
+			}
+		})
+	}
+}
+
+// expectArtifact check the complete download, and that the partial one is gone
+func expectArtifact(t *testing.T, a *configureHost) {
+	t.Helper()
+
+	data, err := os.ReadFile(a.artifactPath())
+	if err != nil {
+		t.Fatalf("failed to read artifact: %v", err)
+	}
+	if string(data) != string(testArchive) {
+		t.Fatalf("expected artifact %q, got %q", testArchive, data)
+	}
+	if _, err := os.Stat(a.partialArtifactPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the partial artifact renamed, got %v", err)
+	}
+}
+
+func TestWriteArtifactResume(t *testing.T) {
+	a := newTestConfigureHost(t, ArtifactPolicy{}).withPartialArtifact(t, "release")
+	reader := newSeekableReader(string(testArchive))
+
+	if err := a.writeArtifact(context.Background(), archiveMeta(digestOf(testArchive)), reader); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if reader.seekedTo != 7 {
+		t.Fatalf("expected the download resumed from 7, got %v", reader.seekedTo)
+	}
+	expectArtifact(t, a)
+
+	if progress := a.progress.stop(); progress.BytesDownloaded != uint64(len(testArchive)) {
+		t.Fatalf("expected %v bytes downloaded, got %v", len(testArchive), progress.BytesDownloaded)
+	}
+}
+
+func TestWriteArtifactStartOver(t *testing.T) {
+	// the partial download is not the prefix of the archive; resuming would corrupt it
+	a := newTestConfigureHost(t, ArtifactPolicy{}).withPartialArtifact(t, "corrupt")
+	reader := struct{ io.Reader }{strings.NewReader(string(testArchive))}
+
+	if err := a.writeArtifact(context.Background(), archiveMeta(digestOf(testArchive)), reader); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectArtifact(t, a)
+}
+
+func TestWriteArtifactSizeMismatch(t *testing.T) {
+	a := newTestConfigureHost(t, ArtifactPolicy{})
+	meta := archiveMeta(digestOf(testArchive))
+	meta.ContentSize += 10
+
+	err := a.writeArtifact(context.Background(), meta, newSeekableReader(string(testArchive)))
+	if err == nil {
+		t.Fatal("expected size mismatch error")
+	}
+
+	// can't be resumed; the next attempt start over
+	if _, err := os.Stat(a.partialArtifactPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the partial artifact removed, got %v", err)
+	}
+	if _, err := os.Stat(a.artifactPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no complete artifact, got %v", err)
+	}
+}
+
+func TestWriteArtifactReuseComplete(t *testing.T) {
+	a := newTestConfigureHost(t, ArtifactPolicy{}).withArtifact(t, testArchive, "")
+
+	if err := a.writeArtifact(context.Background(), archiveMeta(digestOf(testArchive)), failingReader{t: t}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectArtifact(t, a)
+}
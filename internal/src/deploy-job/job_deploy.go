@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/desain-gratis/common/lib/notifier"
@@ -22,16 +23,45 @@ type deploymentJob struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	// running sub-job; so cancellation can wait until they stop. shared by the snapshots
+	running *sync.WaitGroup
+
+	// no more work is submitted once cancelled; guarded by the controller lock
+	cancelled bool
+
 	dependencies *Dependencies
 	topic        notifier.Topic
 	log          *slog.Logger
 	host         *entity.Host
 
+	// shared by all jobs in this host; limit the artifact download
+	downloads semaphore
+
+	// shared by all jobs of the service; held while restarting, rolling back, decommissioning or cleaning up
+	serviceLock *sync.Mutex
+
+	// artifact verification of the job namespace
+	artifactPolicy ArtifactPolicy
+
 	// sub-job that we manage
+	*subJobs
+
+	Job entity.DeploymentJob `json:"job"`
+}
+
+// subJobs is shared by the snapshots, so the cancellation see the local state of the last run
+type subJobs struct {
 	configureHost      *configureHost
 	restartHostService *restartHostService
+}
 
-	Job entity.DeploymentJob `json:"job"`
+// snapshot copy the job for a single run; the job in the pool can be updated by the next event while it's running.
+// caller must hold the controller lock.
+func (d *deploymentJob) snapshot() *deploymentJob {
+	base := *d.jobBase
+	run := *d
+	run.jobBase = &base
+	return &run
 }
 
 func (d *deploymentJob) startConfigureHost() {
@@ -95,6 +125,7 @@ func (d *deploymentJob) startConfigureHost() {
 		HostName:     d.host.Host,
 		Status:       d.configureHost.status,
 		ErrorMessage: errMsg,
+		Progress:     d.configureHost.progress.stop(),
 		UpdatedAt:    time.Now(),
 	})
 	if err != nil {
@@ -161,6 +192,7 @@ func (d *deploymentJob) startRestartHostService() {
 		HostName:     d.host.Host,
 		Status:       d.restartHostService.status,
 		ErrorMessage: errMsg,
+		Progress:     d.restartHostService.progress.stop(),
 		UpdatedAt:    time.Now(),
 	})
 	if err != nil {
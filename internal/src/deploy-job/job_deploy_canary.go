@@ -0,0 +1,123 @@
+package deployjob
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/desain-gratis/common/lib/notifier"
+	notifier_impl "github.com/desain-gratis/common/lib/notifier/impl"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/internal/src/systemd"
+)
+
+// used when the systemd module is not enabled
+const canaryPollInterval = 5 * time.Second
+
+// watchCanary watch the service unit until the bake time ends.
+// If the unit is not healthy, we report to raft to fail the job.
+func (d *deploymentJob) watchCanary(bakeUntil time.Time) {
+	log := d.log
+
+	ctx, cancel := context.WithDeadline(d.ctx, bakeUntil)
+	defer cancel()
+
+	unitName := fmt.Sprintf("%v_%v", d.Job.Ns, d.Job.Request.Service.Id) + ".service"
+
+	log.Info("watching canary", "unit", unitName, "bake_until", bakeUntil)
+
+	var activeState string
+	var err error
+	if d.dependencies.SystemdTopic != nil {
+		activeState, err = watchUnitFromTopic(ctx, d.dependencies.SystemdTopic, unitName)
+	} else {
+		activeState, err = watchUnitFromDBus(ctx, unitName)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// bake finished / job cancelled
+			log.Info("finished watching canary", "unit", unitName)
+			return
+		}
+		log.Warn("failed to watch canary", "unit", unitName, "error", err)
+		return
+	}
+
+	errMsg := fmt.Sprintf("canary unit %v is not healthy: %v", unitName, activeState)
+	log.Warn("canary failed", "unit", unitName, "active_state", activeState)
+
+	_, err = d.dependencies.RaftJobUsecase.FeedHostCanaryUpdate(d.ctx, deployjob.CanaryUpdateRequest{
+		Ns:           d.Job.Ns,
+		JobId:        d.Job.Id,
+		Service:      d.Job.Request.Service.Id,
+		HostName:     d.host.Host,
+		ActiveState:  activeState,
+		ErrorMessage: &errMsg,
+		UpdatedAt:    time.Now(),
+	})
+	if err != nil {
+		log.Warn("failed to notify canary failure to manager.", "error", err)
+	}
+}
+
+// watchUnitFromTopic returns the active state once the unit is not healthy, using the state streamed by the systemd module
+func watchUnitFromTopic(ctx context.Context, topic notifier.Topic, unitName string) (string, error) {
+	subscription, err := topic.Subscribe(ctx, notifier_impl.NewStandardSubscriber(func(a any) bool {
+		row, ok := a.(systemd.Row[systemd.DBusUnitStatus])
+		return !ok || row.Key != unitName
+	}))
+	if err != nil {
+		return "", err
+	}
+	subscription.Start()
+
+	for event := range subscription.Listen() {
+		row := event.(systemd.Row[systemd.DBusUnitStatus])
+		if !isHealthyUnitState(row.Data.ActiveState) {
+			return row.Data.ActiveState, nil
+		}
+	}
+
+	return "", ctx.Err()
+}
+
+// watchUnitFromDBus returns the active state once the unit is not healthy, by polling systemd
+func watchUnitFromDBus(ctx context.Context, unitName string) (string, error) {
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(canaryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		props, err := conn.GetUnitPropertiesContext(ctx, unitName)
+		if err != nil {
+			return "", err
+		}
+
+		activeState, _ := props["ActiveState"].(string)
+		if !isHealthyUnitState(activeState) {
+			return activeState, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func isHealthyUnitState(activeState string) bool {
+	// reloading / activating are transient; not a failure
+	switch activeState {
+	case "active", "reloading", "activating":
+		return true
+	}
+	return false
+}
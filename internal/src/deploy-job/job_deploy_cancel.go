@@ -0,0 +1,112 @@
+package deployjob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// acknowledgeCancel wait until the running sub-job stopped, rollback if requested, then report the host state to raft.
+// the job context should be already cancelled.
+func (d *deploymentJob) acknowledgeCancel() {
+	log := d.log
+
+	d.running.Wait()
+	d.Status = StatusCancelled
+
+	request := deployjob.HostCancelUpdateRequest{
+		Ns:       d.Job.Ns,
+		JobId:    d.Job.Id,
+		Service:  d.Job.Request.Service.Id,
+		HostName: d.host.Host,
+	}
+
+	if info, ok := d.Job.Configuration.Status[d.host.Host]; ok {
+		status := info.Status
+		if d.configureHost != nil {
+			// local state is more up to date; raft might reject the last update because the job is already cancelled
+			status = d.configureHost.status
+		}
+
+		switch status {
+		case entity.HostConfigurationStatusSuccess, entity.HostConfigurationStatusFailed:
+			request.ConfigurationStatus = status
+		default:
+			request.ConfigurationStatus = entity.HostConfigurationStatusCancelled
+		}
+	}
+
+	if info, ok := d.Job.Deployment.Status[d.host.Host]; ok {
+		status := info.Status
+		if d.restartHostService != nil {
+			status = d.restartHostService.status
+		}
+
+		switch {
+		case status == entity.HostDeploymentStatusSuccess && d.Job.Cancellation != nil && d.Job.Cancellation.Rollback:
+			request.DeploymentStatus = entity.HostDeploymentStatusRolledBack
+			if err := d.rollbackHostService(); err != nil {
+				log.Error("failed to rollback service", "error", err)
+				request.DeploymentStatus = entity.HostDeploymentStatusFailed
+				errStr := err.Error()
+				request.ErrorMessage = &errStr
+			}
+		case status == entity.HostDeploymentStatusSuccess,
+			status == entity.HostDeploymentStatusFailed,
+			status == entity.HostDeploymentStatusTimeOut:
+			request.DeploymentStatus = status
+		default:
+			request.DeploymentStatus = entity.HostDeploymentStatusCancelled
+		}
+	}
+
+	request.UpdatedAt = time.Now()
+
+	_, err := d.dependencies.RaftJobUsecase.FeedHostCancelUpdate(context.Background(), request)
+	if err != nil {
+		log.Warn("failed to acknowledge job cancellation to manager.", "error", err)
+		return
+	}
+
+	log.Info("job cancelled",
+		"configuration_status", request.ConfigurationStatus, "deployment_status", request.DeploymentStatus)
+}
+
+// rollbackHostService switch the service back to the version of the rollback job
+func (d *deploymentJob) rollbackHostService() error {
+	cancellation := d.Job.Cancellation
+	if cancellation.RollbackJobId == "" {
+		return errors.New("no previous deployed version to roll back to")
+	}
+
+	d.log.Info("rolling back service", "rollback_job_id", cancellation.RollbackJobId,
+		"build_version", cancellation.RollbackBuildVersion, "env_version", cancellation.RollbackEnvVersion)
+
+	d.serviceLock.Lock()
+	defer d.serviceLock.Unlock()
+
+	// the job context is already cancelled; rollback have its own
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	err := Deploy(ctx, DeployConfig{
+		ServiceName: fmt.Sprintf("%v_%v", d.Job.Ns, d.Job.Request.Service.Id),
+		BuildID:     strconv.FormatUint(cancellation.RollbackBuildVersion, 10),
+		EnvVersion:  strconv.FormatUint(cancellation.RollbackEnvVersion, 10),
+		BaseDir:     "/opt",
+		BinPath:     d.Job.Request.Service.ExecutablePath,
+		Timeout:     rollbackTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("rollback to job %v failed: %w", cancellation.RollbackJobId, err)
+	}
+
+	return nil
+}
+
+const rollbackTimeout = 5 * time.Minute
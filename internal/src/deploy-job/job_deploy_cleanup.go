@@ -0,0 +1,212 @@
+package deployjob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// used when the job does not specify the retention policy
+const (
+	defaultRetainedBuildReleases = 3
+	defaultRetainedEnvReleases   = 3
+)
+
+var _ Job = &cleanupHost{}
+
+// cleanupHost prune the old releases of the service after all hosts are deployed
+type cleanupHost struct {
+	*deploymentJob
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	log    *slog.Logger
+
+	status  entity.HostCleanupStatus
+	removed []string
+}
+
+func (d *deploymentJob) startCleanupHost() {
+	log := d.log
+
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+
+	job := &cleanupHost{
+		deploymentJob: d,
+		ctx:           ctx,
+		cancel:        cancel,
+		status:        entity.HostCleanupStatusCleaning,
+	}
+	job.log = d.log.With("node", "cleanup-host").
+		With("status", job.status).
+		With("instance", job)
+
+	// Report to job manager (raft) that this host are cleaning up
+	_, err := d.dependencies.RaftJobUsecase.FeedHostCleanupUpdate(d.ctx, deployjob.CleanupUpdateRequest{
+		Ns:        d.Job.Ns,
+		JobId:     d.Job.Id,
+		Service:   d.Job.Request.Service.Id,
+		HostName:  d.host.Host,
+		Status:    job.status,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Warn("failed to notify cleanup state to manager.", "error", err)
+	}
+
+	var errMsg *string
+	err = job.Execute()
+	if err != nil {
+		job.status = entity.HostCleanupStatusFailed
+		if errors.Is(err, context.Canceled) {
+			job.status = entity.HostCleanupStatusTimeOut
+		}
+		errStr := err.Error()
+		errMsg = &errStr
+	} else {
+		job.status = entity.HostCleanupStatusSuccess
+	}
+
+	// Report back to job manager (raft)
+	_, err = d.dependencies.RaftJobUsecase.FeedHostCleanupUpdate(d.ctx, deployjob.CleanupUpdateRequest{
+		Ns:           d.Job.Ns,
+		JobId:        d.Job.Id,
+		Service:      d.Job.Request.Service.Id,
+		HostName:     d.host.Host,
+		Status:       job.status,
+		ErrorMessage: errMsg,
+		Removed:      job.removed,
+		UpdatedAt:    time.Now(),
+	})
+	if err != nil {
+		log.Warn("failed to notify cleanup status to manager. manager will time out the cleanup.", "error", err)
+		return
+	}
+
+	log.Info("successfully cleaned up host", "removed", job.removed)
+}
+
+func (a *cleanupHost) Execute() error {
+	a.serviceLock.Lock()
+	defer a.serviceLock.Unlock()
+
+	request := a.Job.Request
+	serviceName := fmt.Sprintf("%v_%v", request.Ns, request.Service.Id)
+	basePath := filepath.Join("/opt", serviceName)
+
+	keepBuild, keepEnv := uint(defaultRetainedBuildReleases), uint(defaultRetainedEnvReleases)
+	if request.Retention != nil {
+		if request.Retention.BuildReleases > 0 {
+			keepBuild = request.Retention.BuildReleases
+		}
+		if request.Retention.EnvReleases > 0 {
+			keepEnv = request.Retention.EnvReleases
+		}
+	}
+
+	// never remove what is running, nor what we just deployed
+	buildInUse := []string{strconv.FormatUint(request.BuildVersion, 10), linkedVersion(filepath.Join(basePath, "current"))}
+	envInUse := []string{strconv.FormatUint(request.EnvVersion, 10), linkedVersion(filepath.Join("/etc", serviceName, "env"))}
+
+	// nor the release a rollback would switch back to
+	if cleanup := a.Job.Cleanup; cleanup.KeepBuildVersion != 0 || cleanup.KeepEnvVersion != 0 {
+		buildInUse = append(buildInUse, strconv.FormatUint(cleanup.KeepBuildVersion, 10))
+		envInUse = append(envInUse, strconv.FormatUint(cleanup.KeepEnvVersion, 10))
+	}
+
+	removed, err := pruneReleases(a.ctx, filepath.Join(basePath, "build-release"), keepBuild, buildInUse...)
+	a.removed = append(a.removed, removed...)
+	if err != nil {
+		return err
+	}
+
+	removed, err = pruneReleases(a.ctx, filepath.Join(basePath, "env-release"), keepEnv, envInUse...)
+	a.removed = append(a.removed, removed...)
+	if err != nil {
+		return err
+	}
+
+	// already extracted to build-release
+	artifactPath := filepath.Join("/tmp", serviceName, "artifact", strconv.FormatUint(request.BuildVersion, 10))
+	a.log.Info("removing downloaded artifact", "path", artifactPath)
+	if _, err := os.Stat(artifactPath); err == nil {
+		if err := os.RemoveAll(artifactPath); err != nil {
+			return err
+		}
+		a.removed = append(a.removed, artifactPath)
+	}
+
+	return nil
+}
+
+// pruneReleases removes the release directories (named by version) under dir, except the latest "keep" versions and the ones in use.
+// Returns the removed paths.
+func pruneReleases(ctx context.Context, dir string, keep uint, inUse ...string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		version, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if err != nil {
+			// not ours
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	// latest first
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+	used := make(map[string]struct{}, len(inUse))
+	for _, version := range inUse {
+		used[version] = struct{}{}
+	}
+
+	var removed []string
+	for idx, version := range versions {
+		name := strconv.FormatUint(version, 10)
+		if _, ok := used[name]; ok || uint(idx) < keep {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+
+		path := filepath.Join(dir, name)
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+
+	return removed, nil
+}
+
+// linkedVersion returns the version (base name) the symlink point to; empty if it's not a symlink
+func linkedVersion(link string) string {
+	target, err := os.Readlink(link)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
@@ -14,6 +14,8 @@ import (
 	"time"
 
 	"github.com/coreos/go-systemd/v22/dbus"
+	common_entity "github.com/desain-gratis/common/types/entity"
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
 	"github.com/desain-gratis/deployd/src/entity"
 )
 
@@ -28,305 +30,356 @@ type configureHost struct {
 	log    *slog.Logger
 
 	status entity.HostConfigurationStatus
-}
-
-func (a *configureHost) Execute() error {
-	ctx := a.ctx
-	// TODO: separate it into their own module later...
-	a.log.Info("configuring host directory")
 
-	if err := ctx.Err(); err != nil {
-		a.status = entity.HostConfigurationStatusCancelled
-		a.log.Error("job cancelled", "error", err)
-		return err
-	}
+	// ensure dirs -> write unit, write env, download -> verify -> extract -> daemon-reload
+	steps    *stepExecutor
+	progress *progressReporter
 
-	basePath := fmt.Sprintf("/opt/%v_%v", a.Job.Request.Ns, a.Job.Request.Service.Id)
+	// set by download; the digest & signature recorded by artifactd
+	artifact *common_entity.Attachment
+}
 
-	a.log.Info("ensuring path", "path", basePath)
-	err := ensureDir(basePath)
+func (a *configureHost) Execute() error {
+	a.log.Info("configuring host")
+
+	a.steps = newStepExecutor(a.ctx, "configure-host-"+a.Job.Id, a.log,
+		newStep("ensure-dirs", a.ensureDirs),
+		newStep("write-unit", a.writeUnit, "ensure-dirs"),
+		newStep("write-env", a.writeEnv, "ensure-dirs").withRetry(3, 2*time.Second),
+		newStep("download", a.download, "ensure-dirs").withExponentialRetry(8, 2*time.Second, time.Minute),
+		newStep("verify-artifact", a.verifyArtifact, "download"),
+		newStep("extract", a.extract, "verify-artifact"),
+		newStep("daemon-reload", a.daemonReload, "write-unit", "write-env", "extract").withRetry(2, time.Second),
+	)
+
+	a.progress = newProgressReporter(progressReportInterval, a.reportProgress)
+	a.steps.onChange = func() { a.progress.stepProgress(a.steps) }
+
+	err := a.steps.Execute()
+	a.progress.stop()
 	if err != nil {
 		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while ensuring directory in base path", "path", basePath, "error", err)
+		if errors.Is(err, context.Canceled) {
+			a.status = entity.HostConfigurationStatusCancelled
+		}
 		return err
 	}
 
-	envPath := fmt.Sprintf(basePath+"/env-release/%v", a.Job.Request.EnvVersion)
-	a.log.Info("ensuring path", "path", envPath)
-	err = ensureDir(envPath)
-	if err != nil {
-		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while ensuring env path", "path", envPath, "error", err)
-		return err
-	}
+	a.status = entity.HostConfigurationStatusSuccess
+	a.log.Info("successfully configured host")
 
-	etcPath := fmt.Sprintf("/etc/%v_%v", a.Job.Request.Ns, a.Job.Request.Service.Id)
-	a.log.Info("ensuring path", "path", etcPath)
-	err = ensureDir(etcPath)
-	if err != nil {
-		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while ensuring etc path", "path", etcPath, "error", err)
-		return err
-	}
+	return nil
+}
 
-	tmpPath := fmt.Sprintf("/tmp/%s_%s/artifact/%v", a.Job.Request.Ns, a.Job.Request.Service.Id, a.Job.Request.BuildVersion)
-	a.log.Info("ensuring path", "tmp", tmpPath)
-	err = ensureDir(tmpPath)
+func (a *configureHost) reportProgress(progress entity.HostProgress) {
+	_, err := a.dependencies.RaftJobUsecase.FeedHostConfigurationUpdate(a.ctx, deployjob.ConfigurationUpdateRequest{
+		Ns:        a.Job.Ns,
+		JobId:     a.Job.Id,
+		Service:   a.Job.Request.Service.Id,
+		HostName:  a.host.Host,
+		Status:    entity.HostConfigurationStatusConfiguring,
+		Progress:  &progress,
+		UpdatedAt: progress.UpdatedAt,
+	})
 	if err != nil {
-		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while ensuring tmp path", "path", tmpPath, "error", err)
-		return err
+		a.log.Warn("failed to report configure progress to manager", "error", err)
 	}
+}
 
-	systemdPath := "/etc/systemd/system"
-	a.log.Info("ensuring path", "path", systemdPath)
-	err = ensureDir(systemdPath)
-	if err != nil {
-		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while ensuring systemd path", "path", systemdPath, "error", err)
-		return err
+func (a *configureHost) GetDAG() DAG {
+	if a.steps == nil {
+		return a.jobBase.GetDAG()
 	}
+	return a.steps.GetDAG()
+}
 
-	// write systemd
-	a.log.Info("writing unit file")
-	if err := ctx.Err(); err != nil {
-		a.status = entity.HostConfigurationStatusCancelled
-		a.log.Error("job cancelled", "error", err)
-		return err
-	}
+func (a *configureHost) serviceName() string {
+	return fmt.Sprintf("%v_%v", a.Job.Request.Ns, a.Job.Request.Service.Id)
+}
 
-	serviceName := fmt.Sprintf("%v_%v.service", a.Job.Request.Ns, a.Job.Request.Service.Id)
+// root of the installed services & the downloaded artifacts; tests point them to a temporary dir
+var (
+	installDir  = "/opt"
+	downloadDir = "/tmp"
+)
 
-	err = func() error {
-		content := BuildUnit(a.Job.Request.Ns, a.Job.Request.Service.Id, a.Job.Request.Service.Description, a.Job.Request.Service.ExecutablePath)
-		name := serviceName
-		tmp := filepath.Join(systemdPath, name+".tmp")
-		final := filepath.Join(systemdPath, name)
-		if err1 := os.WriteFile(tmp, []byte(content), 0644); err1 != nil {
-			a.status = entity.HostConfigurationStatusFailed
-			a.log.Error("error while ensuring systemd path", "path", systemdPath, "error", err1)
-			return err1
-		}
-		err1 := os.Rename(tmp, final)
-		if err1 != nil {
-			a.status = entity.HostConfigurationStatusFailed
-			a.log.Error("error while ensuring systemd path", "path", systemdPath, "error", err1)
-			return err1
+func (a *configureHost) basePath() string {
+	return installDir + "/" + a.serviceName()
+}
+
+func (a *configureHost) envPath() string {
+	return fmt.Sprintf("%v/env-release/%v", a.basePath(), a.Job.Request.EnvVersion)
+}
+
+func (a *configureHost) buildReleasePath() string {
+	return fmt.Sprintf("%v/build-release/%v", a.basePath(), a.Job.Request.BuildVersion)
+}
+
+func (a *configureHost) tmpPath() string {
+	return fmt.Sprintf("%v/%v/artifact/%v", downloadDir, a.serviceName(), a.Job.Request.BuildVersion)
+}
+
+// complete download
+func (a *configureHost) artifactPath() string {
+	return a.tmpPath() + "/release.tar.gz"
+}
+
+// download in progress; renamed to artifactPath once complete
+func (a *configureHost) partialArtifactPath() string {
+	return a.artifactPath() + ".part"
+}
+
+const systemdPath = "/etc/systemd/system"
+
+func (a *configureHost) ensureDirs(_ context.Context) error {
+	for _, path := range []string{
+		a.basePath(),
+		a.envPath(),
+		"/etc/" + a.serviceName(),
+		a.tmpPath(),
+		systemdPath,
+		a.buildReleasePath(),
+	} {
+		a.log.Info("ensuring path", "path", path)
+		if err := ensureDir(path); err != nil {
+			return fmt.Errorf("error while ensuring path %v: %w", path, err)
 		}
+	}
 
-		return nil
-	}()
-	if err != nil {
-		return err
+	return nil
+}
+
+func (a *configureHost) writeUnit(_ context.Context) error {
+	a.log.Info("writing unit file")
+
+	content := BuildUnit(a.Job.Request.Ns, a.Job.Request.Service.Id, a.Job.Request.Service.Description, a.Job.Request.Service.ExecutablePath)
+	tmp := filepath.Join(systemdPath, a.serviceName()+".service.tmp")
+	final := filepath.Join(systemdPath, a.serviceName()+".service")
+
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return fmt.Errorf("error while writing unit file: %w", err)
 	}
 
-	// start more heavier operation
-	a.log.Info("downloading .env")
-	if err := ctx.Err(); err != nil {
-		a.status = entity.HostConfigurationStatusCancelled
-		a.log.Error("job cancelled", "error", err)
-		return err
+	if err := os.Rename(tmp, final); err != nil {
+		return fmt.Errorf("error while writing unit file: %w", err)
 	}
 
-	err = func() error {
-		envData, err1 := a.dependencies.EnvUsecase.Get(ctx, a.Job.Request.Ns, []string{a.Job.Request.Service.Id}, strconv.FormatUint(a.Job.Request.EnvVersion, 10))
-		if err1 != nil || len(envData) == 0 {
-			a.status = entity.HostConfigurationStatusFailed
-			a.log.Error("error while downloading env", "error", err1)
-			return err1
-		}
+	return nil
+}
 
-		env := envData[0]
+func (a *configureHost) writeEnv(ctx context.Context) error {
+	a.log.Info("downloading .env")
 
-		tmpEnv := make([]string, 0, len(env.Value))
-		for k, v := range env.Value {
-			tmpEnv = append(tmpEnv, fmt.Sprintf("%v=%v", strings.ToUpper(k), strconv.Quote(v)))
-		}
+	envData, err := a.dependencies.EnvUsecase.Get(ctx, a.Job.Request.Ns, []string{a.Job.Request.Service.Id}, strconv.FormatUint(a.Job.Request.EnvVersion, 10))
+	if err != nil {
+		return fmt.Errorf("error while downloading env: %w", err)
+	}
+	if len(envData) == 0 {
+		return fmt.Errorf("env %v not found", a.Job.Request.EnvVersion)
+	}
 
-		sort.Slice(tmpEnv, func(i, j int) bool {
-			return strings.Compare(tmpEnv[i], tmpEnv[j]) < 0
-		})
+	env := envData[0]
 
-		a.log.Info("writing .env")
+	tmpEnv := make([]string, 0, len(env.Value))
+	for k, v := range env.Value {
+		tmpEnv = append(tmpEnv, fmt.Sprintf("%v=%v", strings.ToUpper(k), strconv.Quote(v)))
+	}
 
-		path := envPath + "/overwrite.env"
+	sort.Slice(tmpEnv, func(i, j int) bool {
+		return strings.Compare(tmpEnv[i], tmpEnv[j]) < 0
+	})
 
-		f, err1 := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-		if err1 != nil {
-			a.status = entity.HostConfigurationStatusFailed
-			a.log.Error("error while opening env file", "path", path, "error", err1)
-			return err1
-		}
-		defer f.Close()
+	a.log.Info("writing .env")
 
-		for _, env := range tmpEnv {
-			fmt.Fprintln(f, env)
-		}
+	path := a.envPath() + "/overwrite.env"
 
-		return nil
-	}()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		return err
+		return fmt.Errorf("error while opening env file %v: %w", path, err)
 	}
+	defer f.Close()
 
-	buildReleasePath := fmt.Sprintf(basePath+"/build-release/%v", a.Job.Request.BuildVersion)
-	err = ensureDir(buildReleasePath)
-	if err != nil {
-		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while ensuring build release path", "path", buildReleasePath, "error", err)
-		return err
+	for _, env := range tmpEnv {
+		fmt.Fprintln(f, env)
 	}
 
+	return nil
+}
+
+// isInstalled returns true if the build release is already extracted
+func (a *configureHost) isInstalled() (bool, error) {
 	// TODO: use per file based check / more robust approach;
-	isBuildEmpty, err := isEmptyDir(buildReleasePath)
+	isBuildEmpty, err := isEmptyDir(a.buildReleasePath())
 	if err != nil {
-		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while check existing installation inside", "path", buildReleasePath, "error", err)
-		return err
+		return false, fmt.Errorf("error while check existing installation inside %v: %w", a.buildReleasePath(), err)
 	}
+	return !isBuildEmpty, nil
+}
 
-	// TODO: remove this; after finding a way to optimize use installation
-	if !isBuildEmpty {
-		a.status = entity.HostConfigurationStatusSuccess
-		a.log.Info("host is configured")
+func (a *configureHost) download(ctx context.Context) error {
+	installed, err := a.isInstalled()
+	if err != nil {
 		return err
 	}
+	if installed {
+		a.log.Info("build is already installed")
+		return errSkipStep
+	}
 
-	a.log.Info("downloading build artifact")
-	if err := ctx.Err(); err != nil {
-		a.status = entity.HostConfigurationStatusCancelled
-		a.log.Error("job cancelled", "error", err)
+	a.log.Info("waiting for download slot")
+	if err := a.downloads.acquire(ctx); err != nil {
 		return err
 	}
+	defer a.downloads.release()
 
-	err = func() error {
-		buildId := strconv.FormatUint(a.Job.Request.BuildVersion, 10)
+	buildId := strconv.FormatUint(a.Job.Request.BuildVersion, 10)
 
-		f, err1 := os.OpenFile(tmpPath+"/release.tar.gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-		if err1 != nil {
-			a.status = entity.HostConfigurationStatusFailed
-			a.log.Error("error while opening env file", "error", err1)
-			return err1
-		}
-		defer f.Close()
-
-		buildArtifact, meta, err1 := a.dependencies.BuildArtifactUsecase.GetAttachment(
-			ctx,
-			a.Job.Request.Ns,
-			[]string{a.Job.Request.Service.Id, buildId},
-			fmt.Sprintf("%v/%v", a.host.OS, a.host.Architecture), // attachment can have one to many, so we're restricting to one
-		)
-		if err1 != nil {
-			a.status = entity.HostConfigurationStatusFailed
-			a.log.Error("error while getting build artifact", "error", err1)
-
-			if errors.Is(err1, context.Canceled) {
-				a.Status = StatusCancelled
-			}
-
-			return err1
-		}
-		defer buildArtifact.Close()
-
-		// Download
-		total, err1 := Copy(ctx, f, buildArtifact)
-		if err1 != nil {
-			a.status = entity.HostConfigurationStatusFailed
-			a.log.Error("error while writing artifact file", "error", err1)
-			return err1
-		}
+	buildArtifact, meta, err := a.dependencies.BuildArtifactUsecase.GetAttachment(
+		ctx,
+		a.Job.Request.Ns,
+		[]string{a.Job.Request.Service.Id, buildId},
+		fmt.Sprintf("%v/%v", a.host.OS, a.host.Architecture), // attachment can have one to many, so we're restricting to one
+	)
+	if err != nil {
+		return fmt.Errorf("error while getting build artifact: %w", err)
+	}
+	defer buildArtifact.Close()
 
-		if meta.ContentSize != uint64(total) {
-			return fmt.Errorf("download file size not matching! expected %v got %v", meta.ContentSize, total)
-		}
+	return a.writeArtifact(ctx, meta, buildArtifact)
+}
 
+// writeArtifact write the archive to the artifact path; resumed from the partial download if possible
+func (a *configureHost) writeArtifact(ctx context.Context, meta *common_entity.Attachment, buildArtifact io.Reader) error {
+	a.artifact = meta
+
+	// complete download of the previous run (eg. job retried); the digest is verified in the next step
+	if meta.Hash != "" && fileSize(a.artifactPath()) == int64(meta.ContentSize) {
+		a.log.Info("reusing downloaded build artifact", "path", a.artifactPath())
+		a.progress.update(func(progress *entity.HostProgress) {
+			progress.BytesDownloaded = meta.ContentSize
+			progress.BytesTotal = meta.ContentSize
+		})
 		return nil
-	}()
-	if err != nil {
-		return err
 	}
 
-	a.log.Info("extracting build artifact")
+	offset := a.resumeOffset(meta, buildArtifact)
 
-	tmp := buildReleasePath + ".tmp"
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		a.log.Info("resuming build artifact download", "offset", offset, "size", meta.ContentSize)
+	} else {
+		a.log.Info("downloading build artifact")
+	}
 
-	err = ensureDir(tmp)
+	f, err := os.OpenFile(a.partialArtifactPath(), flag, 0644)
 	if err != nil {
-		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while ensuring extracted artifact dir", "error", err)
-		return err
+		return fmt.Errorf("error while opening artifact file: %w", err)
 	}
-	err = os.RemoveAll(tmp)
-	if err != nil {
-		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while removing old artifact", "error", err)
-		return err
+	defer f.Close()
+
+	a.progress.update(func(progress *entity.HostProgress) {
+		progress.BytesDownloaded = uint64(offset)
+		progress.BytesTotal = meta.ContentSize
+	})
+
+	w := &progressWriter{Writer: f, onWrite: func(n int) {
+		a.progress.update(func(progress *entity.HostProgress) {
+			progress.BytesDownloaded += uint64(n)
+		})
+	}}
+
+	if _, err := Copy(ctx, w, buildArtifact); err != nil {
+		// keep the partial file; the next attempt continue from there
+		return fmt.Errorf("error while writing artifact file: %w", err)
 	}
 
-	err = ExtractTarGzStrip(tmpPath+"/release.tar.gz", tmp)
-	if err != nil {
-		return fmt.Errorf("error while extracting artifact file: %w", err)
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error while writing artifact file: %w", err)
 	}
 
-	err = os.RemoveAll(buildReleasePath) // delete previous
-	if err != nil {
-		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while deleting previous installation", "error", err)
-		return err
+	total := fileSize(a.partialArtifactPath())
+	if meta.ContentSize != uint64(total) {
+		// can't be resumed; start over in the next attempt
+		_ = os.Remove(a.partialArtifactPath())
+		return fmt.Errorf("download file size not matching! expected %v got %v", meta.ContentSize, total)
 	}
 
-	err = os.Rename(tmp, buildReleasePath)
+	if err := os.Rename(a.partialArtifactPath(), a.artifactPath()); err != nil {
+		return fmt.Errorf("error while renaming artifact file: %w", err)
+	}
+
+	return nil
+}
+
+func (a *configureHost) extract(_ context.Context) error {
+	installed, err := a.isInstalled()
 	if err != nil {
-		a.status = entity.HostConfigurationStatusFailed
-		a.log.Error("error while renaming artifact file", "error", err)
 		return err
 	}
+	if installed {
+		return errSkipStep
+	}
 
-	err = func() error {
-		// reload daemon reload
-		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-		defer cancel()
+	a.log.Info("extracting build artifact")
 
-		conn, err := dbus.NewSystemConnectionContext(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to connect to systemd: %w", err)
-		}
-		defer conn.Close()
+	buildReleasePath := a.buildReleasePath()
+	tmp := buildReleasePath + ".tmp"
 
-		// This is equivalent to: systemctl daemon-reload
-		if err := conn.ReloadContext(ctx); err != nil {
-			return fmt.Errorf("failed to reload systemd: %w", err)
-		}
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("error while removing old artifact: %w", err)
+	}
 
-		props, err := conn.GetUnitPropertiesContext(ctx, serviceName)
-		if err != nil {
-			a.status = entity.HostConfigurationStatusFailed
-			return err
-		}
+	if err := ExtractTarGzStrip(a.artifactPath(), tmp); err != nil {
+		return fmt.Errorf("error while extracting artifact file: %w", err)
+	}
 
-		loadErr, ok := props["LoadError"].(string)
-		if ok && loadErr != "" {
-			return fmt.Errorf("systemd library load error: %v", err)
-		}
+	if err := os.RemoveAll(buildReleasePath); err != nil { // delete previous
+		return fmt.Errorf("error while deleting previous installation: %w", err)
+	}
 
-		loadState, ok := props["LoadState"].(string)
-		if !ok {
-			return errors.New("systemd library error")
-		}
+	if err := os.Rename(tmp, buildReleasePath); err != nil {
+		return fmt.Errorf("error while renaming artifact file: %w", err)
+	}
 
-		if loadState != "loaded" {
-			a.status = entity.HostConfigurationStatusFailed
-			return fmt.Errorf("service is not loaded. found '%v' state instead for service '%v'", loadState, serviceName)
-		}
+	return nil
+}
 
-		return nil
-	}()
+func (a *configureHost) daemonReload(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	unitName := a.serviceName() + ".service"
+
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	// This is equivalent to: systemctl daemon-reload
+	if err := conn.ReloadContext(ctx); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+
+	props, err := conn.GetUnitPropertiesContext(ctx, unitName)
 	if err != nil {
 		return err
 	}
 
-	a.status = entity.HostConfigurationStatusSuccess
-	a.log.Info("successfully configured host")
+	loadErr, ok := props["LoadError"].(string)
+	if ok && loadErr != "" {
+		return fmt.Errorf("systemd library load error: %v", loadErr)
+	}
+
+	loadState, ok := props["LoadState"].(string)
+	if !ok {
+		return errors.New("systemd library error")
+	}
+
+	if loadState != "loaded" {
+		return fmt.Errorf("service is not loaded. found '%v' state instead for service '%v'", loadState, unitName)
+	}
 
 	return nil
 }
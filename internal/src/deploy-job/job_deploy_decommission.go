@@ -0,0 +1,140 @@
+package deployjob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+var _ Job = &decommissionHost{}
+
+// decommissionHost stop & uninstall the service from a host that is removed from the service
+type decommissionHost struct {
+	*deploymentJob
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	log    *slog.Logger
+
+	status entity.HostDecommissionStatus
+}
+
+func (d *deploymentJob) startDecommissionHost() {
+	log := d.log
+
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+
+	job := &decommissionHost{
+		deploymentJob: d,
+		ctx:           ctx,
+		cancel:        cancel,
+		status:        entity.HostDecommissionStatusUninstalling,
+	}
+	job.log = d.log.With("node", "decommission-host").
+		With("status", job.status).
+		With("instance", job)
+
+	// Report to job manager (raft) that this host are uninstalling
+	_, err := d.dependencies.RaftJobUsecase.FeedHostDecommissionUpdate(d.ctx, deployjob.DecommissionUpdateRequest{
+		Ns:        d.Job.Ns,
+		JobId:     d.Job.Id,
+		Service:   d.Job.Request.Service.Id,
+		HostName:  d.host.Host,
+		Status:    job.status,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Warn("failed to notify decommission state to manager.", "error", err)
+	}
+
+	var errMsg *string
+	err = job.Execute()
+	if err != nil {
+		job.status = entity.HostDecommissionStatusFailed
+		if errors.Is(err, context.Canceled) {
+			job.status = entity.HostDecommissionStatusTimeOut
+		}
+		errStr := err.Error()
+		errMsg = &errStr
+	} else {
+		job.status = entity.HostDecommissionStatusSuccess
+	}
+
+	// Report back to job manager (raft)
+	_, err = d.dependencies.RaftJobUsecase.FeedHostDecommissionUpdate(d.ctx, deployjob.DecommissionUpdateRequest{
+		Ns:           d.Job.Ns,
+		JobId:        d.Job.Id,
+		Service:      d.Job.Request.Service.Id,
+		HostName:     d.host.Host,
+		Status:       job.status,
+		ErrorMessage: errMsg,
+		UpdatedAt:    time.Now(),
+	})
+	if err != nil {
+		log.Warn("failed to notify decommission status to manager. manager should check this host.", "error", err)
+		return
+	}
+
+	log.Info("successfully decommissioned host")
+}
+
+func (a *decommissionHost) Execute() error {
+	a.serviceLock.Lock()
+	defer a.serviceLock.Unlock()
+
+	ctx := a.ctx
+
+	serviceName := fmt.Sprintf("%v_%v", a.Job.Request.Ns, a.Job.Request.Service.Id)
+	unitName := serviceName + ".service"
+
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	a.log.Info("stopping service", "unit", unitName)
+	if err := stopService(ctx, conn, unitName); err != nil {
+		// might be never started
+		a.log.Warn("failed to stop service", "unit", unitName, "error", err)
+	}
+
+	a.log.Info("removing unit file", "unit", unitName)
+	err = os.Remove(filepath.Join("/etc/systemd/system", unitName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// This is equivalent to: systemctl daemon-reload
+	if err := conn.ReloadContext(ctx); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+
+	// raft data (WAL / node host dir) is intentionally kept; remove it manually if needed
+	for _, dir := range []string{
+		filepath.Join("/opt", serviceName),
+		filepath.Join("/etc", serviceName),
+		filepath.Join("/tmp", serviceName),
+	} {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		a.log.Info("removing directory", "path", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
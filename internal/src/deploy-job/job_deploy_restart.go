@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/coreos/go-systemd/v22/dbus"
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
 	"github.com/desain-gratis/deployd/src/entity"
 )
 
@@ -25,6 +26,10 @@ type restartHostService struct {
 	log    *slog.Logger
 
 	status entity.HostDeploymentStatus
+
+	// validate -> stop -> switch links -> start -> verify
+	steps    *stepExecutor
+	progress *progressReporter
 }
 
 func (c *restartHostService) Execute() error {
@@ -35,6 +40,10 @@ func (c *restartHostService) Execute() error {
 	// wait ready
 	// start tunnel
 
+	// the drift repair might be touching the service
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+
 	config := DeployConfig{
 		ServiceName: fmt.Sprintf("%v_%v", c.Job.Ns, c.Job.Request.Service.Id),
 		BuildID:     strconv.FormatUint(c.Job.Request.BuildVersion, 10),
@@ -44,23 +53,88 @@ func (c *restartHostService) Execute() error {
 		Timeout:     30 * time.Hour,
 	}
 
-	return Deploy(c.ctx, config)
+	d, err := newDeployment(config)
+	if err != nil {
+		return err
+	}
+	defer d.close()
+
+	c.steps = newStepExecutor(c.ctx, "restart-service-"+c.Job.Id, c.log, d.steps()...)
+
+	c.progress = newProgressReporter(progressReportInterval, c.reportProgress)
+	c.steps.onChange = func() { c.progress.stepProgress(c.steps) }
+
+	err = c.steps.Execute()
+	c.progress.stop()
+
+	return err
+}
+
+func (c *restartHostService) reportProgress(progress entity.HostProgress) {
+	_, err := c.dependencies.RaftJobUsecase.FeedHostRestartServiceUpdate(c.ctx, deployjob.HostRestartServiceUpdateRequest{
+		Ns:        c.Job.Ns,
+		JobId:     c.Job.Id,
+		Service:   c.Job.Request.Service.Id,
+		HostName:  c.host.Host,
+		Status:    entity.HostDeploymentStatusRestarting,
+		Progress:  &progress,
+		UpdatedAt: progress.UpdatedAt,
+	})
+	if err != nil {
+		c.log.Warn("failed to report restart progress to manager", "error", err)
+	}
+}
 
-	// return errors.New("not implemented yet")
+func (c *restartHostService) GetDAG() DAG {
+	if c.steps == nil {
+		return c.jobBase.GetDAG()
+	}
+	return c.steps.GetDAG()
 }
 
 type DeployConfig struct {
-	ServiceName string // e.g. "deployd_user-profile"
-	BuildID     string // e.g. "20260215-abc123"
+	ServiceName string // e.g. "myapp"
+	BuildID     string // e.g. "20260210-1530"
 	EnvVersion  string
 	BaseDir     string        // default: /opt
 	BinPath     string        // e.g. "bin/myapp"
 	Timeout     time.Duration // optional
 }
 
+// Deploy switch the service to the build & env release, then restart it.
+// If the service cannot be started, the previous release is restored.
 func Deploy(ctx context.Context, cfg DeployConfig) error {
+	d, err := newDeployment(cfg)
+	if err != nil {
+		return err
+	}
+	defer d.close()
+
+	log := slog.Default().With("node", "deploy").With("service", cfg.ServiceName)
+
+	return newStepExecutor(ctx, "deploy-"+cfg.ServiceName+"-"+cfg.BuildID, log, d.steps()...).Execute()
+}
+
+// deployment is the state shared by the deploy steps
+type deployment struct {
+	cfg DeployConfig
+
+	releaseDir    string
+	envReleaseDir string
+	currentLink   string
+	etcServiceDir string
+	etcEnvLink    string
+	unitName      string
+
+	// set while executing
+	conn            *dbus.Conn
+	prevBuildTarget string
+	prevEnvTarget   string
+}
+
+func newDeployment(cfg DeployConfig) (*deployment, error) {
 	if cfg.ServiceName == "" || cfg.BuildID == "" {
-		return errors.New("missing service name or build id")
+		return nil, errors.New("missing service name or build id")
 	}
 
 	if cfg.BaseDir == "" {
@@ -68,36 +142,56 @@ func Deploy(ctx context.Context, cfg DeployConfig) error {
 	}
 
 	baseDir := filepath.Join(cfg.BaseDir, cfg.ServiceName)
-	releaseDir := filepath.Join(baseDir, "build-release", cfg.BuildID)
-	envReleaseDir := filepath.Join(baseDir, "env-release", cfg.EnvVersion)
+	etcServiceDir := filepath.Join("/etc", cfg.ServiceName)
 
-	currentLink := filepath.Join(baseDir, "current")
+	return &deployment{
+		cfg:           cfg,
+		releaseDir:    filepath.Join(baseDir, "build-release", cfg.BuildID),
+		envReleaseDir: filepath.Join(baseDir, "env-release", cfg.EnvVersion),
+		currentLink:   filepath.Join(baseDir, "current"),
+		etcServiceDir: etcServiceDir,
+		etcEnvLink:    filepath.Join(etcServiceDir, "env"),
+		unitName:      cfg.ServiceName + ".service",
+	}, nil
+}
 
-	etcServiceDir := filepath.Join("/etc", cfg.ServiceName)
-	etcEnvLink := filepath.Join(etcServiceDir, "env")
+func (d *deployment) steps() []*step {
+	return []*step{
+		newStep("validate", d.validate),
+		newStep("stop", d.stop, "validate"),
+		newStep("switch-links", d.switchLinks, "stop"),
+		newStep("start", d.start, "switch-links"),
+		newStep("verify", d.verify, "start"),
+	}
+}
 
-	unitName := cfg.ServiceName + ".service"
+func (d *deployment) close() {
+	if d.conn != nil {
+		d.conn.Close()
+	}
+}
 
-	// 1️⃣ Validate release exists
-	releaseInfo, err := os.Stat(releaseDir)
+func (d *deployment) validate(_ context.Context) error {
+	// Validate release exists
+	releaseInfo, err := os.Stat(d.releaseDir)
 	if err != nil || !releaseInfo.IsDir() {
-		return fmt.Errorf("release directory not found: %s", releaseDir)
+		return fmt.Errorf("release directory not found: %s", d.releaseDir)
 	}
 
-	// 2️⃣ Validate env-release exists
-	envInfo, err := os.Stat(envReleaseDir)
+	// Validate env-release exists
+	envInfo, err := os.Stat(d.envReleaseDir)
 	if err != nil || !envInfo.IsDir() {
-		return fmt.Errorf("env-release directory not found: %s", envReleaseDir)
+		return fmt.Errorf("env-release directory not found: %s", d.envReleaseDir)
 	}
 
 	// Validate overwrite.env exists
-	overwriteEnvPath := filepath.Join(envReleaseDir, "overwrite.env")
+	overwriteEnvPath := filepath.Join(d.envReleaseDir, "overwrite.env")
 	if _, err := os.Stat(overwriteEnvPath); err != nil {
 		return fmt.Errorf("overwrite.env not found in env-release: %s", overwriteEnvPath)
 	}
 
-	// 3️⃣ Validate binary exists
-	binaryPath := filepath.Join(releaseDir, cfg.BinPath)
+	// Validate binary exists
+	binaryPath := filepath.Join(d.releaseDir, d.cfg.BinPath)
 
 	binInfo, err := os.Stat(binaryPath)
 	if err != nil {
@@ -112,51 +206,59 @@ func Deploy(ctx context.Context, cfg DeployConfig) error {
 		}
 	}
 
-	// 4️⃣ Ensure /etc/<service> exists
-	if err := os.MkdirAll(etcServiceDir, 0755); err != nil {
+	// Ensure /etc/<service> exists
+	if err := os.MkdirAll(d.etcServiceDir, 0755); err != nil {
 		return fmt.Errorf("failed to create etc service dir: %w", err)
 	}
 
-	// 5️⃣ Connect to systemd
+	return nil
+}
+
+func (d *deployment) stop(ctx context.Context) error {
 	conn, err := dbus.NewSystemConnectionContext(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	d.conn = conn
 
-	// 6️⃣ Stop service
-	if err := stopService(ctx, conn, unitName); err != nil {
-		return err
-	}
+	return stopService(ctx, d.conn, d.unitName)
+}
 
-	// 7️⃣ Backup previous symlinks for rollback
-	prevBuildTarget, _ := os.Readlink(currentLink)
-	prevEnvTarget, _ := os.Readlink(etcEnvLink)
+func (d *deployment) switchLinks(_ context.Context) error {
+	// Backup previous symlinks for rollback
+	d.prevBuildTarget, _ = os.Readlink(d.currentLink)
+	d.prevEnvTarget, _ = os.Readlink(d.etcEnvLink)
 
-	// 8️⃣ Switch build symlink
-	if err := switchSymlinkAtomic(currentLink, releaseDir); err != nil {
+	// Switch build symlink
+	if err := switchSymlinkAtomic(d.currentLink, d.releaseDir); err != nil {
 		return err
 	}
 
-	// 9️⃣ Switch env symlink
-	if err := switchSymlinkAtomic(etcEnvLink, envReleaseDir); err != nil {
+	// Switch env symlink
+	if err := switchSymlinkAtomic(d.etcEnvLink, d.envReleaseDir); err != nil {
 		// rollback build if env switch fails
-		if prevBuildTarget != "" {
-			_ = switchSymlinkAtomic(currentLink, prevBuildTarget)
+		if d.prevBuildTarget != "" {
+			_ = switchSymlinkAtomic(d.currentLink, d.prevBuildTarget)
 		}
 		return fmt.Errorf("failed to switch env symlink: %w", err)
 	}
 
-	// 🔟 Start service
-	if err := startService(ctx, conn, unitName); err != nil {
-		rollback(currentLink, prevBuildTarget, etcEnvLink, prevEnvTarget, conn, ctx, unitName)
+	return nil
+}
+
+func (d *deployment) start(ctx context.Context) error {
+	if err := startService(ctx, d.conn, d.unitName); err != nil {
+		rollback(d.currentLink, d.prevBuildTarget, d.etcEnvLink, d.prevEnvTarget, d.conn, ctx, d.unitName)
 		return fmt.Errorf("start failed, rolled back: %w", err)
 	}
 
-	// 1️⃣1️⃣ Verify active state
-	active, err := isActive(ctx, conn, unitName)
+	return nil
+}
+
+func (d *deployment) verify(ctx context.Context) error {
+	active, err := isActive(ctx, d.conn, d.unitName)
 	if err != nil || !active {
-		rollback(currentLink, prevBuildTarget, etcEnvLink, prevEnvTarget, conn, ctx, unitName)
+		rollback(d.currentLink, d.prevBuildTarget, d.etcEnvLink, d.prevEnvTarget, d.conn, ctx, d.unitName)
 		return fmt.Errorf("service failed health check after start: %v", err)
 	}
 
@@ -0,0 +1,161 @@
+package deployjob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// per host; the plan should not wait for an unreachable host
+const localPlanTimeout = 5 * time.Second
+
+// localPlan returns what is installed for the service in this host
+func (w *jobsController) localPlan(request entity.SubmitDeploymentJobRequest) entity.HostLocalView {
+	serviceName := fmt.Sprintf("%v_%v", request.Ns, request.Service.Id)
+
+	view := entity.HostLocalView{
+		Host:               w.host.Host,
+		LinkedBuildVersion: linkedVersion(filepath.Join("/opt", serviceName, "current")),
+		LinkedEnvVersion:   linkedVersion(filepath.Join("/etc", serviceName, "env")),
+	}
+
+	installed, err := os.ReadFile(filepath.Join("/etc/systemd/system", serviceName+".service"))
+	if err == nil {
+		view.InstalledUnit = string(installed)
+	}
+
+	view.UnitDiff = diffLines(view.InstalledUnit, BuildUnit(request.Ns, request.Service.Id, request.Service.Description, request.Service.ExecutablePath))
+
+	return view
+}
+
+// addLocalViews ask every host of the plan for its local view, in parallel.
+// The host that cannot be reached is reported in the LocalError.
+func (w *jobsController) addLocalViews(ctx context.Context, plan *entity.DeploymentPlan, request entity.SubmitDeploymentJobRequest) {
+	wg := new(sync.WaitGroup)
+	for idx := range plan.Hosts {
+		hostPlan := &plan.Hosts[idx]
+
+		if hostPlan.Host == w.host.Host {
+			view := w.localPlan(request)
+			hostPlan.Local = &view
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			view, err := w.requestLocalPlan(ctx, hostPlan.Host, request)
+			if err != nil {
+				hostPlan.LocalError = err.Error()
+				return
+			}
+			hostPlan.Local = &view
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *jobsController) requestLocalPlan(ctx context.Context, host string, request entity.SubmitDeploymentJobRequest) (entity.HostLocalView, error) {
+	hosts, err := w.dependencies.HostConfigUsecase.Get(ctx, w.host.Ns, nil, host)
+	if err != nil {
+		return entity.HostLocalView{}, err
+	}
+	if len(hosts) == 0 || hosts[0].Address == "" {
+		return entity.HostLocalView{}, fmt.Errorf("address of host %v is unknown", host)
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return entity.HostLocalView{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, localPlanTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(hosts[0].Address, "/")+"/deployd/job/plan/local", bytes.NewReader(payload))
+	if err != nil {
+		return entity.HostLocalView{}, err
+	}
+	req.Header.Set("X-Namespace", request.Ns)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return entity.HostLocalView{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1000000))
+	if err != nil {
+		return entity.HostLocalView{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return entity.HostLocalView{}, fmt.Errorf("host %v: %v %v", host, resp.Status, string(body))
+	}
+
+	var view entity.HostLocalView
+	err = json.Unmarshal(body, &view)
+	return view, err
+}
+
+// diffLines returns a simple line diff from a to b ("-" removed, "+" added); empty if the same
+func diffLines(a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	before, after := splitLines(a), splitLines(b)
+
+	// longest common subsequence
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			out.WriteString("  " + before[i] + "\n")
+			i++
+			j++
+		case i < len(before) && (j == len(after) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("- " + before[i] + "\n")
+			i++
+		default:
+			out.WriteString("+ " + after[j] + "\n")
+			j++
+		}
+	}
+
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,149 @@
+package deployjob
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// every progress update is a raft proposal; at most one per interval for each host work
+const progressReportInterval = 5 * time.Second
+
+// progressReporter send the progress of the host work to raft, throttled.
+// The update in between is not lost; it is sent at the end of the interval.
+// The sending is done by a single goroutine outside the lock, so a slow raft proposal does not block the work.
+type progressReporter struct {
+	mu       sync.Mutex
+	send     func(progress entity.HostProgress)
+	interval time.Duration
+
+	// hold at most the latest unsent progress; consumed in order by the sender
+	queue chan entity.HostProgress
+	done  chan struct{}
+
+	progress entity.HostProgress
+	lastSent time.Time
+	pending  *time.Timer
+	stopped  bool
+}
+
+func newProgressReporter(interval time.Duration, send func(progress entity.HostProgress)) *progressReporter {
+	p := &progressReporter{
+		send:     send,
+		interval: interval,
+		queue:    make(chan entity.HostProgress, 1),
+		done:     make(chan struct{}),
+		progress: entity.HostProgress{StartedAt: time.Now()},
+	}
+
+	go func() {
+		defer close(p.done)
+		for progress := range p.queue {
+			p.send(progress)
+		}
+	}()
+
+	return p
+}
+
+func (p *progressReporter) update(fn func(progress *entity.HostProgress)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return
+	}
+
+	fn(&p.progress)
+	p.progress.UpdatedAt = time.Now()
+
+	if p.pending != nil {
+		// already scheduled; will send the latest
+		return
+	}
+
+	wait := p.interval - time.Since(p.lastSent)
+	if wait <= 0 {
+		p.flushLocked()
+		return
+	}
+
+	p.pending = time.AfterFunc(wait, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.pending = nil
+		if !p.stopped {
+			p.flushLocked()
+		}
+	})
+}
+
+// flushLocked queue the progress to the sender, replacing the one not sent yet.
+// never block; the lock make this the only writer of the queue.
+func (p *progressReporter) flushLocked() {
+	p.lastSent = time.Now()
+
+	select {
+	case <-p.queue:
+	default:
+	}
+	p.queue <- p.progress
+}
+
+// stop the reporting and returns the last progress; to be sent with the final status.
+// wait for the progress being sent, so it does not arrive after the final status.
+func (p *progressReporter) stop() *entity.HostProgress {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		if p.pending != nil {
+			p.pending.Stop()
+			p.pending = nil
+		}
+
+		// the unsent one is part of the final status anyway
+		select {
+		case <-p.queue:
+		default:
+		}
+		close(p.queue)
+	}
+	progress := p.progress
+	p.mu.Unlock()
+
+	<-p.done
+
+	return &progress
+}
+
+// stepProgress update the progress from the step executor
+func (p *progressReporter) stepProgress(e *stepExecutor) {
+	running, current, total := e.progress()
+	p.update(func(progress *entity.HostProgress) {
+		progress.Step = strings.Join(running, ",")
+		progress.CurrentStep = current
+		progress.TotalSteps = total
+	})
+}
+
+// progressWriter count the written bytes
+type progressWriter struct {
+	io.Writer
+	onWrite func(n int)
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	if n > 0 {
+		w.onWrite(n)
+	}
+	return n, err
+}
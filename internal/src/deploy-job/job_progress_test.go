@@ -0,0 +1,168 @@
+package deployjob
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// recorder collect the sent progress
+type recorder struct {
+	mu   sync.Mutex
+	sent []entity.HostProgress
+	ch   chan entity.HostProgress
+}
+
+func newRecorder() *recorder {
+	return &recorder{ch: make(chan entity.HostProgress, 100)}
+}
+
+func (r *recorder) send(progress entity.HostProgress) {
+	r.mu.Lock()
+	r.sent = append(r.sent, progress)
+	r.mu.Unlock()
+	r.ch <- progress
+}
+
+func (r *recorder) steps() []uint8 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var steps []uint8
+	for _, progress := range r.sent {
+		steps = append(steps, progress.CurrentStep)
+	}
+	return steps
+}
+
+func setStep(step uint8) func(progress *entity.HostProgress) {
+	return func(progress *entity.HostProgress) { progress.CurrentStep = step }
+}
+
+func TestProgressReporterThrottle(t *testing.T) {
+	r := newRecorder()
+	p := newProgressReporter(200*time.Millisecond, r.send)
+	defer p.stop()
+
+	// the first update is sent right away
+	p.update(setStep(1))
+	select {
+	case progress := <-r.ch:
+		if progress.CurrentStep != 1 {
+			t.Fatalf("expected step 1, got %v", progress.CurrentStep)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("first update is not sent")
+	}
+
+	// the next ones wait for the interval, and only the latest is sent
+	p.update(setStep(2))
+	p.update(setStep(3))
+	select {
+	case progress := <-r.ch:
+		t.Fatalf("expected nothing sent within the interval, got step %v", progress.CurrentStep)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	select {
+	case progress := <-r.ch:
+		if progress.CurrentStep != 3 {
+			t.Fatalf("expected the latest step 3, got %v", progress.CurrentStep)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending update is not sent at the end of the interval")
+	}
+
+	if steps := r.steps(); len(steps) != 2 {
+		t.Fatalf("expected 2 sends, got %v", steps)
+	}
+}
+
+func TestProgressReporterOrder(t *testing.T) {
+	r := newRecorder()
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	first := true
+	p := newProgressReporter(0, func(progress entity.HostProgress) {
+		// only the sender goroutine call this; the first send is slow
+		if first {
+			first = false
+			close(sending)
+			<-release
+		}
+		r.send(progress)
+	})
+
+	p.update(setStep(1))
+	<-sending
+
+	// the update must not wait for the slow send
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		for i := uint8(2); i <= 100; i++ {
+			p.update(setStep(i))
+		}
+	}()
+
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("update is blocked by the send")
+	}
+	close(release)
+
+	// the updates queued meanwhile are sent as the latest one, after the first
+	for _, expected := range []uint8{1, 100} {
+		select {
+		case progress := <-r.ch:
+			if progress.CurrentStep != expected {
+				t.Fatalf("expected step %v, got %v", expected, progress.CurrentStep)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("step %v is not sent", expected)
+		}
+	}
+
+	last := p.stop()
+	if last.CurrentStep != 100 {
+		t.Fatalf("expected the last progress step 100, got %v", last.CurrentStep)
+	}
+	if steps := r.steps(); len(steps) != 2 {
+		t.Fatalf("expected 2 sends, got %v", steps)
+	}
+}
+
+func TestProgressReporterStop(t *testing.T) {
+	r := newRecorder()
+	p := newProgressReporter(time.Hour, r.send)
+
+	p.update(setStep(1))
+	<-r.ch
+	p.update(setStep(2))
+
+	// stop wait for the sent progress, and drop the pending one
+	last := p.stop()
+	if last.CurrentStep != 2 {
+		t.Fatalf("expected the last progress step 2, got %v", last.CurrentStep)
+	}
+	if steps := r.steps(); len(steps) != 1 || steps[0] != 1 {
+		t.Fatalf("expected only step 1 sent, got %v", steps)
+	}
+
+	// nothing is sent after stopped; stop can be called again
+	p.update(setStep(3))
+	if last := p.stop(); last.CurrentStep != 2 {
+		t.Fatalf("expected the progress to stay at step 2, got %v", last.CurrentStep)
+	}
+	if steps := r.steps(); len(steps) != 1 {
+		t.Fatalf("expected no send after stopped, got %v", steps)
+	}
+
+	var stopped *progressReporter
+	if stopped.stop() != nil {
+		t.Fatal("expected nil progress from a nil reporter")
+	}
+}
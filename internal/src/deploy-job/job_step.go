@@ -0,0 +1,350 @@
+package deployjob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errSkipStep returned by a step that has nothing to do (eg. already installed)
+var errSkipStep = errors.New("step skipped")
+
+type retryPolicy struct {
+	Attempts   uint8         // including the first one; 0 or 1 means no retry
+	Backoff    time.Duration // wait between attempts
+	MaxBackoff time.Duration // if set, the wait is doubled every attempt up to this
+}
+
+// wait returns the backoff before the attempt (starting from 1)
+func (r retryPolicy) wait(attempt int) time.Duration {
+	if r.MaxBackoff <= 0 {
+		return r.Backoff
+	}
+
+	backoff := r.Backoff
+	for i := 1; i < attempt && backoff < r.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, r.MaxBackoff)
+}
+
+var _ Job = &step{}
+
+// step is a named unit of work of a job.
+// It only run after all of its dependencies are finished successfully (or skipped).
+type step struct {
+	*jobBase
+
+	DependsOn  []string   `json:"depends_on,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	retry retryPolicy
+	run   func(ctx context.Context) error
+
+	// set by the executor
+	ctx    context.Context
+	mu     *sync.Mutex
+	notify func()
+}
+
+func newStep(name string, run func(ctx context.Context) error, dependsOn ...string) *step {
+	return &step{
+		jobBase:   &jobBase{Name: name, Status: StatusPending},
+		DependsOn: dependsOn,
+		run:       run,
+	}
+}
+
+func (s *step) withRetry(attempts uint8, backoff time.Duration) *step {
+	s.retry = retryPolicy{Attempts: attempts, Backoff: backoff}
+	return s
+}
+
+// withExponentialRetry double the backoff every attempt; the retry still stop when the job is cancelled (eg. timed out)
+func (s *step) withExponentialRetry(attempts uint8, backoff time.Duration, maxBackoff time.Duration) *step {
+	s.retry = retryPolicy{Attempts: attempts, Backoff: backoff, MaxBackoff: maxBackoff}
+	return s
+}
+
+func (s *step) update(fn func()) {
+	s.mu.Lock()
+	fn()
+	s.mu.Unlock()
+
+	if s.notify != nil {
+		s.notify()
+	}
+}
+
+func (s *step) Execute() error {
+	s.update(func() {
+		now := time.Now()
+		s.StartedAt = &now
+		s.Status = StatusInProgress
+	})
+
+	attempts := max(int(s.retry.Attempts), 1)
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			s.update(func() {
+				s.Status = StatusRetrying
+				s.RetryCount++
+			})
+
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(s.retry.wait(attempt)):
+			}
+		}
+
+		// cancelled before (or while waiting to) start
+		if err = s.ctx.Err(); err != nil {
+			break
+		}
+
+		err = s.run(s.ctx)
+		if err == nil || errors.Is(err, errSkipStep) || s.ctx.Err() != nil {
+			break
+		}
+	}
+
+	s.update(func() {
+		now := time.Now()
+		s.FinishedAt = &now
+
+		switch {
+		case err == nil:
+			s.Status = StatusSuccess
+		case errors.Is(err, errSkipStep):
+			s.Status = StatusSkipped
+		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			s.Status = StatusCancelled
+			s.SetErrorMessage(err.Error())
+		default:
+			s.Status = StatusFailed
+			s.SetErrorMessage(err.Error())
+		}
+	})
+
+	if errors.Is(err, errSkipStep) {
+		return nil
+	}
+	return err
+}
+
+var _ Job = &stepExecutor{}
+
+// stepExecutor run the steps following their dependencies; steps that are ready at the same time run concurrently.
+// The first failed step stop the execution, the steps that are not started yet are cancelled.
+type stepExecutor struct {
+	*jobBase
+
+	ctx context.Context
+	log *slog.Logger
+
+	// guard the state of the steps; it can be read while executing
+	mu    sync.Mutex
+	steps []*step
+	index map[string]int
+
+	// optional; called after a step changed its status (eg. to report the progress)
+	onChange func()
+}
+
+func newStepExecutor(ctx context.Context, name string, log *slog.Logger, steps ...*step) *stepExecutor {
+	e := &stepExecutor{
+		jobBase: &jobBase{Name: name, Status: StatusPending, TotalSteps: uint8(len(steps))},
+		ctx:     ctx,
+		log:     log,
+		steps:   steps,
+		index:   make(map[string]int, len(steps)),
+	}
+
+	for idx, s := range steps {
+		s.ctx = ctx
+		s.mu = &e.mu
+		s.notify = e.notify
+		e.index[s.Name] = idx
+	}
+
+	return e
+}
+
+func (e *stepExecutor) Execute() error {
+	for _, s := range e.steps {
+		for _, dependency := range s.DependsOn {
+			if _, ok := e.index[dependency]; !ok {
+				return fmt.Errorf("step %v depends on unknown step %v", s.Name, dependency)
+			}
+		}
+	}
+
+	e.update(func() { e.Status = StatusInProgress })
+
+	for {
+		ready := e.readySteps()
+		if len(ready) == 0 {
+			break
+		}
+
+		errs := make([]error, len(ready))
+		wg := new(sync.WaitGroup)
+		for idx, s := range ready {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				e.log.Info("running step", "step", s.Name)
+				err := s.Execute()
+				if err != nil {
+					e.log.Error("step failed", "step", s.Name, "retry_count", s.RetryCount, "error", err)
+					errs[idx] = fmt.Errorf("step %v: %w", s.Name, err)
+					return
+				}
+				e.log.Info("step finished", "step", s.Name, "status", s.Status, "duration", s.FinishedAt.Sub(*s.StartedAt))
+			}()
+		}
+		wg.Wait()
+
+		e.update(func() { e.CurrentStep += uint8(len(ready)) })
+
+		if err := errors.Join(errs...); err != nil {
+			e.finish(StatusFailed, err)
+			if e.ctx.Err() != nil {
+				e.update(func() { e.Status = StatusCancelled })
+			}
+			return err
+		}
+
+		if err := e.ctx.Err(); err != nil {
+			e.finish(StatusCancelled, err)
+			return err
+		}
+	}
+
+	for _, s := range e.steps {
+		if s.Status == StatusPending {
+			// never ready; the dependencies contain cycle
+			err := fmt.Errorf("step %v is never ready; check the dependency cycle", s.Name)
+			e.finish(StatusFailed, err)
+			return err
+		}
+	}
+
+	e.update(func() { e.Status = StatusSuccess })
+	return nil
+}
+
+// readySteps returns the pending steps that all of the dependencies are finished
+func (e *stepExecutor) readySteps() []*step {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var ready []*step
+	for _, s := range e.steps {
+		if s.Status != StatusPending {
+			continue
+		}
+
+		isReady := true
+		for _, dependency := range s.DependsOn {
+			switch e.steps[e.index[dependency]].Status {
+			case StatusSuccess, StatusSkipped:
+			default:
+				isReady = false
+			}
+		}
+
+		if isReady {
+			ready = append(ready, s)
+		}
+	}
+
+	return ready
+}
+
+// finish mark the steps that are not started as cancelled
+func (e *stepExecutor) finish(status Status, err error) {
+	e.update(func() {
+		for _, s := range e.steps {
+			if s.Status == StatusPending {
+				s.Status = StatusCancelled
+			}
+		}
+		e.Status = status
+		e.SetErrorMessage(err.Error())
+	})
+}
+
+func (e *stepExecutor) notify() {
+	if e.onChange != nil {
+		e.onChange()
+	}
+}
+
+// progress returns the running steps, the number of finished steps and the total
+func (e *stepExecutor) progress() ([]string, uint8, uint8) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var running []string
+	var finished uint8
+	for _, s := range e.steps {
+		switch s.Status {
+		case StatusInProgress, StatusRetrying:
+			running = append(running, s.Name)
+		case StatusSuccess, StatusSkipped, StatusFailed, StatusCancelled:
+			finished++
+		}
+	}
+
+	return running, finished, e.TotalSteps
+}
+
+func (e *stepExecutor) update(fn func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fn()
+}
+
+// GetDAG returns a snapshot of the steps
+func (e *stepExecutor) GetDAG() DAG {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	dag := DAG{
+		Vertices: make([]Job, 0, len(e.steps)),
+		Edges:    make([]uint8, 0),
+	}
+
+	for idx, s := range e.steps {
+		snapshot := *s
+		base := *s.jobBase
+		snapshot.jobBase = &base
+		dag.Vertices = append(dag.Vertices, &snapshot)
+
+		for _, dependency := range s.DependsOn {
+			dag.Edges = append(dag.Edges, uint8(e.index[dependency]), uint8(idx))
+		}
+	}
+
+	return dag
+}
+
+func (e *stepExecutor) GetCurrentSteps() uint8 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.CurrentStep
+}
+
+func (e *stepExecutor) GetStatus() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.Status
+}
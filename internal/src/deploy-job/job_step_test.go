@@ -0,0 +1,252 @@
+package deployjob
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errStepFailed = errors.New("step failed")
+
+func newTestExecutor(ctx context.Context, steps ...*step) *stepExecutor {
+	return newStepExecutor(ctx, "test", slog.New(slog.DiscardHandler), steps...)
+}
+
+// expectStatus check the status of the steps by name
+func expectStatus(t *testing.T, e *stepExecutor, expected map[string]Status) {
+	t.Helper()
+
+	for name, status := range expected {
+		if actual := e.steps[e.index[name]].Status; actual != status {
+			t.Errorf("step %v: expected status %v, got %v", name, status, actual)
+		}
+	}
+}
+
+func TestStepExecutorDependencyOrder(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	// b & c are ready at the same time; each wait for the other to start
+	bStarted, cStarted := make(chan struct{}), make(chan struct{})
+	together := func(name string, started chan struct{}, other chan struct{}) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			close(started)
+			select {
+			case <-other:
+			case <-time.After(time.Second):
+				return errors.New(name + " is not run concurrently")
+			}
+			return record(name)(ctx)
+		}
+	}
+
+	e := newTestExecutor(context.Background(),
+		newStep("d", record("d"), "b", "c"),
+		newStep("b", together("b", bStarted, cStarted), "a"),
+		newStep("c", together("c", cStarted, bStarted), "a"),
+		newStep("a", record("a")),
+	)
+
+	if err := e.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(order) != 4 || order[0] != "a" || order[3] != "d" {
+		t.Fatalf("expected a first and d last, got %v", order)
+	}
+	if e.GetStatus() != StatusSuccess || e.GetCurrentSteps() != 4 {
+		t.Fatalf("expected success with 4 steps finished, got %v with %v", e.GetStatus(), e.GetCurrentSteps())
+	}
+}
+
+func TestStepExecutorUnknownDependency(t *testing.T) {
+	ran := false
+	e := newTestExecutor(context.Background(),
+		newStep("a", func(ctx context.Context) error { ran = true; return nil }),
+		newStep("b", func(ctx context.Context) error { ran = true; return nil }, "missing"),
+	)
+
+	err := e.Execute()
+	if err == nil || !strings.Contains(err.Error(), "unknown step missing") {
+		t.Fatalf("expected unknown step error, got %v", err)
+	}
+	if ran {
+		t.Fatal("no step should run when a dependency is unknown")
+	}
+}
+
+func TestStepExecutorCycle(t *testing.T) {
+	noop := func(ctx context.Context) error { return nil }
+	e := newTestExecutor(context.Background(),
+		newStep("a", noop),
+		newStep("b", noop, "a", "c"),
+		newStep("c", noop, "b"),
+	)
+
+	err := e.Execute()
+	if err == nil || !strings.Contains(err.Error(), "never ready") {
+		t.Fatalf("expected never ready error, got %v", err)
+	}
+
+	expectStatus(t, e, map[string]Status{"a": StatusSuccess, "b": StatusCancelled, "c": StatusCancelled})
+	if e.GetStatus() != StatusFailed {
+		t.Fatalf("expected executor failed, got %v", e.GetStatus())
+	}
+}
+
+func TestStepExecutorSkip(t *testing.T) {
+	ran := false
+	e := newTestExecutor(context.Background(),
+		newStep("a", func(ctx context.Context) error { return errSkipStep }),
+		newStep("b", func(ctx context.Context) error { ran = true; return nil }, "a"),
+	)
+
+	if err := e.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a skipped step still let its dependents run
+	expectStatus(t, e, map[string]Status{"a": StatusSkipped, "b": StatusSuccess})
+	if !ran {
+		t.Fatal("dependent of a skipped step is not run")
+	}
+}
+
+func TestStepExecutorRetry(t *testing.T) {
+	calls := 0
+	flaky := newStep("flaky", func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errStepFailed
+		}
+		return nil
+	}).withRetry(3, time.Millisecond)
+
+	e := newTestExecutor(context.Background(), flaky)
+	if err := e.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 || flaky.RetryCount != 2 || flaky.Status != StatusSuccess {
+		t.Fatalf("expected success after 3 calls & 2 retries, got %v calls, %v retries, status %v", calls, flaky.RetryCount, flaky.Status)
+	}
+}
+
+func TestStepExecutorRetryExhausted(t *testing.T) {
+	calls := 0
+	e := newTestExecutor(context.Background(),
+		newStep("broken", func(ctx context.Context) error { calls++; return errStepFailed }).withRetry(2, time.Millisecond),
+		newStep("after", func(ctx context.Context) error { return nil }, "broken"),
+	)
+
+	err := e.Execute()
+	if !errors.Is(err, errStepFailed) {
+		t.Fatalf("expected the step error, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 attempts, got %v", calls)
+	}
+
+	// the steps that are not started are cancelled
+	expectStatus(t, e, map[string]Status{"broken": StatusFailed, "after": StatusCancelled})
+	if e.GetStatus() != StatusFailed {
+		t.Fatalf("expected executor failed, got %v", e.GetStatus())
+	}
+}
+
+func TestStepExecutorCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	e := newTestExecutor(ctx,
+		newStep("wait", func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		newStep("after", func(ctx context.Context) error { return nil }, "wait"),
+	)
+
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	err := e.Execute()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+
+	expectStatus(t, e, map[string]Status{"wait": StatusCancelled, "after": StatusCancelled})
+	if e.GetStatus() != StatusCancelled {
+		t.Fatalf("expected executor cancelled, got %v", e.GetStatus())
+	}
+}
+
+func TestStepRetryStopWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	e := newTestExecutor(ctx,
+		newStep("broken", func(ctx context.Context) error {
+			calls++
+			cancel()
+			return errStepFailed
+		}).withRetry(5, time.Hour),
+	)
+
+	done := make(chan error)
+	go func() { done <- e.Execute() }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("retry is still waiting after cancelled")
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected no retry after cancelled, got %v calls", calls)
+	}
+	expectStatus(t, e, map[string]Status{"broken": StatusFailed})
+}
+
+func TestRetryPolicyWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   retryPolicy
+		attempt  int
+		expected time.Duration
+	}{
+		{"fixed", retryPolicy{Attempts: 3, Backoff: 2 * time.Second}, 1, 2 * time.Second},
+		{"fixed later attempt", retryPolicy{Attempts: 3, Backoff: 2 * time.Second}, 3, 2 * time.Second},
+		{"exponential first", retryPolicy{Attempts: 8, Backoff: 2 * time.Second, MaxBackoff: time.Minute}, 1, 2 * time.Second},
+		{"exponential doubled", retryPolicy{Attempts: 8, Backoff: 2 * time.Second, MaxBackoff: time.Minute}, 3, 8 * time.Second},
+		{"exponential capped", retryPolicy{Attempts: 8, Backoff: 2 * time.Second, MaxBackoff: time.Minute}, 7, time.Minute},
+		{"max below backoff", retryPolicy{Attempts: 2, Backoff: time.Minute, MaxBackoff: time.Second}, 1, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.policy.wait(tt.attempt); actual != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/desain-gratis/common/lib/notifier"
@@ -16,14 +17,23 @@ type jobsController struct {
 	dependencies *Dependencies
 	host         *entity.Host
 
-	// todo: can add lock
+	// guard the job pool; events, recovery & http handler access it concurrently
+	mu                sync.Mutex
 	deploymentJobPool map[string]*deploymentJob
 
+	// per service; serialize the work that touch the installed service, including the drift repair
+	serviceLocks map[string]*sync.Mutex
+
+	// bounded host work (see submit) & artifact download
+	workers   semaphore
+	downloads semaphore
+
+	// per namespace
+	artifactPolicies map[string]ArtifactPolicy
+
 	// controller level log
 	log *slog.Logger
 
-	// TODO: use worker pool B-)
-
 	// TODO: later, after have many job types,
 	// consider this jobsController can contain multiple types of job or just single
 
@@ -33,7 +43,9 @@ type jobsController struct {
 const minimumTimeOutIfConfiguredSeconds = 30
 
 func (w *jobsController) configureHost(out notifier.Topic, jobDefinition entity.DeploymentJob) {
-	// todo: prepare locking
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	if _, ok := w.deploymentJobPool[getKey(jobDefinition)]; ok {
 		return
 	}
@@ -45,14 +57,30 @@ func (w *jobsController) configureHost(out notifier.Topic, jobDefinition entity.
 
 	// Validate job state, if it's already configured, we wont execute
 
+	job := w.newDeploymentJob(out, jobDefinition)
+
+	// insert into job pool
+	w.deploymentJobPool[getKey(jobDefinition)] = job
+	w.evictPreviousJobs(jobDefinition)
+
+	w.submit(job, (*deploymentJob).startConfigureHost)
+}
+
+func (w *jobsController) newDeploymentJob(out notifier.Topic, jobDefinition entity.DeploymentJob) *deploymentJob {
 	ctx, cancel := context.WithCancel(context.Background())
 	job := &deploymentJob{
-		ctx:    ctx,
-		cancel: cancel,
+		ctx:     ctx,
+		cancel:  cancel,
+		running: new(sync.WaitGroup),
+		subJobs: &subJobs{},
 
 		topic:        out,
 		host:         w.host,
 		dependencies: w.dependencies,
+		downloads:    w.downloads,
+		serviceLock:  w.serviceLock(jobDefinition.Ns, jobDefinition.Request.Service.Id),
+
+		artifactPolicy: w.artifactPolicies[jobDefinition.Ns],
 
 		Job: jobDefinition,
 
@@ -76,20 +104,96 @@ func (w *jobsController) configureHost(out notifier.Topic, jobDefinition entity.
 
 	job.log = logger
 
-	// insert into job pool
-	w.deploymentJobPool[getKey(jobDefinition)] = job
+	return job
+}
+
+// retryHost re-run the configure / restart phase of this host.
+// the job might not be in the pool anymore (eg. deployd restarted), so we create it if needed.
+func (w *jobsController) retryHost(out notifier.Topic, event deployjob.EventHostRetry) {
+	if event.TargetHost != w.host.Host {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	job := w.getOrCreateJob(out, event.Job)
+
+	// previous run might be cancelled; start over with a new job
+	if job.cancelled {
+		job = w.newDeploymentJob(out, event.Job)
+		w.deploymentJobPool[getKey(event.Job)] = job
+	}
 
-	// TODO: use go-routine pooling / other library
-	go job.startConfigureHost()
+	job.Job = event.Job
+	job.Status = StatusRetrying
+
+	switch event.Phase {
+	case deployjob.RetryPhaseConfiguration:
+		job.RetryCount = event.Job.Configuration.Status[w.host.Host].RetryCount
+		job.log.Info("retrying host configuration", "retry_count", job.RetryCount)
+		w.submit(job, (*deploymentJob).startConfigureHost)
+	case deployjob.RetryPhaseDeployment:
+		job.RetryCount = event.Job.Deployment.Status[w.host.Host].RetryCount
+		job.log.Info("retrying service restart", "retry_count", job.RetryCount)
+		w.submit(job, (*deploymentJob).startRestartHostService)
+	case deployjob.RetryPhaseDecommission:
+		job.RetryCount = event.Job.Decommission.Status[w.host.Host].RetryCount
+		job.log.Info("retrying decommission", "retry_count", job.RetryCount)
+		w.submit(job, (*deploymentJob).startDecommissionHost)
+	}
 }
 
+// cancelDeployment stop the timed out job; it will not progress anymore
 func (w *jobsController) cancelDeployment(_ notifier.Topic, jobDefinition entity.DeploymentJob) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	job, ok := w.deploymentJobPool[getKey(jobDefinition)]
 	if !ok {
 		return
 	}
 
+	job.cancelled = true
+	job.cancel()
+	delete(w.deploymentJobPool, getKey(jobDefinition))
+}
+
+// finishJob remove the job from the pool once all hosts finished cleaning up
+func (w *jobsController) finishJob(_ notifier.Topic, jobDefinition entity.DeploymentJob) {
+	job, ok := w.getJob(getKey(jobDefinition))
+	if !ok {
+		return
+	}
+
+	w.evictJob(job)
+}
+
+// cancelJob stop the job in this host and acknowledge it to raft (with rollback, if requested)
+func (w *jobsController) cancelJob(out notifier.Topic, event deployjob.EventDeploymentJobCancelled) {
+	_, isConfigurationHost := event.Job.Configuration.Status[w.host.Host]
+	_, isDeploymentHost := event.Job.Deployment.Status[w.host.Host]
+	if !isConfigurationHost && !isDeploymentHost {
+		// not part of the deployment worker
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// might never started here (eg. deployd restarted); still need to acknowledge
+	job := w.getOrCreateJob(out, event.Job)
+
+	// mark it under the lock, so no work is submitted while waiting for the running one
+	job.cancelled = true
 	job.cancel()
+	job.Job = event.Job
+
+	run := job.snapshot()
+	go func() {
+		run.acknowledgeCancel()
+		w.evictJob(job)
+	}()
 }
 
 func (w *jobsController) confirmDeploymentAsUserIfEnabled(_ notifier.Topic, event deployjob.EventAllHostConfigured) {
@@ -128,7 +232,7 @@ func (w *jobsController) confirmDeploymentAsUserIfEnabled(_ notifier.Topic, even
 func (w *jobsController) continueRestartServiceAsUserIfEnabled(_ notifier.Topic, event deployjob.EventServiceRestarted) {
 	log := w.log
 
-	if event.Job.Status == entity.DeploymentJobStatusDeployed {
+	if event.Job.Status != entity.DeploymentJobStatusDeploying {
 		// log.Info("all service has been restarted successfully")
 		return
 	}
@@ -163,16 +267,77 @@ func (w *jobsController) continueRestartServiceAsUserIfEnabled(_ notifier.Topic,
 		"host", w.host.Host, "job_status", result.Job.Status, "current_step", result.Step)
 }
 
-func (w *jobsController) restartService(_ notifier.Topic, event deployjob.EventRestartConfirmed) {
-	job, ok := w.deploymentJobPool[getKey(event.Job)]
-	if !ok {
-		// should not be possibre, if we already configure, the job should still be there
+func (w *jobsController) restartService(out notifier.Topic, event deployjob.EventRestartConfirmed) {
+	targetHosts := event.TargetHosts
+	if len(targetHosts) == 0 {
+		targetHosts = []string{event.TargetHost}
+	}
+
+	for _, targetHost := range targetHosts {
+		if targetHost != w.host.Host {
+			continue
+		}
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		// might be configured before deployd restarted
+		job := w.getOrCreateJob(out, event.Job)
+
+		job.Job = event.Job
+		w.submit(job, (*deploymentJob).startRestartHostService)
+		return
+	}
+}
+
+func (w *jobsController) watchCanary(out notifier.Topic, event deployjob.EventCanaryStarted) {
+	if !event.Job.Deployment.IsCanary(w.host.Host) || event.Job.Deployment.BakeUntil == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// might be restarted before deployd restarted
+	job := w.getOrCreateJob(out, event.Job)
+
+	job.Job = event.Job
+
+	// only wait; not using the worker pool
+	bakeUntil := *event.Job.Deployment.BakeUntil
+	w.watch(job, func(run *deploymentJob) { run.watchCanary(bakeUntil) })
+}
+
+// decommissionHost uninstall the service if this host is removed from the service
+func (w *jobsController) decommissionHost(out notifier.Topic, event deployjob.EventDecommissionStarted) {
+	if _, ok := event.Job.Decommission.Status[w.host.Host]; !ok {
 		return
 	}
 
-	if event.TargetHost == w.host.Host {
-		job.startRestartHostService()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	job := w.getOrCreateJob(out, event.Job)
+
+	job.Job = event.Job
+
+	w.submit(job, (*deploymentJob).startDecommissionHost)
+}
+
+// cleanupHost prune the old releases if this host is deployed by the job
+func (w *jobsController) cleanupHost(out notifier.Topic, event deployjob.EventCleanupStarted) {
+	if _, ok := event.Job.Cleanup.Status[w.host.Host]; !ok {
+		return
 	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	job := w.getOrCreateJob(out, event.Job)
+
+	job.Job = event.Job
+
+	w.submit(job, (*deploymentJob).startCleanupHost)
 }
 
 func getKey(job entity.DeploymentJob) string {
@@ -0,0 +1,173 @@
+package deployjob
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/desain-gratis/common/lib/notifier"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// used when the controller config is not set
+const (
+	defaultWorkers   = 4
+	defaultDownloads = 2
+)
+
+type ControllerConfig struct {
+	// maximum host work (configure, restart, decommission, cleanup) running at the same time
+	Workers int
+
+	// maximum artifact downloaded at the same time by this host
+	Downloads int
+
+	// artifact verification per namespace; namespace without policy only verify the digest
+	ArtifactPolicies map[string]ArtifactPolicy
+}
+
+// semaphore limit the number of goroutine doing the same kind of work
+type semaphore chan struct{}
+
+func newSemaphore(size int, defaultSize int) semaphore {
+	if size <= 0 {
+		size = defaultSize
+	}
+	return make(semaphore, size)
+}
+
+// acquire wait for a free slot; returns the context error if cancelled while waiting
+func (s semaphore) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	<-s
+}
+
+// submit run the host work on the worker pool.
+// the work is counted as running immediately, so cancellation wait for the queued work too.
+// caller must hold the lock.
+func (w *jobsController) submit(job *deploymentJob, work func(run *deploymentJob)) {
+	w.start(job, work, true)
+}
+
+// watch run the host work that only wait (eg. canary bake) outside of the worker pool; still counted as running.
+// caller must hold the lock.
+func (w *jobsController) watch(job *deploymentJob, work func(run *deploymentJob)) {
+	w.start(job, work, false)
+}
+
+// start run the work with a snapshot of the job, so the next event can update the job in the pool meanwhile
+func (w *jobsController) start(job *deploymentJob, work func(run *deploymentJob), pooled bool) {
+	if job.cancelled {
+		job.log.Info("job is cancelled; not starting the work")
+		return
+	}
+
+	run := job.snapshot()
+
+	job.running.Add(1)
+	go func() {
+		defer job.running.Done()
+
+		if pooled {
+			// cancelled while waiting for a worker; acknowledgeCancel report the host state
+			if err := w.workers.acquire(run.ctx); err != nil {
+				return
+			}
+			defer w.workers.release()
+		}
+
+		if run.ctx.Err() != nil {
+			return
+		}
+
+		work(run)
+	}()
+}
+
+// getJob returns the job in the pool
+func (w *jobsController) getJob(key string) (*deploymentJob, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	job, ok := w.deploymentJobPool[key]
+	return job, ok
+}
+
+// getOrCreateJob returns the job in the pool; created if not exist (eg. deployd restarted).
+// caller must hold the lock.
+func (w *jobsController) getOrCreateJob(out notifier.Topic, jobDefinition entity.DeploymentJob) *deploymentJob {
+	job, ok := w.deploymentJobPool[getKey(jobDefinition)]
+	if !ok {
+		job = w.newDeploymentJob(out, jobDefinition)
+		w.deploymentJobPool[getKey(jobDefinition)] = job
+	}
+	return job
+}
+
+// evictJob remove the job from the pool, if it is not replaced already
+func (w *jobsController) evictJob(job *deploymentJob) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := getKey(job.Job)
+	if w.deploymentJobPool[key] == job {
+		delete(w.deploymentJobPool, key)
+	}
+}
+
+// evictPreviousJobs remove the older jobs of the service; a new job is only created after the previous one finished.
+// this is the only way a failed job leave the pool. caller must hold the lock.
+func (w *jobsController) evictPreviousJobs(jobDefinition entity.DeploymentJob) {
+	prefix := servicePrefix(jobDefinition.Ns, jobDefinition.Request.Service.Id)
+	for key := range w.deploymentJobPool {
+		if strings.HasPrefix(key, prefix) && key != getKey(jobDefinition) {
+			delete(w.deploymentJobPool, key)
+		}
+	}
+}
+
+// hasServiceJob returns true if a job of the service is in the pool
+func (w *jobsController) hasServiceJob(ns, service string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	prefix := servicePrefix(ns, service)
+	for key := range w.deploymentJobPool {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// getServiceLock returns the lock of the service
+func (w *jobsController) getServiceLock(ns, service string) *sync.Mutex {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.serviceLock(ns, service)
+}
+
+// serviceLock returns the lock of the service; created if not exist. caller must hold the lock.
+func (w *jobsController) serviceLock(ns, service string) *sync.Mutex {
+	key := servicePrefix(ns, service)
+	lock, ok := w.serviceLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		w.serviceLocks[key] = lock
+	}
+	return lock
+}
+
+// servicePrefix is the key prefix of all jobs of the service (see getKey)
+func servicePrefix(ns, service string) string {
+	return strings.Join([]string{ns, service, ""}, "\\")
+}
@@ -0,0 +1,274 @@
+package deployjob
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func newTestController(workers int) *jobsController {
+	return &jobsController{
+		host:              &entity.Host{Host: "host-1"},
+		deploymentJobPool: make(map[string]*deploymentJob),
+		serviceLocks:      make(map[string]*sync.Mutex),
+		workers:           newSemaphore(workers, defaultWorkers),
+		downloads:         newSemaphore(0, defaultDownloads),
+		log:               slog.New(slog.DiscardHandler),
+	}
+}
+
+func testJobDefinition(service string, id int) entity.DeploymentJob {
+	return entity.DeploymentJob{
+		Ns: "test",
+		Id: strconv.Itoa(id),
+		Request: entity.SubmitDeploymentJobRequest{
+			Ns:      "test",
+			Service: entity.ServiceDefinition{Id: service},
+		},
+	}
+}
+
+// addJob put a new job into the pool
+func (w *jobsController) addJob(jobDefinition entity.DeploymentJob) *deploymentJob {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.getOrCreateJob(nil, jobDefinition)
+}
+
+func TestSemaphore(t *testing.T) {
+	if s := newSemaphore(0, 3); cap(s) != 3 {
+		t.Fatalf("expected the default size 3, got %v", cap(s))
+	}
+
+	s := newSemaphore(2, defaultWorkers)
+	ctx := context.Background()
+	for range 2 {
+		if err := s.acquire(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// full; wait until released
+	acquired := make(chan error)
+	go func() { acquired <- s.acquire(ctx) }()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquired more than the semaphore size")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not acquired after released")
+	}
+
+	// cancelled while waiting
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := s.acquire(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestEvictJob(t *testing.T) {
+	w := newTestController(1)
+	definition := testJobDefinition("svc", 1)
+
+	job := w.addJob(definition)
+	w.evictJob(job)
+	if _, ok := w.getJob(getKey(definition)); ok {
+		t.Fatal("expected the job evicted")
+	}
+
+	// replaced meanwhile (eg. retried after cancelled); keep the new one
+	previous := w.addJob(definition)
+	w.mu.Lock()
+	replacement := w.newDeploymentJob(nil, definition)
+	w.deploymentJobPool[getKey(definition)] = replacement
+	w.mu.Unlock()
+
+	w.evictJob(previous)
+	if job, ok := w.getJob(getKey(definition)); !ok || job != replacement {
+		t.Fatal("expected the replacement job kept in the pool")
+	}
+}
+
+func TestEvictPreviousJobs(t *testing.T) {
+	w := newTestController(1)
+	w.addJob(testJobDefinition("svc", 1))
+	w.addJob(testJobDefinition("svc", 2))
+	w.addJob(testJobDefinition("svc-2", 1))
+	current := testJobDefinition("svc", 3)
+	w.addJob(current)
+
+	w.mu.Lock()
+	w.evictPreviousJobs(current)
+	w.mu.Unlock()
+
+	for _, expected := range []struct {
+		definition entity.DeploymentJob
+		inPool     bool
+	}{
+		{testJobDefinition("svc", 1), false},
+		{testJobDefinition("svc", 2), false},
+		{testJobDefinition("svc-2", 1), true},
+		{current, true},
+	} {
+		if _, ok := w.getJob(getKey(expected.definition)); ok != expected.inPool {
+			t.Errorf("job %v: expected in pool %v, got %v", getKey(expected.definition), expected.inPool, ok)
+		}
+	}
+
+	if !w.hasServiceJob("test", "svc") || !w.hasServiceJob("test", "svc-2") || w.hasServiceJob("test", "svc-3") {
+		t.Fatal("unexpected service job in the pool")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	w := newTestController(1)
+	job := w.addJob(testJobDefinition("svc", 1))
+
+	w.mu.Lock()
+	run := job.snapshot()
+	w.mu.Unlock()
+
+	// the next event update the job in the pool; the run keep its own copy
+	job.Status = StatusRetrying
+	job.RetryCount = 3
+	job.Job.Status = entity.DeploymentJobStatusDeploying
+
+	if run.Status != StatusPending || run.RetryCount != 0 || run.Job.Status != "" {
+		t.Fatalf("snapshot is updated by the job: %+v", run.jobBase)
+	}
+
+	// the sub-jobs & the running work are shared, so cancellation can see them
+	if run.subJobs != job.subJobs || run.running != job.running || run.serviceLock != job.serviceLock {
+		t.Fatal("expected the sub-jobs, running work & service lock shared with the job")
+	}
+}
+
+func TestStartCancelledJob(t *testing.T) {
+	w := newTestController(1)
+	job := w.addJob(testJobDefinition("svc", 1))
+
+	w.mu.Lock()
+	job.cancelled = true
+	w.submit(job, func(run *deploymentJob) { t.Error("cancelled job is started") })
+	w.mu.Unlock()
+
+	job.running.Wait()
+}
+
+func TestSubmitCancelledWhileQueued(t *testing.T) {
+	w := newTestController(1)
+	busy := w.addJob(testJobDefinition("svc", 1))
+	queued := w.addJob(testJobDefinition("svc-2", 1))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	w.mu.Lock()
+	w.submit(busy, func(run *deploymentJob) {
+		close(started)
+		<-release
+	})
+	w.mu.Unlock()
+	<-started
+
+	// the only worker is busy
+	w.mu.Lock()
+	w.submit(queued, func(run *deploymentJob) { t.Error("work is run after cancelled") })
+	w.mu.Unlock()
+
+	w.cancelDeployment(nil, queued.Job)
+
+	// the queued work is still counted as running until it gives up
+	done := make(chan struct{})
+	go func() {
+		queued.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queued work is not stopped after cancelled")
+	}
+
+	close(release)
+	busy.running.Wait()
+}
+
+// run with -race; events of the same job arrive concurrently while the previous work is running
+func TestConcurrentEventsOfTheSameJob(t *testing.T) {
+	w := newTestController(2)
+	definition := testJobDefinition("svc", 1)
+
+	var mu sync.Mutex
+	var runs int
+	work := func(run *deploymentJob) {
+		// the run own its copy; no lock needed
+		run.Status = StatusInProgress
+		run.RetryCount++
+		_ = run.Job.Status
+
+		mu.Lock()
+		runs++
+		mu.Unlock()
+	}
+
+	// guarded by the controller lock; evicted jobs might still be running
+	var submitted []*deploymentJob
+
+	events := new(sync.WaitGroup)
+	for idx := range 20 {
+		events.Add(1)
+		go func() {
+			defer events.Done()
+
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			job := w.getOrCreateJob(nil, definition)
+			job.Job.Status = entity.DeploymentJobStatusDeploying
+			job.Status = StatusRetrying
+			job.RetryCount = uint8(idx)
+			w.submit(job, work)
+			submitted = append(submitted, job)
+		}()
+	}
+
+	events.Add(2)
+	go func() {
+		defer events.Done()
+		if job, ok := w.getJob(getKey(definition)); ok {
+			w.evictJob(job)
+		}
+	}()
+	go func() {
+		defer events.Done()
+		_ = w.hasServiceJob(definition.Ns, definition.Request.Service.Id)
+	}()
+	events.Wait()
+
+	for _, job := range submitted {
+		job.running.Wait()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if runs != len(submitted) {
+		t.Fatalf("expected %v runs, got %v", len(submitted), runs)
+	}
+}
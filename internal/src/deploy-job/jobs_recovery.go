@@ -0,0 +1,183 @@
+package deployjob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	content_chraft "github.com/desain-gratis/common/delivery/mycontent-api/storage/content/clickhouse-raft"
+	"github.com/desain-gratis/common/lib/notifier"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// inFlightJobs returns the non-terminal jobs that this host still need to work on.
+// The events of these jobs might be delivered while deployd was down, so the job pool have to be rebuilt.
+func (w *jobsController) inFlightJobs(ctx context.Context) ([]entity.DeploymentJob, error) {
+	services, err := w.dependencies.ServiceDefinitionUsecase.Get(ctx, "*", nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []entity.DeploymentJob
+	for _, service := range services {
+		// the latest jobs of the service are enough; old one should already be finished
+		jobs, err := w.dependencies.JobUsecase.Get(ctx, service.Ns, []string{service.Id}, "")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, job := range jobs {
+			if job.Status.IsTerminal() || job.Status == entity.DeploymentJobStatusQueued {
+				continue
+			}
+			if !w.isJobHost(*job) {
+				continue
+			}
+			result = append(result, *job)
+		}
+	}
+
+	return result, nil
+}
+
+func (w *jobsController) isJobHost(job entity.DeploymentJob) bool {
+	host := w.host.Host
+	_, isConfigurationHost := job.Configuration.Status[host]
+	_, isDeploymentHost := job.Deployment.Status[host]
+	_, isDecommissionHost := job.Decommission.Status[host]
+	_, isCleanupHost := job.Cleanup.Status[host]
+	return isConfigurationHost || isDeploymentHost || isDecommissionHost || isCleanupHost
+}
+
+// recoverJob put back the job into the pool and resume the phase of this host
+func (w *jobsController) recoverJob(out notifier.Topic, jobDefinition entity.DeploymentJob) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.deploymentJobPool[getKey(jobDefinition)]; ok {
+		// an event for the job is already handled after deployd started
+		return
+	}
+
+	job := w.newDeploymentJob(out, jobDefinition)
+	w.deploymentJobPool[getKey(jobDefinition)] = job
+
+	host := w.host.Host
+	log := job.log.With("node", "recovery")
+
+	switch jobDefinition.Status {
+	case entity.DeploymentJobStatusConfiguring:
+		switch jobDefinition.Configuration.Status[host].Status {
+		case entity.HostConfigurationStatusPending, entity.HostConfigurationStatusConfiguring:
+			// configure is skipping what is already installed
+			log.Info("resuming host configuration", "host_status", jobDefinition.Configuration.Status[host].Status)
+			w.submit(job, (*deploymentJob).startConfigureHost)
+		}
+
+	case entity.DeploymentJobStatusDeploying:
+		if !jobDefinition.Deployment.InCurrentBatch(host) {
+			// not our turn yet; wait for the restart confirmation
+			return
+		}
+
+		switch jobDefinition.Deployment.Status[host].Status {
+		case entity.HostDeploymentStatusSuccess,
+			entity.HostDeploymentStatusFailed,
+			entity.HostDeploymentStatusTimeOut,
+			entity.HostDeploymentStatusCancelled,
+			entity.HostDeploymentStatusRolledBack:
+			return
+		}
+
+		log.Info("resuming service restart", "host_status", jobDefinition.Deployment.Status[host].Status)
+		w.submit(job, (*deploymentJob).resumeRestartHostService)
+
+	case entity.DeploymentJobStatusCanary:
+		if jobDefinition.Deployment.IsCanary(host) && jobDefinition.Deployment.BakeUntil != nil {
+			log.Info("resuming canary watch", "bake_until", jobDefinition.Deployment.BakeUntil)
+			bakeUntil := *jobDefinition.Deployment.BakeUntil
+			w.watch(job, func(run *deploymentJob) { run.watchCanary(bakeUntil) })
+		}
+
+	case entity.DeploymentJobStatusDecommissioning:
+		switch jobDefinition.Decommission.Status[host].Status {
+		case entity.HostDecommissionStatusPending, entity.HostDecommissionStatusUninstalling:
+			log.Info("resuming decommission", "host_status", jobDefinition.Decommission.Status[host].Status)
+			w.submit(job, (*deploymentJob).startDecommissionHost)
+		}
+
+	case entity.DeploymentJobStatusDeployed:
+		switch jobDefinition.Cleanup.Status[host].Status {
+		case entity.HostCleanupStatusPending, entity.HostCleanupStatusCleaning:
+			log.Info("resuming cleanup", "host_status", jobDefinition.Cleanup.Status[host].Status)
+			w.submit(job, (*deploymentJob).startCleanupHost)
+		}
+	}
+}
+
+// isDeployed returns true if the host already run the requested version (symlinks switched & service active)
+func isDeployed(ctx context.Context, request entity.SubmitDeploymentJobRequest) (bool, error) {
+	serviceName := fmt.Sprintf("%v_%v", request.Ns, request.Service.Id)
+
+	if linkedVersion(filepath.Join("/opt", serviceName, "current")) != strconv.FormatUint(request.BuildVersion, 10) {
+		return false, nil
+	}
+	if linkedVersion(filepath.Join("/etc", serviceName, "env")) != strconv.FormatUint(request.EnvVersion, 10) {
+		return false, nil
+	}
+
+	activeState, err := unitActiveState(ctx, serviceName+".service")
+	if err != nil {
+		return false, err
+	}
+
+	return activeState == "active", nil
+}
+
+// resumeRestartHostService restart the service again, unless the restart is already finished
+// before deployd is stopped (but not reported)
+func (d *deploymentJob) resumeRestartHostService() {
+	deployed, err := isDeployed(d.ctx, d.Job.Request)
+	if err != nil || !deployed {
+		d.startRestartHostService()
+		return
+	}
+
+	d.log.Info("service is already restarted; reporting the result")
+
+	_, err = d.dependencies.RaftJobUsecase.FeedHostRestartServiceUpdate(d.ctx, deployjob.HostRestartServiceUpdateRequest{
+		Ns:        d.Job.Ns,
+		JobId:     d.Job.Id,
+		Service:   d.Job.Request.Service.Id,
+		HostName:  d.host.Host,
+		Status:    entity.HostDeploymentStatusSuccess,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		d.log.Warn("failed to notify deployment status to manager. manager should check this host.", "error", err)
+	}
+}
+
+// waitInFlightJobs retry until the job table is readable (the replica might still catching up)
+func (w *jobsController) waitInFlightJobs(ctx context.Context) ([]entity.DeploymentJob, error) {
+	for {
+		jobs, err := w.inFlightJobs(ctx)
+		if err == nil {
+			return jobs, nil
+		}
+		if !errors.Is(err, content_chraft.ErrNotReady) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
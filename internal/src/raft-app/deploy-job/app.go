@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand/v2"
-	"path"
+	"time"
 
 	mycontent_base "github.com/desain-gratis/common/delivery/mycontent-api/mycontent/base"
+	"github.com/desain-gratis/common/delivery/mycontent-api/storage/content"
 	content_chraft "github.com/desain-gratis/common/delivery/mycontent-api/storage/content/clickhouse-raft"
 	"github.com/desain-gratis/common/lib/notifier"
 	"github.com/desain-gratis/common/lib/raft"
@@ -20,18 +20,68 @@ import (
 const (
 	TableDeploymentJob       = "deployment_job"
 	TableServiceInstanceHost = "service_instance_host"
+	TableHostPort            = "host_port"
+	TableJobAudit            = "job_audit"
+	TableFreeze              = "freeze"
+	TableServiceJob          = "service_job"
+
+	// the audit table only returns the latest entries; the older entries of a job are fetched by ID
+	JobAuditGetLimit = 200
 
 	CommandUserSubmitJob raft.Command = "deployd.user.submit-job"
 	CommandUserCancelJob raft.Command = "deployd.user.cancel-job"
 
+	// Re-deploy the last known-good release of a service
+	CommandUserRollbackJob raft.Command = "deployd.user.rollback-job"
+
 	// Host update
 	CommandHostConfigurationUpdate raft.Command = "deployd.host.configuration-update"
 
+	// Canary host found the service is not healthy during bake
+	CommandHostCanaryUpdate raft.Command = "deployd.host.canary-update"
+
 	// After configured, we wait before immediately continuing
 	CommandRestartConfirmation raft.Command = "deployd.restart-confirmation"
 
 	// Update de
 	CommandHostRestartServiceUpdate raft.Command = "deployd.host.restart-service-update"
+
+	// Proposed by the leader when the current phase deadline of a job has passed
+	CommandLeaderJobTimeout raft.Command = "deployd.leader.job-timeout"
+
+	// Re-run the failed phase of a single host
+	CommandUserRetryHost raft.Command = "deployd.user.retry-host"
+
+	// Remove the service instances and release its ports
+	CommandUserRemoveService raft.Command = "deployd.user.remove-service"
+
+	// Removed host (CHANGE_HOSTS job) uninstall update
+	CommandHostDecommissionUpdate raft.Command = "deployd.host.decommission-update"
+
+	// Host acknowledge a cancelled job
+	CommandHostCancelUpdate raft.Command = "deployd.host.cancel-update"
+
+	// Block / unblock the deployment in a namespace
+	CommandUserFreeze   raft.Command = "deployd.user.freeze"
+	CommandUserUnfreeze raft.Command = "deployd.user.unfreeze"
+
+	// Host finished pruning old releases after deployed
+	CommandHostCleanupUpdate raft.Command = "deployd.host.cleanup-update"
+
+	// Host found (or repaired) the difference between the latest deployment and the host
+	CommandHostDriftUpdate raft.Command = "deployd.host.drift-update"
+)
+
+// used when the request does not specify TimeoutSeconds
+const defaultPhaseTimeoutSeconds = 15 * 60
+
+// maximum retry per host per phase
+const maxHostRetry = 5
+
+// used when the port range is not configured
+const (
+	defaultPortRangeStart = 14000
+	defaultPortRangeEnd   = 19999
 )
 
 var _ raft.Application = &raftApp{}
@@ -43,20 +93,59 @@ var _ raft.Application = &raftApp{}
 // If you have multiple, you can use actual composition instead, and then make sure the Raft App lifecycle
 // is executed for each instance
 type raftApp struct {
-	*content_chraft.ContentApp
+	Store
 
 	topic notifier.Topic
 
+	config Config
+
 	jobUsecase  *mycontent_base.Handler[*entity.DeploymentJob]
 	serviceHost *mycontent_base.Handler[*entity.ServiceInstanceHost]
+	hostPort    *mycontent_base.Handler[*entity.HostPort]
+	jobAudit    *mycontent_base.Handler[*entity.JobAudit]
+	freeze      *mycontent_base.Handler[*entity.Freeze]
+	serviceJobs *mycontent_base.Handler[*entity.ServiceJob]
 }
 
-func New(topic notifier.Topic) *raftApp {
-	stateStore := content_chraft.New(
-		topic,
-		content_chraft.TableConfig{Name: TableDeploymentJob, RefSize: 1, IncrementalID: true, IncrementalIDGetLimit: 10},
-		content_chraft.TableConfig{Name: TableServiceInstanceHost, RefSize: 1},
-	)
+// Config of the deploy-job raft app; must be the same for all replicas
+type Config struct {
+	// range of port allocated for raft-backed service
+	PortRangeStart uint16
+	PortRangeEnd   uint16
+
+	// distinct approvers needed before restart, per namespace (eg. production);
+	// the service definition may ask for more
+	RequiredApprovals map[string]uint
+}
+
+// Store is the base raft application that keep the tables of the deploy-job raft app.
+// content_chraft.ContentApp in production; an in-memory store for the harness.
+type Store interface {
+	raft.Application
+	GetStorage(tableName string) (content.Repository, error)
+}
+
+// TableConfigs of the deploy-job raft app
+func TableConfigs() []content_chraft.TableConfig {
+	return []content_chraft.TableConfig{
+		{Name: TableDeploymentJob, RefSize: 1, IncrementalID: true, IncrementalIDGetLimit: 10},
+		{Name: TableServiceInstanceHost, RefSize: 1},
+		{Name: TableHostPort, RefSize: 1},
+		{Name: TableJobAudit, RefSize: 2, IncrementalID: true, IncrementalIDGetLimit: JobAuditGetLimit},
+		{Name: TableFreeze, RefSize: 0},
+		{Name: TableServiceJob, RefSize: 0},
+	}
+}
+
+func New(topic notifier.Topic, config Config) *raftApp {
+	return NewWithStore(topic, config, content_chraft.New(topic, TableConfigs()...))
+}
+
+// NewWithStore create the raft app on top of the given store
+func NewWithStore(topic notifier.Topic, config Config, stateStore Store) *raftApp {
+	if config.PortRangeStart == 0 || config.PortRangeEnd < config.PortRangeStart {
+		config.PortRangeStart, config.PortRangeEnd = defaultPortRangeStart, defaultPortRangeEnd
+	}
 
 	jobStorage, err := stateStore.GetStorage(TableDeploymentJob)
 	if err != nil {
@@ -68,111 +157,219 @@ func New(topic notifier.Topic) *raftApp {
 		log.Fatal().Msgf("err: %v", err)
 	}
 
+	hostPortStorage, err := stateStore.GetStorage(TableHostPort)
+	if err != nil {
+		log.Fatal().Msgf("err: %v", err)
+	}
+
+	jobAuditStorage, err := stateStore.GetStorage(TableJobAudit)
+	if err != nil {
+		log.Fatal().Msgf("err: %v", err)
+	}
+
+	freezeStorage, err := stateStore.GetStorage(TableFreeze)
+	if err != nil {
+		log.Fatal().Msgf("err: %v", err)
+	}
+
+	serviceJobStorage, err := stateStore.GetStorage(TableServiceJob)
+	if err != nil {
+		log.Fatal().Msgf("err: %v", err)
+	}
+
 	// data accessor inside raft
 	jobUsecase := mycontent_base.New[*entity.DeploymentJob](jobStorage, 1)
 	serviceHost := mycontent_base.New[*entity.ServiceInstanceHost](serviceInstanceStorage, 1)
+	hostPort := mycontent_base.New[*entity.HostPort](hostPortStorage, 1)
+	jobAudit := mycontent_base.New[*entity.JobAudit](jobAuditStorage, 2)
+	freeze := mycontent_base.New[*entity.Freeze](freezeStorage, 0)
+	serviceJobs := mycontent_base.New[*entity.ServiceJob](serviceJobStorage, 0)
 
 	return &raftApp{
 		topic:       topic,
-		ContentApp:  stateStore,
+		config:      config,
+		Store:       stateStore,
 		jobUsecase:  jobUsecase,
 		serviceHost: serviceHost,
+		hostPort:    hostPort,
+		jobAudit:    jobAudit,
+		freeze:      freeze,
+		serviceJobs: serviceJobs,
 	}
 }
 
-// make it easier for everyone..
+// OnUpdate carry the typed error code in the raft result
 func (m *raftApp) OnUpdate(ctx context.Context, e raft.Entry) (raft.OnAfterApply, error) {
+	afterApply, err := m.onUpdate(withAuditEntry(ctx, e), e)
+	if err != nil {
+		var appErr *Error
+		if errors.As(err, &appErr) {
+			return errorResult(err), nil
+		}
+		return nil, err
+	}
+	return afterApply, nil
+}
+
+// make it easier for everyone..
+func (m *raftApp) onUpdate(ctx context.Context, e raft.Entry) (raft.OnAfterApply, error) {
 	switch e.Command {
 	case CommandUserSubmitJob:
 		// start create job
 		payload, err := parseAs[entity.SubmitDeploymentJobRequest](e.Value)
 		if err != nil {
-			return nil, fmt.Errorf("%w: failed to parse command as JSON (%v)", err, string(e.Value))
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
 		}
 		return m.userSubmitJob(ctx, payload)
 	case CommandUserCancelJob:
 		// explicitly cancelling job, we cancel
 		payload, err := parseAs[CancelJobRequest](e.Value)
 		if err != nil {
-			return nil, fmt.Errorf("%w: failed to parse command as JSON (%v)", err, string(e.Value))
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
 		}
 		return m.cancelJob(ctx, payload)
+	case CommandUserRollbackJob:
+		// create a new job using the last known-good versions
+		payload, err := parseAs[RollbackJobRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.rollbackJob(ctx, payload)
 	case CommandHostConfigurationUpdate:
 		// feed installation (sub)state update to raft
 		payload, err := parseAs[ConfigurationUpdateRequest](e.Value)
 		if err != nil {
-			return nil, fmt.Errorf("%w: failed to parse command as JSON (%v)", err, string(e.Value))
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
 		}
 		return m.hostConfigurationUpdate(ctx, payload)
+	case CommandHostCanaryUpdate:
+		// feed canary health to raft
+		payload, err := parseAs[CanaryUpdateRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.hostCanaryUpdate(ctx, payload)
 	case CommandRestartConfirmation:
 		// if restart is confirmed, we do restart
 		payload, err := parseAs[RestartConfirmation](e.Value)
 		if err != nil {
-			return nil, fmt.Errorf("%w: failed to parse command as JSON (%v)", err, string(e.Value))
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
 		}
 		return m.restart(ctx, payload)
 	case CommandHostRestartServiceUpdate:
 		// feed deployment update (sub)state update to raft
 		payload, err := parseAs[HostRestartServiceUpdateRequest](e.Value)
 		if err != nil {
-			return nil, fmt.Errorf("%w: failed to parse command as JSON (%v)", err, string(e.Value))
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
 		}
 		return m.hostRestartServiceUpdate(ctx, payload)
+	case CommandLeaderJobTimeout:
+		// the leader found the job has passed its deadline
+		payload, err := parseAs[JobTimeoutRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.timeoutJob(ctx, payload)
+	case CommandUserRetryHost:
+		// retry a failed host
+		payload, err := parseAs[RetryHostRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.retryHost(ctx, payload)
+	case CommandUserRemoveService:
+		// release everything owned by the service
+		payload, err := parseAs[RemoveServiceRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.removeService(ctx, payload)
+	case CommandHostDecommissionUpdate:
+		// feed removed host uninstall state to raft
+		payload, err := parseAs[DecommissionUpdateRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.hostDecommissionUpdate(ctx, payload)
+	case CommandHostCancelUpdate:
+		// feed host cancel acknowledgement to raft
+		payload, err := parseAs[HostCancelUpdateRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.hostCancelUpdate(ctx, payload)
+	case CommandUserFreeze:
+		// block the deployment in the namespace
+		payload, err := parseAs[FreezeRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.freezeNamespace(ctx, payload)
+	case CommandUserUnfreeze:
+		// lift the namespace freeze
+		payload, err := parseAs[UnfreezeRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.unfreezeNamespace(ctx, payload)
+	case CommandHostCleanupUpdate:
+		// feed host cleanup state to raft
+		payload, err := parseAs[CleanupUpdateRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.hostCleanupUpdate(ctx, payload)
+	case CommandHostDriftUpdate:
+		// feed host drift to raft
+		payload, err := parseAs[DriftUpdateRequest](e.Value)
+		if err != nil {
+			return nil, errValidation("%v: failed to parse command as JSON (%v)", err, string(e.Value))
+		}
+		return m.hostDriftUpdate(ctx, payload)
 	}
 
 	// fallback to the base
-	return m.ContentApp.OnUpdate(ctx, e)
+	return m.Store.OnUpdate(ctx, e)
 }
 
 // Because we're using Golang composition / aka inheritance, we do not need to implement the rest of raft.Application method.
 // Later if we have multiple ContentApp, then you need to implement it to make sure all method are executed.
 
 func (m *raftApp) userSubmitJob(ctx context.Context, request entity.SubmitDeploymentJobRequest) (raft.OnAfterApply, error) {
-	// needto validate duplication outside raft (in http integration layer)
-
-	// check if there is an existing deployment
-	instances, err := m.serviceHost.Get(ctx, request.Service.Ns, []string{request.Service.Id}, "")
+	busy, freeze, err := m.admitJob(ctx, request)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(instances) == 0 {
-		if len(request.TargetHosts) == 0 {
-			return nil, errors.New("for new deployment, please specify target host")
-		}
+	instances, removedInstances, needRaftPort, err := m.targetInstances(ctx, &request, busy)
+	if err != nil {
+		return nil, err
+	}
 
-		// Create New instances
+	// the bound ports first, so a conflict does not leave the raft port allocated
+	err = m.checkServicePorts(ctx, request.Service, instances)
+	if err != nil {
+		return nil, err
+	}
 
-		// TODO: compare used port
-		// TODO: move this to their own function
-		// TODO: validate maxxing
-		// TODO: accept job to modify this; (but can be very later); eg. in case of server change disk address
-		randomPort := uint16(rand.UintN(16))%50000 + 14000
-		uniqueReplicaID := make(map[uint64]struct{})
-		for _, target := range request.TargetHosts {
-			nodeHostDir := path.Join(path.Clean(target.RaftConfig.NodeHostDir), fmt.Sprintf("%v_%v", request.Service.Ns, request.Service.Id))
-			walDir := path.Join(path.Clean(target.RaftConfig.WALDir), fmt.Sprintf("%v_%v", request.Service.Ns, request.Service.Id))
-
-			if _, ok := uniqueReplicaID[target.RaftConfig.ReplicaID]; ok {
-				return nil, fmt.Errorf("duplicate replica ID found: %v", target.RaftConfig.ReplicaID)
-			}
-			uniqueReplicaID[target.RaftConfig.ReplicaID] = struct{}{}
+	// validation done; the same raft port for all hosts
+	if needRaftPort {
+		raftPort, err := m.allocatePort(ctx, request.Service, instanceHosts(instances), entity.HostPortPurposeRaft)
+		if err != nil {
+			return nil, err
+		}
 
-			instances = append(instances, &entity.ServiceInstanceHost{
-				Ns:      request.Service.Ns,
-				Service: request.Service.Id,
-				Host:    target.Host,
-				RaftConfig: &entity.RaftConfig{
-					RaftPort:       randomPort,
-					ReplicaID:      target.RaftConfig.ReplicaID,
-					RaftWALDir:     walDir,
-					NodeHostDir:    nodeHostDir, // make the same first
-					RTTMillisecond: 100,         // default
-				},
-			})
+		for _, instance := range instances {
+			instance.RaftConfig.RaftPort = raftPort
 		}
 	}
 
+	// register the ports used by the service; fail if it conflicts with other service on the host
+	err = m.reserveServicePorts(ctx, request.Service, instances)
+	if err != nil {
+		return nil, err
+	}
+
 	hostOrdering := make([]string, len(instances))
 	hostDeploymentStatus := make(map[string]entity.HostDeploymentStatusInfo, len(instances))
 	hostConfigurationStatus := make(map[string]entity.HostConfigurationStatusInfo, len(instances))
@@ -186,10 +383,17 @@ func (m *raftApp) userSubmitJob(ctx context.Context, request entity.SubmitDeploy
 		}
 	}
 
+	hostDecommissionStatus := make(map[string]entity.HostDecommissionStatusInfo, len(removedInstances))
+	for _, instance := range removedInstances {
+		hostDecommissionStatus[instance.Host] = entity.HostDecommissionStatusInfo{
+			Status: entity.HostDecommissionStatusPending,
+		}
+	}
+
 	// we create / initialize the job
 	job := &entity.DeploymentJob{
 		Ns:          request.Ns,
-		Status:      entity.DeploymentJobStatusQueued,
+		Status:      entity.DeploymentJobStatusConfiguring,
 		Request:     request,
 		PublishedAt: request.PublishedAt,
 		Deployment: entity.Deployment{
@@ -200,18 +404,58 @@ func (m *raftApp) userSubmitJob(ctx context.Context, request entity.SubmitDeploy
 		Configuration: entity.Configuration{
 			Status: hostConfigurationStatus,
 		},
+		Decommission: entity.Decommission{
+			Status: hostDecommissionStatus,
+		},
+		Approval: entity.Approval{
+			Required: max(request.Service.RequiredApprovals, m.config.RequiredApprovals[request.Ns]),
+		},
+		Deadline: phaseDeadline(request, request.PublishedAt),
+	}
+
+	if len(hostOrdering) == 0 {
+		// hosts removal only
+		startDecommission(job, request.PublishedAt)
+	}
+
+	submitStatus := SubmitJobStatusSuccess
+	if busy {
+		// wait until the active job finished; see startNextJob
+		job.Status = entity.DeploymentJobStatusQueued
+		job.Deadline = nil
+		submitStatus = SubmitJobStatusQueued
 	}
 
 	// TODO: utilize metamaxxing
-	jobMeta := map[string]any{"author": "kmg"}
+	jobMeta := map[string]any{"author": request.Agent}
+
+	result, err := m.postJob(ctx, job, jobMeta)
+	if err != nil {
+		return nil, err
+	}
 
-	result, err := m.jobUsecase.Post(ctx, job, jobMeta)
+	err = m.audit(ctx, result, auditRecord{actor: request.Agent, message: string(submitStatus) + freezeOverridden(freeze), at: request.PublishedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	// first deployment / new hosts; register the instances
+	if needRaftPort || request.Type == entity.DeploymentJobTypeChangeHosts {
+		for _, instance := range instances {
+			_, err = m.serviceHost.Post(ctx, instance, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = m.syncInstances(ctx, result, hostOrdering...)
 	if err != nil {
 		return nil, err
 	}
 
 	resp := SubmitJobResponse{
-		SubmitJobStatus: SubmitJobStatusSuccess,
+		SubmitJobStatus: submitStatus,
 		Job:             *result,
 	}
 
@@ -225,7 +469,9 @@ func (m *raftApp) userSubmitJob(ctx context.Context, request entity.SubmitDeploy
 	}
 
 	return func() (raft.Result, error) {
-		if resp.SubmitJobStatus == SubmitJobStatusSuccess {
+		if resp.SubmitJobStatus == SubmitJobStatusSuccess && resp.Job.Status == entity.DeploymentJobStatusDecommissioning {
+			m.topic.Broadcast(context.Background(), EventDecommissionStarted{Job: resp.Job})
+		} else if resp.SubmitJobStatus == SubmitJobStatusSuccess {
 			m.topic.Broadcast(context.Background(), EventDeploymentJobCreated(resp))
 		}
 
@@ -233,28 +479,172 @@ func (m *raftApp) userSubmitJob(ctx context.Context, request entity.SubmitDeploy
 	}, nil
 }
 
+// admitJob checks whether the job can be submitted now.
+// Returns whether the job has to be queued, and the freeze the job override (if any).
+func (m *raftApp) admitJob(ctx context.Context, request entity.SubmitDeploymentJobRequest) (busy bool, freeze *entity.Freeze, err error) {
+	if err := request.Schedule.Validate(); err != nil {
+		return false, nil, errValidation("invalid schedule: %v", err)
+	}
+
+	freeze, err = m.activeFreeze(ctx, request.Ns, request.PublishedAt)
+	if err != nil {
+		return false, nil, err
+	}
+	if freeze != nil && !request.OverrideFreeze {
+		return false, nil, frozenError(freeze)
+	}
+
+	// one active job per service; the rest are queued (if requested)
+	serviceJob, _, err := m.serviceJob(ctx, request.Ns, request.Service.Id)
+	if err != nil {
+		return false, nil, err
+	}
+
+	queuedJobs := len(serviceJob.QueuedJobIds)
+	busy = serviceJob.ActiveJobId != "" || queuedJobs > 0
+	if busy && !request.QueueIfBusy {
+		return false, nil, errConflict("service already has active job (active: %v, queued: %v)", activeJobId(serviceJob), queuedJobs)
+	}
+	if busy && queuedJobs >= maxQueuedJob {
+		return false, nil, errConflict("too many queued job for the service: %v", queuedJobs)
+	}
+
+	return busy, freeze, nil
+}
+
+// targetInstances returns the instances the job is deployed to, and the instances to be removed (CHANGE_HOSTS job).
+// For CHANGE_HOSTS job without build version, the request is filled with the currently running version.
+// needRaftPort is true if the instances are new and need a raft port.
+func (m *raftApp) targetInstances(ctx context.Context, request *entity.SubmitDeploymentJobRequest, busy bool) (instances, removed []*entity.ServiceInstanceHost, needRaftPort bool, err error) {
+	// check if there is an existing deployment
+	instances, err = m.serviceHost.Get(ctx, request.Service.Ns, []string{request.Service.Id}, "")
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	switch {
+	case request.Type == entity.DeploymentJobTypeChangeHosts:
+		// the diff is only valid for the current state
+		if busy {
+			return nil, nil, false, errConflict("cannot change hosts while the service has active job")
+		}
+
+		// only the new hosts are configured & restarted
+		instances, removed, err = changeHostInstances(*request, instances)
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		goodJob, err := m.latestGoodJob(ctx, request.Ns, request.Service.Id)
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		// deploy the currently running version to the new hosts, if not specified
+		if goodJob != nil && request.BuildVersion == 0 {
+			request.BuildVersion = goodJob.Request.BuildVersion
+			request.SecretVersion = goodJob.Request.SecretVersion
+			request.EnvVersion = goodJob.Request.EnvVersion
+			request.RaftConfigVersion = goodJob.Request.RaftConfigVersion
+			request.RaftConfigReplicaVersion = goodJob.Request.RaftConfigReplicaVersion
+		}
+
+		// only if all hosts are replaced
+		return instances, removed, len(instances) > 0 && instances[0].RaftConfig.RaftPort == 0, nil
+	case len(instances) == 0:
+		if len(request.TargetHosts) == 0 {
+			return nil, nil, false, errValidation("for new deployment, please specify target host")
+		}
+
+		// Create New instances
+		instances, err = newServiceInstances(request.Service, request.TargetHosts, nil)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return instances, nil, true, nil
+	}
+
+	return instances, nil, false, nil
+}
+
+func instanceHosts(instances []*entity.ServiceInstanceHost) []string {
+	hosts := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		hosts = append(hosts, instance.Host)
+	}
+	return hosts
+}
+
 func (m *raftApp) cancelJob(ctx context.Context, request CancelJobRequest) (raft.OnAfterApply, error) {
 	previousJobs, err := m.jobUsecase.Get(ctx, request.Ns, []string{request.Service}, request.JobId)
 	if err != nil {
 		return nil, err
 	}
+	if len(previousJobs) != 1 {
+		return nil, errNotFound("job nengendi???? not found")
+	}
 
 	previousJob := previousJobs[0]
 
-	switch previousJob.Status {
-	case entity.DeploymentJobStatusCancelled:
+	switch {
+	case previousJob.Status == entity.DeploymentJobStatusCancelled:
 		encResult, err := json.Marshal(previousJob)
 		if err != nil {
 			return nil, err
 		}
 		return func() (raft.Result, error) { return raft.Result{Value: 0, Data: encResult}, nil }, nil
-	case entity.DeploymentJobStatusSuccess:
-		return nil, fmt.Errorf("job already finished")
+	case previousJob.Status.IsTerminal():
+		return nil, errInvalidState("job already finished with status %v", previousJob.Status)
+	case previousJob.Status == entity.DeploymentJobStatusDeployed:
+		return nil, errInvalidState("job already deployed; cleaning up old releases")
+	}
+
+	cancellation := &entity.Cancellation{
+		Reason:      request.Reason,
+		CancelledBy: request.Agent,
+		CancelledAt: request.CreatedAt,
+		Rollback:    request.Rollback,
+	}
+
+	if request.Rollback {
+		rollbackTo, err := m.latestGoodJob(ctx, request.Ns, request.Service)
+		if err != nil {
+			return nil, err
+		}
+
+		if rollbackTo == nil && anyHostSwitched(previousJob) {
+			return nil, errInvalidState("no previous deployed job to roll back to")
+		}
+		if rollbackTo != nil {
+			cancellation.RollbackJobId = rollbackTo.Id
+			cancellation.RollbackBuildVersion = rollbackTo.Request.BuildVersion
+			cancellation.RollbackEnvVersion = rollbackTo.Request.EnvVersion
+		}
 	}
 
+	wasQueued := previousJob.Status == entity.DeploymentJobStatusQueued
+	previousStatus := previousJob.Status
+
 	previousJob.Status = entity.DeploymentJobStatusCancelled
+	previousJob.Deadline = nil
+	previousJob.Cancellation = cancellation
+
+	updatedJob, err := m.postJob(ctx, previousJob, nil) // TODO: utilize meta. Meta have full utility here
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, updatedJob, auditRecord{previous: previousStatus, actor: request.Agent, message: request.Reason, at: request.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
 
-	updatedJob, err := m.jobUsecase.Post(ctx, previousJob, nil) // TODO: utilize meta. Meta have full utility here
+	err = m.syncInstances(ctx, updatedJob, updatedJob.Deployment.HostOrder...)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := m.startNextJob(ctx, updatedJob.Ns, request.Service, request.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -264,7 +654,14 @@ func (m *raftApp) cancelJob(ctx context.Context, request CancelJobRequest) (raft
 		return nil, err
 	}
 
-	return func() (raft.Result, error) { return raft.Result{Data: encResult}, nil }, nil
+	return func() (raft.Result, error) {
+		if !wasQueued {
+			// queued job does not reach any host yet
+			m.topic.Broadcast(context.Background(), EventDeploymentJobCancelled{Job: *updatedJob})
+		}
+		m.broadcastNextJob(next)
+		return raft.Result{Data: encResult}, nil
+	}, nil
 }
 
 // Host configuration update
@@ -274,22 +671,40 @@ func (m *raftApp) hostConfigurationUpdate(ctx context.Context, request Configura
 		return nil, err
 	}
 	if len(jobs) != 1 {
-		return nil, errors.New("job nengendi???? not found")
+		return nil, errNotFound("job nengendi???? not found")
 	}
 
 	job := jobs[0]
+	previousStatus := job.Status
 
 	if job.Configuration.Status == nil {
-		return nil, errors.New("invalid job")
+		return nil, errInvalidState("invalid job")
+	}
+
+	if job.Status.IsTerminal() || job.Status == entity.DeploymentJobStatusDeployed {
+		return nil, errInvalidState("job is already finished with status %v", job.Status)
 	}
 
-	if _, ok := job.Configuration.Status[request.HostName]; !ok {
-		return nil, fmt.Errorf("invalid host '%v'. available hosts are: %v", request.HostName, job.Configuration.Status)
+	if job.Status == entity.DeploymentJobStatusQueued {
+		return nil, errInvalidState("job is still queued")
+	}
+
+	current, ok := job.Configuration.Status[request.HostName]
+	if !ok {
+		return nil, errValidation("invalid host '%v'. available hosts are: %v", request.HostName, job.Configuration.Status)
+	}
+
+	if request.Progress != nil && request.Status == current.Status {
+		current.Progress = request.Progress
+		job.Configuration.Status[request.HostName] = current
+		return m.saveProgress(ctx, job, ConfigurationUpdateResponse{Job: job, TriggerHost: request.HostName})
 	}
 
 	job.Configuration.Status[request.HostName] = entity.HostConfigurationStatusInfo{
 		Status:       request.Status,
 		ErrorMessage: request.ErrorMessage,
+		RetryCount:   current.RetryCount,
+		Progress:     latestProgress(request.Progress, current.Progress),
 	}
 	// TODO: dontuse serviceHost, just use the jobUsecase
 
@@ -302,9 +717,22 @@ func (m *raftApp) hostConfigurationUpdate(ctx context.Context, request Configura
 	if allHostConfigured {
 		// Update the job status itself
 		job.Status = entity.DeploymentJobStatusConfigured
+
+		// waiting for the user to confirm; no deadline
+		job.Deadline = nil
+	}
+
+	job, err = m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	job, err = m.jobUsecase.Post(ctx, job, nil)
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: statusMessage(string(request.Status), request.ErrorMessage), at: request.UpdatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.syncInstances(ctx, job, request.HostName)
 	if err != nil {
 		return nil, err
 	}
@@ -334,14 +762,87 @@ func (m *raftApp) restart(ctx context.Context, request RestartConfirmation) (raf
 		return nil, err
 	}
 	if len(jobs) != 1 {
-		return nil, errors.New("job nengendi???? not found")
+		return nil, errNotFound("job nengendi???? not found")
 	}
 
 	job := jobs[0]
+	previousStatus := job.Status
+
+	// only restart if job status is already CONFIGURED, DEPLOYING or CANARY (promotion)
+	if job.Status != entity.DeploymentJobStatusConfigured && job.Status != entity.DeploymentJobStatusDeploying &&
+		job.Status != entity.DeploymentJobStatusCanary {
+		return nil, errInvalidState("cannot confirm deployment. current job state is not CONFIGURED / DEPLOYING / CANARY, actual: %v", job.Status)
+	}
+
+	if job.Status == entity.DeploymentJobStatusCanary && job.Deployment.BakeUntil != nil && request.CreatedAt.Before(*job.Deployment.BakeUntil) {
+		return nil, errInvalidState("canary is still baking until %v", job.Deployment.BakeUntil)
+	}
+
+	// do not restart the batch twice
+	for _, host := range job.Deployment.CurrentBatch() {
+		if job.Deployment.Status[host].Status != entity.HostDeploymentStatusPending {
+			return nil, errInvalidState("current batch is still in progress; host %v status: %v", host, job.Deployment.Status[host].Status)
+		}
+	}
+
+	// each confirmation of a CONFIGURED job is an approval; wait until there are enough approvers
+	if job.Status == entity.DeploymentJobStatusConfigured && !job.Approval.IsApproved() {
+		if request.Agent == "" {
+			return nil, errValidation("approver (agent) is required")
+		}
+		if job.Approval.HasApproved(request.Agent) {
+			return nil, errConflict("already approved by %v", request.Agent)
+		}
+		// the agent is only a trusted header, but at least the submitter can't approve their own job
+		if job.Request.Agent != "" && request.Agent == job.Request.Agent {
+			return nil, errValidation("%v submitted the job and can't approve it", request.Agent)
+		}
+
+		job.Approval.Approvals = append(job.Approval.Approvals, entity.Approver{
+			Agent:      request.Agent,
+			Message:    request.Message,
+			ApprovedAt: request.CreatedAt,
+		})
+
+		if !job.Approval.IsApproved() {
+			return m.waitApproval(ctx, job, request)
+		}
+	}
 
-	// only restart if job status is already CONFIGURED or DEPLOYING
-	if job.Status != entity.DeploymentJobStatusConfigured && job.Status != entity.DeploymentJobStatusDeploying {
-		return nil, fmt.Errorf("cannot confirm deployment. current job state is not CONFIGURED / DEPLOYING, actual: %v", job.Status)
+	freeze, err := m.activeFreeze(ctx, job.Ns, request.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if freeze != nil && !job.Request.OverrideFreeze {
+		if freeze.Until == nil {
+			return nil, frozenError(freeze)
+		}
+
+		// the leader confirm it again once the freeze is over
+		openAt, _ := job.Request.Schedule.NextOpen(*freeze.Until)
+		return m.holdRestart(ctx, job, request, openAt, fmt.Sprintf("namespace is frozen (%v)", freeze.Reason))
+	}
+
+	// outside of the deploy window; the leader confirm it again once it's open
+	if !job.Request.Schedule.IsOpen(request.CreatedAt) {
+		openAt, ok := job.Request.Schedule.NextOpen(request.CreatedAt)
+		if !ok {
+			return nil, errInvalidState("deploy window never opens")
+		}
+		return m.holdRestart(ctx, job, request, openAt, "outside of the deploy window")
+	}
+
+	// the one who confirm is the one who made the held confirmation
+	confirmedBy := request.Agent
+	if job.PendingSchedule != nil {
+		confirmedBy = job.PendingSchedule.ConfirmedBy
+		job.PendingSchedule = nil
+	}
+
+	// Promote canary to the rest of the hosts
+	if job.Status == entity.DeploymentJobStatusCanary {
+		job.Status = entity.DeploymentJobStatusDeploying
+		job.Deployment.PromotedBy = confirmedBy
 	}
 
 	// Initialize "deploying" stage
@@ -350,10 +851,25 @@ func (m *raftApp) restart(ctx context.Context, request RestartConfirmation) (raf
 
 		var currentOder uint
 		job.Deployment.CurrentOrder = &currentOder
-		job.Deployment.ConfirmedBy = request.Agent
+		job.Deployment.BatchSize = job.Request.Strategy.GetBatchSize(len(job.Deployment.HostOrder))
+		job.Deployment.CanaryHosts = job.Request.Strategy.GetCanaryHosts(len(job.Deployment.HostOrder))
+		job.Deployment.ConfirmedBy = confirmedBy
+	}
+
+	// each confirmation start the restart phase of the next host
+	job.Deadline = phaseDeadline(job.Request, request.CreatedAt)
+
+	job, err = m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: request.Agent, message: request.Message + freezeOverridden(freeze), at: request.CreatedAt})
+	if err != nil {
+		return nil, err
 	}
 
-	job, err = m.jobUsecase.Post(ctx, job, nil)
+	err = m.syncInstances(ctx, job, job.Deployment.CurrentBatch()...)
 	if err != nil {
 		return nil, err
 	}
@@ -367,6 +883,7 @@ func (m *raftApp) restart(ctx context.Context, request RestartConfirmation) (raf
 	if step < len(job.Deployment.HostOrder) {
 		resp.TargetHost = job.Deployment.HostOrder[step] // which host that the service will restart
 	}
+	resp.TargetHosts = job.Deployment.CurrentBatch() // all hosts restarted in this step
 
 	encResult, err := json.Marshal(resp)
 	if err != nil {
@@ -387,30 +904,83 @@ func (m *raftApp) hostRestartServiceUpdate(ctx context.Context, request HostRest
 		return nil, err
 	}
 	if len(jobs) != 1 {
-		return nil, errors.New("job nengendi???? not found")
+		return nil, errNotFound("job nengendi???? not found")
 	}
 
 	job := jobs[0]
+	previousStatus := job.Status
 
-	if job.Status != entity.DeploymentJobStatusDeploying {
-		return nil, errors.New("invalid state")
+	// other host in the same batch may still report after the job failed; record it, so it can be retried
+	lateReport := job.Status == entity.DeploymentJobStatusFailed && job.Deployment.InCurrentBatch(request.HostName)
+
+	if job.Status != entity.DeploymentJobStatusDeploying && !lateReport {
+		return nil, errInvalidState("invalid state")
 	}
 
-	hostOnProgress := job.Deployment.HostOrder[*job.Deployment.CurrentOrder]
-	if hostOnProgress != request.HostName {
+	if !job.Deployment.InCurrentBatch(request.HostName) {
 		// or other meaningful error based on deployed host...
-		return nil, fmt.Errorf("host %v is not yet on deployment. Please wait for %v", request.HostName, hostOnProgress)
+		return nil, errInvalidState("host %v is not yet on deployment. Please wait for %v", request.HostName, job.Deployment.CurrentBatch())
+	}
+
+	current := job.Deployment.Status[request.HostName]
+
+	if request.Progress != nil && request.Status == current.Status {
+		current.Progress = request.Progress
+		job.Deployment.Status[request.HostName] = current
+		return m.saveProgress(ctx, job, HostRestartServiceUpdateResponse{Job: *job, TriggerHost: request.HostName})
 	}
 
 	job.Deployment.Status[request.HostName] = entity.HostDeploymentStatusInfo{
 		Status:       request.Status,
 		ErrorMessage: request.ErrorMessage,
+		RetryCount:   current.RetryCount,
+		Progress:     latestProgress(request.Progress, current.Progress),
+	}
+
+	if lateReport {
+		job, err = m.postJob(ctx, job, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: statusMessage(string(request.Status), request.ErrorMessage), at: request.UpdatedAt})
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.syncInstances(ctx, job, request.HostName)
+		if err != nil {
+			return nil, err
+		}
+
+		encResult, err := json.Marshal(HostRestartServiceUpdateResponse{Job: *job, TriggerHost: request.HostName})
+		if err != nil {
+			return nil, err
+		}
+
+		return func() (raft.Result, error) { return raft.Result{Data: encResult}, nil }, nil
 	}
 
 	// If one fail, then we fail the whole job
 	if request.Status == entity.HostDeploymentStatusFailed {
 		job.Status = entity.DeploymentJobStatusFailed
-		job, err = m.jobUsecase.Post(ctx, job, nil)
+		job.Deadline = nil
+		job, err = m.postJob(ctx, job, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: statusMessage(string(request.Status), request.ErrorMessage), at: request.UpdatedAt})
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.syncInstances(ctx, job, request.HostName)
+		if err != nil {
+			return nil, err
+		}
+
+		next, err := m.startNextJob(ctx, job.Ns, request.Service, request.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -428,14 +998,31 @@ func (m *raftApp) hostRestartServiceUpdate(ctx context.Context, request HostRest
 
 		return func() (raft.Result, error) {
 			m.topic.Broadcast(context.Background(), EventDeploymentFailed(resp))
+			m.broadcastNextJob(next)
 			return raft.Result{Data: encResult, Value: 0}, nil
 		}, nil
 	}
 
+	batchFinished := true
+	for _, host := range job.Deployment.CurrentBatch() {
+		batchFinished = batchFinished && job.Deployment.Status[host].Status == entity.HostDeploymentStatusSuccess
+	}
+
 	// If it's other status than success, we just update; a FYI
 	// General update to the state..
-	if request.Status != entity.HostDeploymentStatusSuccess {
-		job, err = m.jobUsecase.Post(ctx, job, nil)
+	// Same if the other host in the batch still restarting
+	if request.Status != entity.HostDeploymentStatusSuccess || !batchFinished {
+		job, err = m.postJob(ctx, job, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: statusMessage(string(request.Status), request.ErrorMessage), at: request.UpdatedAt})
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.syncInstances(ctx, job, request.HostName)
 		if err != nil {
 			return nil, err
 		}
@@ -457,15 +1044,47 @@ func (m *raftApp) hostRestartServiceUpdate(ctx context.Context, request HostRest
 		}, nil
 	}
 
-	// NOW, the real deal; if it's success.
+	// NOW, the real deal; if the whole batch success.
 
-	*job.Deployment.CurrentOrder++
+	*job.Deployment.CurrentOrder += uint(len(job.Deployment.CurrentBatch()))
+
+	// waiting for the next confirmation, or finished
+	job.Deadline = nil
+
+	// Canary batch finished; bake it first before continue to the rest
+	if job.Deployment.CanaryHosts > 0 && *job.Deployment.CurrentOrder == job.Deployment.CanaryHosts {
+		return m.startCanary(ctx, job, request)
+	}
 
 	// It means, all restart are successful.
-	if int(*job.Deployment.CurrentOrder) >= len(job.Deployment.Status) {
-		job.Status = entity.DeploymentJobStatusDeployed
+	if int(*job.Deployment.CurrentOrder) >= len(job.Deployment.HostOrder) {
+		if len(job.Decommission.Status) > 0 {
+			// new hosts are ready; now remove the old one
+			startDecommission(job, request.UpdatedAt)
+		} else {
+			keep, err := m.rollbackRelease(ctx, job)
+			if err != nil {
+				return nil, err
+			}
+			startCleanup(job, request.UpdatedAt, keep)
+		}
+
+		job, err = m.postJob(ctx, job, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: statusMessage(string(request.Status), request.ErrorMessage), at: request.UpdatedAt})
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.syncInstances(ctx, job, request.HostName)
+		if err != nil {
+			return nil, err
+		}
 
-		job, err = m.jobUsecase.Post(ctx, job, nil)
+		next, err := m.startNextJob(ctx, job.Ns, request.Service, request.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -484,12 +1103,28 @@ func (m *raftApp) hostRestartServiceUpdate(ctx context.Context, request HostRest
 		return func() (raft.Result, error) {
 			// notify the good news
 			m.topic.Broadcast(context.Background(), EventServiceRestarted(resp))
+			if resp.Job.Status == entity.DeploymentJobStatusDecommissioning {
+				m.topic.Broadcast(context.Background(), EventDecommissionStarted{Job: resp.Job})
+				return raft.Result{Data: encResult, Value: 0}, nil
+			}
 			m.topic.Broadcast(context.Background(), EventAllServiceRestarted(resp))
+			m.broadcastCleanup(resp.Job)
+			m.broadcastNextJob(next)
 			return raft.Result{Data: encResult, Value: 0}, nil
 		}, nil
 	}
 
-	job, err = m.jobUsecase.Post(ctx, job, nil)
+	job, err = m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: statusMessage(string(request.Status), request.ErrorMessage), at: request.UpdatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.syncInstances(ctx, job, request.HostName)
 	if err != nil {
 		return nil, err
 	}
@@ -514,6 +1149,18 @@ func (m *raftApp) hostRestartServiceUpdate(ctx context.Context, request HostRest
 	}, nil
 }
 
+// phaseDeadline calculate the deadline of a phase started at "from".
+// "from" must come from the command to keep the state machine deterministic
+func phaseDeadline(request entity.SubmitDeploymentJobRequest, from time.Time) *time.Time {
+	timeoutSeconds := uint32(defaultPhaseTimeoutSeconds)
+	if request.TimeoutSeconds != nil && *request.TimeoutSeconds > 0 {
+		timeoutSeconds = *request.TimeoutSeconds
+	}
+
+	deadline := from.Add(time.Duration(timeoutSeconds) * time.Second)
+	return &deadline
+}
+
 func parseAs[T any](payload []byte) (T, error) {
 	var t T
 	err := json.Unmarshal(payload, &t)
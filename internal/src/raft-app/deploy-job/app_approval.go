@@ -0,0 +1,37 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// waitApproval records the approval of a CONFIGURED job that still needs more approvers
+func (m *raftApp) waitApproval(ctx context.Context, job *entity.DeploymentJob, request RestartConfirmation) (raft.OnAfterApply, error) {
+	job, err := m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("approved by %v (%v/%v)", request.Agent, len(job.Approval.Approvals), job.Approval.Required)
+	err = m.audit(ctx, job, auditRecord{previous: job.Status, actor: request.Agent, message: message, at: request.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	encResult, err := json.Marshal(HostRestartConfirmationResponse{
+		Job:     *job,
+		Message: message,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
@@ -0,0 +1,64 @@
+package deployjob
+
+import (
+	"context"
+	"time"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+type auditEntryKey struct{}
+
+// withAuditEntry keep the applied entry in the context, so the audit know which command & raft index it belongs to
+func withAuditEntry(ctx context.Context, e raft.Entry) context.Context {
+	return context.WithValue(ctx, auditEntryKey{}, e)
+}
+
+// auditRecord is what the command know about the transition
+type auditRecord struct {
+	previous entity.DeploymentJobStatus
+	actor    string
+	host     string
+	message  string
+	at       time.Time
+}
+
+// audit append the job state transition to the audit table
+func (m *raftApp) audit(ctx context.Context, job *entity.DeploymentJob, record auditRecord) error {
+	return m.auditService(ctx, job.Ns, job.Request.Service.Id, job.Id, job.Status, record)
+}
+
+// auditService is for command that is not about a single job; use entity.JobAuditNoJob as the job id
+func (m *raftApp) auditService(ctx context.Context, ns, service, jobId string, next entity.DeploymentJobStatus, record auditRecord) error {
+	e, _ := ctx.Value(auditEntryKey{}).(raft.Entry)
+
+	_, err := m.jobAudit.Post(ctx, &entity.JobAudit{
+		Ns:             ns,
+		Service:        service,
+		JobId:          jobId,
+		Command:        string(e.Command),
+		Actor:          record.actor,
+		PreviousStatus: record.previous,
+		NextStatus:     next,
+		Host:           record.host,
+		Message:        record.message,
+		RaftIndex:      e.Index,
+		Timestamp:      record.at,
+		PublishedAt:    record.at,
+	}, nil)
+	return err
+}
+
+func hostActor(host string) string {
+	return "host:" + host
+}
+
+// statusMessage is the reported status, with the error message if any
+func statusMessage(status string, errMsg *string) string {
+	if errMsg == nil || *errMsg == "" {
+		return status
+	}
+	return status + ": " + *errMsg
+}
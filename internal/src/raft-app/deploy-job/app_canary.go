@@ -0,0 +1,132 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// startCanary is called once all canary hosts restarted successfully.
+// The job wait in CANARY state until promoted (see restart), or failed by the canary hosts (see hostCanaryUpdate).
+func (m *raftApp) startCanary(ctx context.Context, job *entity.DeploymentJob, request HostRestartServiceUpdateRequest) (raft.OnAfterApply, error) {
+	var bakeSeconds uint32
+	if job.Request.Strategy != nil && job.Request.Strategy.Canary != nil {
+		bakeSeconds = job.Request.Strategy.Canary.BakeSeconds
+	}
+
+	bakeUntil := request.UpdatedAt.Add(time.Duration(bakeSeconds) * time.Second)
+
+	previousStatus := job.Status
+	job.Status = entity.DeploymentJobStatusCanary
+	job.Deployment.BakeUntil = &bakeUntil
+
+	job, err := m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: "canary baking", at: request.UpdatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.syncInstances(ctx, job, job.Deployment.HostOrder[:job.Deployment.CanaryHosts]...)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := EventCanaryStarted{
+		Job:         *job,
+		TriggerHost: request.HostName,
+	}
+
+	encResult, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		// canary hosts start watching
+		m.topic.Broadcast(context.Background(), resp)
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
+
+// hostCanaryUpdate fail the job if the canary host report the service is not healthy during bake.
+func (m *raftApp) hostCanaryUpdate(ctx context.Context, request CanaryUpdateRequest) (raft.OnAfterApply, error) {
+	jobs, err := m.jobUsecase.Get(ctx, request.Ns, []string{request.Service}, request.JobId)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, errNotFound("job nengendi???? not found")
+	}
+
+	job := jobs[0]
+	previousStatus := job.Status
+
+	if job.Status != entity.DeploymentJobStatusCanary {
+		return nil, errInvalidState("job is not in canary; actual: %v", job.Status)
+	}
+
+	if !job.Deployment.IsCanary(request.HostName) {
+		return nil, errValidation("host %v is not a canary host", request.HostName)
+	}
+
+	job.Deployment.Status[request.HostName] = entity.HostDeploymentStatusInfo{
+		Status:       entity.HostDeploymentStatusFailed,
+		ErrorMessage: request.ErrorMessage,
+		RetryCount:   job.Deployment.Status[request.HostName].RetryCount,
+		Progress:     job.Deployment.Status[request.HostName].Progress,
+	}
+
+	job.Status = entity.DeploymentJobStatusFailed
+	job.Deadline = nil
+	job.Deployment.BakeUntil = nil
+
+	// back to the canary batch, so the failed host can be retried
+	var canaryOrder uint
+	job.Deployment.CurrentOrder = &canaryOrder
+
+	job, err = m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: statusMessage(request.ActiveState, request.ErrorMessage), at: request.UpdatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.syncInstances(ctx, job, request.HostName)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := m.startNextJob(ctx, job.Ns, request.Service, request.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := HostRestartServiceUpdateResponse{
+		Job:         *job,
+		TriggerHost: request.HostName,
+		Failed:      true,
+		FailReason:  request.ErrorMessage,
+	}
+
+	encResult, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		m.topic.Broadcast(context.Background(), EventDeploymentFailed(resp))
+		m.broadcastNextJob(next)
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
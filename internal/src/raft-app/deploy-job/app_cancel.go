@@ -0,0 +1,131 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// anyHostSwitched returns true if at least one host already restart (or is restarting) the service to the job version
+func anyHostSwitched(job *entity.DeploymentJob) bool {
+	for _, info := range job.Deployment.Status {
+		switch info.Status {
+		case "", entity.HostDeploymentStatusPending, entity.HostDeploymentStatusCancelled:
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// hostCancelUpdate record the host acknowledgement of a cancelled job,
+// including the result of the rollback if it's requested.
+func (m *raftApp) hostCancelUpdate(ctx context.Context, request HostCancelUpdateRequest) (raft.OnAfterApply, error) {
+	jobs, err := m.jobUsecase.Get(ctx, request.Ns, []string{request.Service}, request.JobId)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, errNotFound("job nengendi???? not found")
+	}
+
+	job := jobs[0]
+	previousStatus := job.Status
+
+	if job.Status != entity.DeploymentJobStatusCancelled {
+		return nil, errInvalidState("job is not cancelled; actual: %v", job.Status)
+	}
+
+	_, isConfigurationHost := job.Configuration.Status[request.HostName]
+	_, isDeploymentHost := job.Deployment.Status[request.HostName]
+	if !isConfigurationHost && !isDeploymentHost {
+		return nil, errValidation("invalid host '%v'", request.HostName)
+	}
+
+	if request.ConfigurationStatus != "" && isConfigurationHost {
+		job.Configuration.Status[request.HostName] = entity.HostConfigurationStatusInfo{
+			Status:       request.ConfigurationStatus,
+			ErrorMessage: request.ErrorMessage,
+			RetryCount:   job.Configuration.Status[request.HostName].RetryCount,
+			Progress:     job.Configuration.Status[request.HostName].Progress,
+		}
+	}
+
+	if request.DeploymentStatus != "" && isDeploymentHost {
+		job.Deployment.Status[request.HostName] = entity.HostDeploymentStatusInfo{
+			Status:       request.DeploymentStatus,
+			ErrorMessage: request.ErrorMessage,
+			RetryCount:   job.Deployment.Status[request.HostName].RetryCount,
+			Progress:     job.Deployment.Status[request.HostName].Progress,
+		}
+	}
+
+	job, err = m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: statusMessage(fmt.Sprintf("configuration: %v, deployment: %v", request.ConfigurationStatus, request.DeploymentStatus), request.ErrorMessage), at: request.UpdatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.syncInstances(ctx, job, request.HostName)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.DeploymentStatus == entity.HostDeploymentStatusRolledBack {
+		err = m.restoreLatestDeployment(ctx, job, request.HostName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	encResult, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
+
+// restoreLatestDeployment point the host LatestDeployment back to the job that the host is rolled back to
+func (m *raftApp) restoreLatestDeployment(ctx context.Context, job *entity.DeploymentJob, host string) error {
+	if job.Cancellation == nil || job.Cancellation.RollbackJobId == "" {
+		return nil
+	}
+
+	// by ID; the rollback job might be already out of the latest jobs
+	rollbackJob, err := m.getJob(ctx, job.Ns, job.Request.Service.Id, job.Cancellation.RollbackJobId)
+	if err != nil {
+		return err
+	}
+
+	instances, err := m.serviceHost.Get(ctx, job.Request.Service.Ns, []string{job.Request.Service.Id}, "")
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		if instance.Host != host {
+			continue
+		}
+
+		instance.LatestDeployment = &entity.HostDeploymentJob{
+			DeploymentJob: rollbackJob,
+			Status:        entity.HostDeploymentStatusSuccess,
+		}
+
+		_, err = m.serviceHost.Post(ctx, instance, nil)
+		return err
+	}
+
+	return nil
+}
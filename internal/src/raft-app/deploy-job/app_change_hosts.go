@@ -0,0 +1,232 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// newServiceInstances creates the instances for the target hosts.
+// Replica ID must be unique, including the existing instances.
+// RaftPort is not yet assigned.
+func newServiceInstances(service entity.ServiceDefinition, targets []*entity.Host, existing []*entity.ServiceInstanceHost) ([]*entity.ServiceInstanceHost, error) {
+	uniqueReplicaID := make(map[uint64]struct{})
+	for _, instance := range existing {
+		if instance.RaftConfig != nil {
+			uniqueReplicaID[instance.RaftConfig.ReplicaID] = struct{}{}
+		}
+	}
+
+	// TODO: validate maxxing
+	// TODO: accept job to modify this; (but can be very later); eg. in case of server change disk address
+	instances := make([]*entity.ServiceInstanceHost, 0, len(targets))
+	for _, target := range targets {
+		nodeHostDir := path.Join(path.Clean(target.RaftConfig.NodeHostDir), fmt.Sprintf("%v_%v", service.Ns, service.Id))
+		walDir := path.Join(path.Clean(target.RaftConfig.WALDir), fmt.Sprintf("%v_%v", service.Ns, service.Id))
+
+		if _, ok := uniqueReplicaID[target.RaftConfig.ReplicaID]; ok {
+			return nil, errValidation("duplicate replica ID found: %v", target.RaftConfig.ReplicaID)
+		}
+		uniqueReplicaID[target.RaftConfig.ReplicaID] = struct{}{}
+
+		instances = append(instances, &entity.ServiceInstanceHost{
+			Ns:      service.Ns,
+			Service: service.Id,
+			Host:    target.Host,
+			RaftConfig: &entity.RaftConfig{
+				ReplicaID:      target.RaftConfig.ReplicaID,
+				RaftWALDir:     walDir,
+				NodeHostDir:    nodeHostDir, // make the same first
+				RTTMillisecond: 100,         // default
+			},
+		})
+	}
+
+	return instances, nil
+}
+
+// changeHostInstances compare the existing instances with the TargetHosts of a CHANGE_HOSTS request.
+// Returns the instances of the new hosts, and the instances to be removed.
+func changeHostInstances(request entity.SubmitDeploymentJobRequest, existing []*entity.ServiceInstanceHost) (added, removed []*entity.ServiceInstanceHost, err error) {
+	if len(existing) == 0 {
+		return nil, nil, errInvalidState("service is not deployed yet; please submit a normal job with target host")
+	}
+
+	if len(request.TargetHosts) == 0 {
+		return nil, nil, errValidation("target host cannot be empty; please remove the service instead")
+	}
+
+	desired := make(map[string]*entity.Host, len(request.TargetHosts))
+	for _, target := range request.TargetHosts {
+		if _, ok := desired[target.Host]; ok {
+			return nil, nil, errValidation("duplicate target host: %v", target.Host)
+		}
+		desired[target.Host] = target
+	}
+
+	existingByHost := make(map[string]*entity.ServiceInstanceHost, len(existing))
+	kept := make([]*entity.ServiceInstanceHost, 0, len(existing))
+	for _, instance := range existing {
+		existingByHost[instance.Host] = instance
+		if _, ok := desired[instance.Host]; ok {
+			kept = append(kept, instance)
+			continue
+		}
+		removed = append(removed, instance)
+	}
+
+	addedTargets := make([]*entity.Host, 0, len(request.TargetHosts))
+	for _, target := range request.TargetHosts {
+		if _, ok := existingByHost[target.Host]; !ok {
+			addedTargets = append(addedTargets, target)
+		}
+	}
+
+	if len(addedTargets) == 0 && len(removed) == 0 {
+		return nil, nil, errValidation("nothing to change; target hosts are the same as the current hosts")
+	}
+
+	added, err = newServiceInstances(request.Service, addedTargets, kept)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// keep the same raft port as the rest of the cluster
+	for _, instance := range kept {
+		if instance.RaftConfig == nil {
+			continue
+		}
+		for _, addedInstance := range added {
+			addedInstance.RaftConfig.RaftPort = instance.RaftConfig.RaftPort
+		}
+		break
+	}
+
+	return added, removed, nil
+}
+
+// latestGoodJob returns the latest DEPLOYED / SUCCESS job; nil if the service is never deployed
+func (m *raftApp) latestGoodJob(ctx context.Context, ns, service string) (*entity.DeploymentJob, error) {
+	serviceJob, _, err := m.serviceJob(ctx, ns, service)
+	if err != nil {
+		return nil, err
+	}
+	if len(serviceJob.Releases) == 0 {
+		return nil, nil
+	}
+
+	return m.getJob(ctx, ns, service, serviceJob.Releases[0].JobId)
+}
+
+// startDecommission is called once the new hosts are deployed (or directly, if there is no new host)
+func startDecommission(job *entity.DeploymentJob, from time.Time) {
+	job.Status = entity.DeploymentJobStatusDecommissioning
+	job.Deadline = phaseDeadline(job.Request, from)
+}
+
+// hostDecommissionUpdate feed the removed host uninstall state.
+// Once all removed hosts are uninstalled, the instances are deleted and their ports released.
+func (m *raftApp) hostDecommissionUpdate(ctx context.Context, request DecommissionUpdateRequest) (raft.OnAfterApply, error) {
+	jobs, err := m.jobUsecase.Get(ctx, request.Ns, []string{request.Service}, request.JobId)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, errNotFound("job nengendi???? not found")
+	}
+
+	job := jobs[0]
+	previousStatus := job.Status
+
+	if job.Status != entity.DeploymentJobStatusDecommissioning {
+		return nil, errInvalidState("job is not decommissioning; actual: %v", job.Status)
+	}
+
+	if _, ok := job.Decommission.Status[request.HostName]; !ok {
+		return nil, errValidation("host %v is not being decommissioned", request.HostName)
+	}
+
+	job.Decommission.Status[request.HostName] = entity.HostDecommissionStatusInfo{
+		Status:       request.Status,
+		ErrorMessage: request.ErrorMessage,
+	}
+
+	allDecommissioned := true
+	for _, info := range job.Decommission.Status {
+		allDecommissioned = allDecommissioned && info.Status == entity.HostDecommissionStatusSuccess
+	}
+
+	switch {
+	case request.Status == entity.HostDecommissionStatusFailed:
+		job.Status = entity.DeploymentJobStatusFailed
+		job.Deadline = nil
+	case allDecommissioned:
+		hosts := make([]string, 0, len(job.Decommission.Status))
+		for host := range job.Decommission.Status {
+			_, err = m.serviceHost.Delete(ctx, job.Request.Service.Ns, []string{job.Request.Service.Id}, host)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, host)
+		}
+
+		err = m.releasePorts(ctx, job.Request.Service.Ns, job.Request.Service.Id, hosts)
+		if err != nil {
+			return nil, err
+		}
+
+		keep, err := m.rollbackRelease(ctx, job)
+		if err != nil {
+			return nil, err
+		}
+		startCleanup(job, request.UpdatedAt, keep)
+	}
+
+	job, err = m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: statusMessage(string(request.Status), request.ErrorMessage), at: request.UpdatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	var next *entity.DeploymentJob
+	if job.Status.IsTerminal() {
+		next, err = m.startNextJob(ctx, job.Ns, request.Service, request.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp := HostRestartServiceUpdateResponse{
+		Job:         *job,
+		TriggerHost: request.HostName,
+		Failed:      job.Status == entity.DeploymentJobStatusFailed,
+		FailReason:  request.ErrorMessage,
+	}
+
+	encResult, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		switch job.Status {
+		case entity.DeploymentJobStatusFailed:
+			m.topic.Broadcast(context.Background(), EventDeploymentFailed(resp))
+		case entity.DeploymentJobStatusDeployed, entity.DeploymentJobStatusSuccess:
+			m.topic.Broadcast(context.Background(), EventAllServiceRestarted(resp))
+			m.broadcastCleanup(resp.Job)
+		}
+		m.broadcastNextJob(next)
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
@@ -0,0 +1,180 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// startCleanup is called once all hosts are deployed; every deployed host prune its old releases.
+// Nothing to clean up means the job is directly SUCCESS.
+func startCleanup(job *entity.DeploymentJob, from time.Time, keep *entity.ServiceRelease) {
+	job.Status = entity.DeploymentJobStatusDeployed
+	job.Deadline = phaseDeadline(job.Request, from)
+
+	if keep != nil {
+		job.Cleanup.KeepBuildVersion = keep.BuildVersion
+		job.Cleanup.KeepEnvVersion = keep.EnvVersion
+	}
+
+	job.Cleanup.Status = make(map[string]entity.HostCleanupStatusInfo, len(job.Deployment.HostOrder))
+	for _, host := range job.Deployment.HostOrder {
+		job.Cleanup.Status[host] = entity.HostCleanupStatusInfo{Status: entity.HostCleanupStatusPending}
+	}
+
+	if len(job.Cleanup.Status) == 0 {
+		job.Status = entity.DeploymentJobStatusSuccess
+		job.Deadline = nil
+	}
+}
+
+// rollbackRelease returns the known-good release the job would be rolled back to (see lastKnownGoodJob); nil if none
+func (m *raftApp) rollbackRelease(ctx context.Context, job *entity.DeploymentJob) (*entity.ServiceRelease, error) {
+	serviceJob, _, err := m.serviceJob(ctx, job.Ns, job.Request.Service.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, release := range serviceJob.Releases {
+		if release.BuildVersion == job.Request.BuildVersion && release.EnvVersion == job.Request.EnvVersion {
+			continue
+		}
+		return &release, nil
+	}
+
+	return nil, nil
+}
+
+// broadcastCleanup let the hosts start cleaning up, or tell everyone the job is finished
+func (m *raftApp) broadcastCleanup(job entity.DeploymentJob) {
+	switch job.Status {
+	case entity.DeploymentJobStatusDeployed:
+		m.topic.Broadcast(context.Background(), EventCleanupStarted{Job: job})
+	case entity.DeploymentJobStatusSuccess:
+		m.topic.Broadcast(context.Background(), EventCleanupFinished{Job: job})
+	}
+}
+
+// hostCleanupUpdate feed the host cleanup state.
+// Clean up is best effort: the service is already deployed, so a failed host does not fail the job;
+// once every host finished (successfully or not), the job is SUCCESS.
+func (m *raftApp) hostCleanupUpdate(ctx context.Context, request CleanupUpdateRequest) (raft.OnAfterApply, error) {
+	jobs, err := m.jobUsecase.Get(ctx, request.Ns, []string{request.Service}, request.JobId)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, errNotFound("job nengendi???? not found")
+	}
+
+	job := jobs[0]
+	previousStatus := job.Status
+
+	if job.Status != entity.DeploymentJobStatusDeployed {
+		return nil, errInvalidState("job is not cleaning up; actual: %v", job.Status)
+	}
+
+	if _, ok := job.Cleanup.Status[request.HostName]; !ok {
+		return nil, errValidation("host %v is not cleaning up", request.HostName)
+	}
+
+	job.Cleanup.Status[request.HostName] = entity.HostCleanupStatusInfo{
+		Status:       request.Status,
+		ErrorMessage: request.ErrorMessage,
+		Removed:      request.Removed,
+	}
+
+	if job.Cleanup.IsFinished() {
+		job.Status = entity.DeploymentJobStatusSuccess
+		job.Deadline = nil
+	}
+
+	job, err = m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: hostActor(request.HostName), host: request.HostName, message: statusMessage(string(request.Status), request.ErrorMessage), at: request.UpdatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	var next *entity.DeploymentJob
+	if job.Status.IsTerminal() {
+		err = m.syncInstances(ctx, job, job.Deployment.HostOrder...)
+		if err != nil {
+			return nil, err
+		}
+
+		next, err = m.startNextJob(ctx, job.Ns, request.Service, request.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	encResult, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		if job.Status == entity.DeploymentJobStatusSuccess {
+			m.topic.Broadcast(context.Background(), EventCleanupFinished{Job: *job})
+		}
+		m.broadcastNextJob(next)
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
+
+// timeoutCleanup finish the job whose hosts did not finish cleaning up in time.
+// The service is already deployed, so the job is SUCCESS; the unfinished hosts are marked TIMEOUT.
+func (m *raftApp) timeoutCleanup(ctx context.Context, job *entity.DeploymentJob, request JobTimeoutRequest) (raft.OnAfterApply, error) {
+	previousStatus := job.Status
+
+	for host, info := range job.Cleanup.Status {
+		if info.Status == entity.HostCleanupStatusPending || info.Status == entity.HostCleanupStatusCleaning {
+			info.Status = entity.HostCleanupStatusTimeOut
+			job.Cleanup.Status[host] = info
+		}
+	}
+
+	job.Status = entity.DeploymentJobStatusSuccess
+	job.Deadline = nil
+
+	job, err := m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: request.Agent, message: "clean up deadline passed", at: request.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.syncInstances(ctx, job, job.Deployment.HostOrder...)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := m.startNextJob(ctx, job.Ns, request.Service, request.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	encResult, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		// let the host stop cleaning up
+		m.topic.Broadcast(context.Background(), EventDeploymentJobTimedOut{Job: *job})
+		m.topic.Broadcast(context.Background(), EventCleanupFinished{Job: *job})
+		m.broadcastNextJob(next)
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
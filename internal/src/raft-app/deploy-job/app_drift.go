@@ -0,0 +1,86 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// hostDriftUpdate record the drift of a service instance, so everyone know the host no longer match the latest deployment.
+// Repaired drift is only kept in the audit trail.
+func (m *raftApp) hostDriftUpdate(ctx context.Context, request DriftUpdateRequest) (raft.OnAfterApply, error) {
+	instances, err := m.serviceHost.Get(ctx, request.Ns, []string{request.Service}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var instance *entity.ServiceInstanceHost
+	for _, candidate := range instances {
+		if candidate.Host == request.HostName {
+			instance = candidate
+		}
+	}
+	if instance == nil {
+		return nil, errNotFound("service %v is not deployed on host %v", request.Service, request.HostName)
+	}
+
+	var message string
+	switch {
+	case len(request.Items) == 0:
+		if instance.Drift == nil {
+			return nil, errInvalidState("service %v on host %v has no drift", request.Service, request.HostName)
+		}
+		instance.Drift = nil
+		message = "drift resolved"
+	case request.Repaired:
+		instance.Drift = nil
+		message = "drift repaired: " + driftMessage(request.Items)
+	default:
+		detectedAt := request.CheckedAt
+		if instance.Drift != nil {
+			detectedAt = instance.Drift.DetectedAt
+		}
+		instance.Drift = &entity.HostDrift{Items: request.Items, DetectedAt: detectedAt}
+		message = "drift detected: " + driftMessage(request.Items)
+	}
+
+	instance, err = m.serviceHost.Post(ctx, instance, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.auditService(ctx, request.Ns, request.Service, entity.JobAuditNoJob, "", auditRecord{
+		actor:   hostActor(request.HostName),
+		host:    request.HostName,
+		message: message,
+		at:      request.CheckedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encResult, err := json.Marshal(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		if instance.Drift != nil {
+			m.topic.Broadcast(context.Background(), EventHostDriftDetected{Instance: *instance})
+		}
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
+
+func driftMessage(items []entity.DriftItem) string {
+	messages := make([]string, 0, len(items))
+	for _, item := range items {
+		messages = append(messages, fmt.Sprintf("%v (expected %q, actual %q)", item.Kind, item.Expected, item.Actual))
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,128 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// freezeNamespace blocks new job & restart in the namespace; replace the existing freeze if any
+func (m *raftApp) freezeNamespace(ctx context.Context, request FreezeRequest) (raft.OnAfterApply, error) {
+	if request.Ns == "" {
+		return nil, errValidation("namespace is required")
+	}
+	if request.Reason == "" {
+		return nil, errValidation("reason is required")
+	}
+	if request.Until != nil && !request.Until.After(request.CreatedAt) {
+		return nil, errValidation("until (%v) must be after now (%v)", request.Until, request.CreatedAt)
+	}
+
+	freeze, err := m.freeze.Post(ctx, &entity.Freeze{
+		Ns:          request.Ns,
+		Id:          entity.FreezeID,
+		Reason:      request.Reason,
+		FrozenBy:    request.Agent,
+		FrozenAt:    request.CreatedAt,
+		Until:       request.Until,
+		PublishedAt: request.CreatedAt,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	message := "frozen: " + request.Reason
+	if request.Until != nil {
+		message += fmt.Sprintf(" (until %v)", request.Until)
+	}
+
+	err = m.auditService(ctx, request.Ns, entity.JobAuditNoJob, entity.JobAuditNoJob, "", auditRecord{
+		actor:   request.Agent,
+		message: message,
+		at:      request.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encResult, err := json.Marshal(freeze)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
+
+// unfreezeNamespace lift the freeze. Held restarts are released by the leader at their OpenAt.
+func (m *raftApp) unfreezeNamespace(ctx context.Context, request UnfreezeRequest) (raft.OnAfterApply, error) {
+	freezes, err := m.freeze.Get(ctx, request.Ns, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(freezes) == 0 {
+		return nil, errNotFound("namespace %v is not frozen", request.Ns)
+	}
+
+	// delete only returns a placeholder, not the deleted freeze
+	freeze := freezes[0]
+	_, err = m.freeze.Delete(ctx, request.Ns, nil, entity.FreezeID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.auditService(ctx, request.Ns, entity.JobAuditNoJob, entity.JobAuditNoJob, "", auditRecord{
+		actor:   request.Agent,
+		message: "unfrozen; was: " + freeze.Reason,
+		at:      request.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encResult, err := json.Marshal(freeze)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
+
+// activeFreeze returns the freeze of the namespace that is still in effect at t; nil if not frozen
+func (m *raftApp) activeFreeze(ctx context.Context, ns string, t time.Time) (*entity.Freeze, error) {
+	freezes, err := m.freeze.Get(ctx, ns, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, freeze := range freezes {
+		if freeze.IsActive(t) {
+			return freeze, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func frozenError(freeze *entity.Freeze) error {
+	if freeze.Until != nil {
+		return errFrozen("namespace %v is frozen by %v until %v: %v; set override_freeze for emergency fix", freeze.Ns, freeze.FrozenBy, freeze.Until, freeze.Reason)
+	}
+	return errFrozen("namespace %v is frozen by %v: %v; set override_freeze for emergency fix", freeze.Ns, freeze.FrozenBy, freeze.Reason)
+}
+
+// freezeOverridden is appended to the audit message if the job is deployed during freeze
+func freezeOverridden(freeze *entity.Freeze) string {
+	if freeze == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (freeze overridden: %v)", freeze.Reason)
+}
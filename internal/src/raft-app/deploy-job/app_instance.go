@@ -0,0 +1,67 @@
+package deployjob
+
+import (
+	"context"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// syncInstances update the service instance of the hosts based on the job state, so we know what is running on which host:
+//   - ActiveDeployment: the job that is still working on the host
+//   - LatestDeployment: the latest job that successfully restarted the service on the host
+func (m *raftApp) syncInstances(ctx context.Context, job *entity.DeploymentJob, hosts ...string) error {
+	instances, err := m.serviceHost.Get(ctx, job.Request.Service.Ns, []string{job.Request.Service.Id}, "")
+	if err != nil {
+		return err
+	}
+
+	instanceByHost := make(map[string]*entity.ServiceInstanceHost, len(instances))
+	for _, instance := range instances {
+		instanceByHost[instance.Host] = instance
+	}
+
+	for _, host := range hosts {
+		instance, ok := instanceByHost[host]
+		if !ok {
+			// not (yet) an instance of the service
+			continue
+		}
+
+		if job.Status == entity.DeploymentJobStatusQueued {
+			// nothing happen on the host yet
+			continue
+		}
+
+		// snapshot, so later update on the job does not affect it
+		snapshot := *job
+		hostJob := &entity.HostDeploymentJob{
+			DeploymentJob: &snapshot,
+			Status:        job.Deployment.Status[host].Status,
+		}
+
+		isActive := instance.ActiveDeployment != nil && instance.ActiveDeployment.DeploymentJob != nil &&
+			instance.ActiveDeployment.Id == job.Id
+
+		switch {
+		case hostJob.Status == entity.HostDeploymentStatusSuccess:
+			instance.LatestDeployment = hostJob
+			instance.Drift = nil // the host is deployed again
+			if isActive {
+				instance.ActiveDeployment = nil
+			}
+		case job.Status.IsTerminal():
+			if isActive {
+				instance.ActiveDeployment = nil
+			}
+		default:
+			instance.ActiveDeployment = hostJob
+		}
+
+		_, err = m.serviceHost.Post(ctx, instance, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package deployjob
+
+import (
+	"context"
+	"errors"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// Lookup answer the plan query; the rest are handled by the content store
+func (m *raftApp) Lookup(ctx context.Context, key interface{}) (interface{}, error) {
+	query, ok := key.(PlanQuery)
+	if !ok {
+		return m.Store.Lookup(ctx, key)
+	}
+
+	plan, err := m.plan(ctx, query.Request)
+	if err != nil {
+		var appErr *Error
+		if !errors.As(err, &appErr) {
+			return nil, err
+		}
+		return PlanResponse{Err: appErr}, nil
+	}
+
+	return PlanResponse{Plan: plan}, nil
+}
+
+// plan is the dry run of userSubmitJob; it goes through the same checks, but does not modify anything.
+// The host local view & the rendered unit are added outside of raft.
+func (m *raftApp) plan(ctx context.Context, request entity.SubmitDeploymentJobRequest) (entity.DeploymentPlan, error) {
+	busy, freeze, err := m.admitJob(ctx, request)
+	if err != nil {
+		return entity.DeploymentPlan{}, err
+	}
+
+	instances, removedInstances, needRaftPort, err := m.targetInstances(ctx, &request, busy)
+	if err != nil {
+		return entity.DeploymentPlan{}, err
+	}
+
+	plan := entity.DeploymentPlan{
+		Ns:               request.Ns,
+		Service:          request.Service.Id,
+		Type:             request.Type,
+		SubmitStatus:     string(SubmitJobStatusSuccess),
+		BuildVersion:     request.BuildVersion,
+		EnvVersion:       request.EnvVersion,
+		Hosts:            make([]entity.HostPlan, 0, len(instances)),
+		RemovedHosts:     instanceHosts(removedInstances),
+		OverriddenFreeze: freeze,
+	}
+	if busy {
+		plan.SubmitStatus = string(SubmitJobStatusQueued)
+	}
+
+	for _, instance := range instances {
+		hostPlan := entity.HostPlan{
+			Host:         instance.Host,
+			New:          instance.LatestDeployment == nil,
+			BuildVersion: request.BuildVersion,
+			EnvVersion:   request.EnvVersion,
+		}
+		if instance.LatestDeployment != nil && instance.LatestDeployment.DeploymentJob != nil {
+			hostPlan.DeployedBuildVersion = instance.LatestDeployment.Request.BuildVersion
+			hostPlan.DeployedEnvVersion = instance.LatestDeployment.Request.EnvVersion
+		}
+		plan.Hosts = append(plan.Hosts, hostPlan)
+	}
+
+	// the same as allocatePort & reserveServicePorts, without registering
+	if needRaftPort {
+		port, err := m.freePort(ctx, request.Service, instanceHosts(instances))
+		if err != nil {
+			return entity.DeploymentPlan{}, err
+		}
+		for _, instance := range instances {
+			plan.Ports = append(plan.Ports, entity.PlannedPort{Host: instance.Host, Port: port, Purpose: entity.HostPortPurposeRaft, New: true})
+		}
+	}
+
+	for _, instance := range instances {
+		var ports []entity.PlannedPort
+		if !needRaftPort && instance.RaftConfig != nil && instance.RaftConfig.RaftPort != 0 {
+			ports = append(ports, entity.PlannedPort{Host: instance.Host, Port: instance.RaftConfig.RaftPort, Purpose: entity.HostPortPurposeRaft})
+		}
+		for _, address := range request.Service.BoundAddresses {
+			if address.Port <= 0 || address.Port > 65535 {
+				return entity.DeploymentPlan{}, errValidation("invalid bound port %v", address.Port)
+			}
+			ports = append(ports, entity.PlannedPort{Host: instance.Host, Port: uint16(address.Port), Purpose: entity.HostPortPurposeBound})
+		}
+
+		for _, port := range ports {
+			registered, err := m.checkPort(ctx, request.Service.Ns, request.Service.Id, port.Host, port.Port)
+			if err != nil {
+				return entity.DeploymentPlan{}, err
+			}
+			port.New = !registered
+			plan.Ports = append(plan.Ports, port)
+		}
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,183 @@
+package deployjob
+
+import (
+	"context"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// usedPorts returns the registered ports of the hosts (all namespace)
+func (m *raftApp) usedPorts(ctx context.Context, hosts []string) (map[string]map[uint16]*entity.HostPort, error) {
+	result := make(map[string]map[uint16]*entity.HostPort, len(hosts))
+	for _, host := range hosts {
+		ports, err := m.hostPort.Get(ctx, "*", []string{host}, "")
+		if err != nil {
+			return nil, err
+		}
+
+		result[host] = make(map[uint16]*entity.HostPort, len(ports))
+		for _, port := range ports {
+			result[host][port.Port] = port
+		}
+	}
+	return result, nil
+}
+
+// freePort returns the lowest port in the configured range that is free in all the hosts.
+// The bound ports of the service are skipped too; on the first deploy they are not registered yet.
+// Deterministic; only depends on the state.
+func (m *raftApp) freePort(ctx context.Context, service entity.ServiceDefinition, hosts []string) (uint16, error) {
+	used, err := m.usedPorts(ctx, hosts)
+	if err != nil {
+		return 0, err
+	}
+
+	for port := uint32(m.config.PortRangeStart); port <= uint32(m.config.PortRangeEnd); port++ {
+		if isBoundPort(service, port) {
+			continue
+		}
+
+		free := true
+		for _, host := range hosts {
+			if _, ok := used[host][uint16(port)]; ok {
+				free = false
+				break
+			}
+		}
+		if free {
+			return uint16(port), nil
+		}
+	}
+
+	return 0, errConflict("no free port left in range %v-%v for hosts %v", m.config.PortRangeStart, m.config.PortRangeEnd, hosts)
+}
+
+func isBoundPort(service entity.ServiceDefinition, port uint32) bool {
+	for _, address := range service.BoundAddresses {
+		if address.Port > 0 && uint32(address.Port) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// allocatePort register the free port in all the hosts (see freePort)
+func (m *raftApp) allocatePort(ctx context.Context, service entity.ServiceDefinition, hosts []string, purpose entity.HostPortPurpose) (uint16, error) {
+	port, err := m.freePort(ctx, service, hosts)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, host := range hosts {
+		err = m.reservePort(ctx, service.Ns, service.Id, host, port, purpose)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return port, nil
+}
+
+// checkPort returns true if the port is already registered by the service; error if it is used by other service
+func (m *raftApp) checkPort(ctx context.Context, ns, service, host string, port uint16) (bool, error) {
+	used, err := m.usedPorts(ctx, []string{host})
+	if err != nil {
+		return false, err
+	}
+
+	existing, ok := used[host][port]
+	if !ok {
+		return false, nil
+	}
+	if existing.Ns == ns && existing.Service == service {
+		return true, nil
+	}
+	return false, errConflict("port %v in host %v is already used by service %v/%v (%v)", port, host, existing.Ns, existing.Service, existing.Purpose)
+}
+
+// reservePort register a known port of the service (eg. BoundAddresses). Idempotent for the same service.
+func (m *raftApp) reservePort(ctx context.Context, ns, service, host string, port uint16, purpose entity.HostPortPurpose) error {
+	registered, err := m.checkPort(ctx, ns, service, host, port)
+	if err != nil || registered {
+		return err
+	}
+
+	_, err = m.hostPort.Post(ctx, &entity.HostPort{
+		Ns:      ns,
+		Host:    host,
+		Port:    port,
+		Service: service,
+		Purpose: purpose,
+	}, nil)
+	return err
+}
+
+// checkServicePorts returns error if the raft port or the bound addresses of the service instances can't be registered.
+// Does not register anything; the command is applied even if it fails halfway, so check before allocating.
+func (m *raftApp) checkServicePorts(ctx context.Context, service entity.ServiceDefinition, instances []*entity.ServiceInstanceHost) error {
+	for _, instance := range instances {
+		if instance.RaftConfig != nil && instance.RaftConfig.RaftPort != 0 {
+			_, err := m.checkPort(ctx, service.Ns, service.Id, instance.Host, instance.RaftConfig.RaftPort)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, address := range service.BoundAddresses {
+			if address.Port <= 0 || address.Port > 65535 {
+				return errValidation("invalid bound port %v", address.Port)
+			}
+			_, err := m.checkPort(ctx, service.Ns, service.Id, instance.Host, uint16(address.Port))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reserveServicePorts register the raft port and the bound addresses of the service instances
+func (m *raftApp) reserveServicePorts(ctx context.Context, service entity.ServiceDefinition, instances []*entity.ServiceInstanceHost) error {
+	err := m.checkServicePorts(ctx, service, instances)
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		if instance.RaftConfig != nil && instance.RaftConfig.RaftPort != 0 {
+			err := m.reservePort(ctx, service.Ns, service.Id, instance.Host, instance.RaftConfig.RaftPort, entity.HostPortPurposeRaft)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, address := range service.BoundAddresses {
+			err := m.reservePort(ctx, service.Ns, service.Id, instance.Host, uint16(address.Port), entity.HostPortPurposeBound)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releasePorts remove all ports of the service in the hosts
+func (m *raftApp) releasePorts(ctx context.Context, ns, service string, hosts []string) error {
+	used, err := m.usedPorts(ctx, hosts)
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		for _, port := range used[host] {
+			if port.Ns != ns || port.Service != service {
+				continue
+			}
+
+			_, err = m.hostPort.Delete(ctx, port.Ns, []string{host}, port.ID())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
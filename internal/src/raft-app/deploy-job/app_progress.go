@@ -0,0 +1,35 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/desain-gratis/common/lib/raft"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// saveProgress store the job with the updated host progress.
+// Progress is frequent & not a state transition; it is not audited, and no event is broadcasted.
+func (m *raftApp) saveProgress(ctx context.Context, job *entity.DeploymentJob, resp any) (raft.OnAfterApply, error) {
+	_, err := m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	encResult, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
+
+// latestProgress keep the last reported progress if the update does not carry one
+func latestProgress(reported, previous *entity.HostProgress) *entity.HostProgress {
+	if reported != nil {
+		return reported
+	}
+	return previous
+}
@@ -0,0 +1,72 @@
+package deployjob
+
+import (
+	"context"
+	"time"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// maximum queued job per service
+const maxQueuedJob = 5
+
+// known-good releases kept per service for rollback
+const maxServiceRelease = 10
+
+// startNextJob starts the oldest queued job of the service, if any.
+// "from" must come from the command that finish the previous job to keep the state machine deterministic.
+// Caller should broadcast EventDeploymentJobCreated for the returned job after apply.
+func (m *raftApp) startNextJob(ctx context.Context, ns, service string, from time.Time) (*entity.DeploymentJob, error) {
+	serviceJob, _, err := m.serviceJob(ctx, ns, service)
+	if err != nil {
+		return nil, err
+	}
+
+	if serviceJob.ActiveJobId != "" || len(serviceJob.QueuedJobIds) == 0 {
+		return nil, nil
+	}
+
+	next, err := m.getJob(ctx, ns, service, serviceJob.QueuedJobIds[0])
+	if err != nil {
+		return nil, err
+	}
+
+	next.Status = entity.DeploymentJobStatusConfiguring
+	next.Deadline = phaseDeadline(next.Request, from)
+
+	next, err = m.postJob(ctx, next, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, next, auditRecord{previous: entity.DeploymentJobStatusQueued, actor: next.Request.Agent, message: "started from queue", at: from})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.syncInstances(ctx, next, next.Deployment.HostOrder...)
+	if err != nil {
+		return nil, err
+	}
+
+	return next, nil
+}
+
+// broadcastNextJob let the host start configuring the next job
+func (m *raftApp) broadcastNextJob(next *entity.DeploymentJob) {
+	if next == nil {
+		return
+	}
+
+	m.topic.Broadcast(context.Background(), EventDeploymentJobCreated(SubmitJobResponse{
+		SubmitJobStatus: SubmitJobStatusSuccess,
+		Job:             *next,
+	}))
+}
+
+func activeJobId(serviceJob *entity.ServiceJob) string {
+	if serviceJob.ActiveJobId == "" {
+		return "-"
+	}
+	return serviceJob.ActiveJobId
+}
@@ -0,0 +1,71 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// removeService delete the service instances and release its ports, so other service can use it.
+// It does not touch the host (yet); the service should be already stopped.
+func (m *raftApp) removeService(ctx context.Context, request RemoveServiceRequest) (raft.OnAfterApply, error) {
+	serviceJob, _, err := m.serviceJob(ctx, request.Ns, request.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	if serviceJob.ActiveJobId != "" || len(serviceJob.QueuedJobIds) > 0 {
+		return nil, errConflict("service still has active job (active: %v, queued: %v)", activeJobId(serviceJob), len(serviceJob.QueuedJobIds))
+	}
+
+	instances, err := m.serviceHost.Get(ctx, request.Ns, []string{request.Service}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		hosts = append(hosts, instance.Host)
+	}
+
+	err = m.releasePorts(ctx, request.Ns, request.Service, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, instance := range instances {
+		_, err = m.serviceHost.Delete(ctx, request.Ns, []string{request.Service}, instance.Host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = m.auditService(ctx, request.Ns, request.Service, entity.JobAuditNoJob, "", auditRecord{
+		actor:   request.Agent,
+		message: fmt.Sprintf("service removed from %v host(s)", len(instances)),
+		at:      request.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := EventServiceRemoved{
+		Ns:        request.Ns,
+		Service:   request.Service,
+		Instances: instances,
+	}
+
+	encResult, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		m.topic.Broadcast(context.Background(), resp)
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
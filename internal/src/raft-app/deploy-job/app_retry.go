@@ -0,0 +1,159 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// retryHost re-run the failed phase of a single host, instead of submitting a new job.
+//   - configuration: only while the job is still in configuration phase
+//   - deployment: the failed host is always in the current batch (the job stops there), so we continue from it
+//   - decommission: the removed host uninstall again, so it does not stay half removed
+//
+// A timed out job is reopened in the retried phase with a new deadline, as long as no other job took over the service.
+func (m *raftApp) retryHost(ctx context.Context, request RetryHostRequest) (raft.OnAfterApply, error) {
+	jobs, err := m.jobUsecase.Get(ctx, request.Ns, []string{request.Service}, request.JobId)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, errNotFound("job nengendi???? not found")
+	}
+
+	job := jobs[0]
+	previousStatus := job.Status
+
+	// retrying an old job may overwrite newer deployment
+	serviceJob, _, err := m.serviceJob(ctx, request.Ns, request.Service)
+	if err != nil {
+		return nil, err
+	}
+	if idSequence(serviceJob.LatestJobId) > jobSequence(job) {
+		return nil, errConflict("job is superseded by newer job %v", serviceJob.LatestJobId)
+	}
+	if serviceJob.ActiveJobId != "" && serviceJob.ActiveJobId != job.Id {
+		return nil, errConflict("service already has active job %v", serviceJob.ActiveJobId)
+	}
+
+	configStatus, isConfigurationHost := job.Configuration.Status[request.HostName]
+	decommissionStatus, isDecommissionHost := job.Decommission.Status[request.HostName]
+	if !isConfigurationHost && !isDecommissionHost {
+		return nil, errValidation("invalid host '%v'. available hosts are: %v", request.HostName, job.Configuration.Status)
+	}
+
+	var phase RetryPhase
+	switch configStatus.Status {
+	case entity.HostConfigurationStatusFailed, entity.HostConfigurationStatusCancelled, entity.HostConfigurationStatusTimeOut:
+		phase = RetryPhaseConfiguration
+	}
+
+	deployStatus := job.Deployment.Status[request.HostName]
+	switch deployStatus.Status {
+	case entity.HostDeploymentStatusFailed, entity.HostDeploymentStatusTimeOut:
+		phase = RetryPhaseDeployment
+	}
+
+	switch decommissionStatus.Status {
+	case entity.HostDecommissionStatusFailed, entity.HostDecommissionStatusTimeOut:
+		phase = RetryPhaseDecommission
+	}
+
+	switch phase {
+	case RetryPhaseConfiguration:
+		if job.Status != entity.DeploymentJobStatusConfiguring && job.Status != entity.DeploymentJobStatusTimeOut {
+			return nil, errInvalidState("cannot retry configuration of job with status %v", job.Status)
+		}
+
+		if configStatus.RetryCount >= maxHostRetry {
+			return nil, errInvalidState("host '%v' already retried %v times", request.HostName, configStatus.RetryCount)
+		}
+
+		job.Status = entity.DeploymentJobStatusConfiguring
+		job.Configuration.Status[request.HostName] = entity.HostConfigurationStatusInfo{
+			Status:     entity.HostConfigurationStatusPending,
+			RetryCount: configStatus.RetryCount + 1,
+		}
+	case RetryPhaseDeployment:
+		// the host can also report a time out while the job is still deploying
+		switch job.Status {
+		case entity.DeploymentJobStatusFailed, entity.DeploymentJobStatusDeploying, entity.DeploymentJobStatusTimeOut:
+		default:
+			return nil, errInvalidState("cannot retry deployment of job with status %v", job.Status)
+		}
+
+		if !job.Deployment.InCurrentBatch(request.HostName) {
+			return nil, errInvalidState("host %v is not in the current batch on deployment", request.HostName)
+		}
+
+		if deployStatus.RetryCount >= maxHostRetry {
+			return nil, errInvalidState("host '%v' already retried %v times", request.HostName, deployStatus.RetryCount)
+		}
+
+		job.Status = entity.DeploymentJobStatusDeploying
+		job.Deployment.Status[request.HostName] = entity.HostDeploymentStatusInfo{
+			Status:     entity.HostDeploymentStatusPending,
+			RetryCount: deployStatus.RetryCount + 1,
+		}
+	case RetryPhaseDecommission:
+		// the other removed hosts might still be uninstalling when the job failed
+		switch job.Status {
+		case entity.DeploymentJobStatusFailed, entity.DeploymentJobStatusDecommissioning, entity.DeploymentJobStatusTimeOut:
+		default:
+			return nil, errInvalidState("cannot retry decommission of job with status %v", job.Status)
+		}
+
+		if decommissionStatus.RetryCount >= maxHostRetry {
+			return nil, errInvalidState("host '%v' already retried %v times", request.HostName, decommissionStatus.RetryCount)
+		}
+
+		job.Status = entity.DeploymentJobStatusDecommissioning
+		job.Decommission.Status[request.HostName] = entity.HostDecommissionStatusInfo{
+			Status:     entity.HostDecommissionStatusPending,
+			RetryCount: decommissionStatus.RetryCount + 1,
+		}
+	default:
+		return nil, errInvalidState("nothing to retry on host '%v' (configuration: %v, deployment: %v)",
+			request.HostName, configStatus.Status, deployStatus.Status)
+	}
+
+	// the retried phase start again
+	job.Deadline = phaseDeadline(job.Request, request.CreatedAt)
+
+	job, err = m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: request.Agent, host: request.HostName, message: "retry " + string(phase), at: request.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	// the removed host is not part of the deployment
+	if phase != RetryPhaseDecommission {
+		err = m.syncInstances(ctx, job, request.HostName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp := EventHostRetry{
+		Phase:      phase,
+		TargetHost: request.HostName,
+		Job:        *job,
+	}
+
+	encResult, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		m.topic.Broadcast(context.Background(), resp)
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
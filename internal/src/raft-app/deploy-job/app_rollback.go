@@ -0,0 +1,71 @@
+package deployjob
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// rollbackJob re-submit the last known-good release of a service as a brand new job.
+// The new job goes through the same configure / restart flow as the user submitted one.
+func (m *raftApp) rollbackJob(ctx context.Context, request RollbackJobRequest) (raft.OnAfterApply, error) {
+	goodJob, err := m.lastKnownGoodJob(ctx, request.Ns, request.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	// same versions, same service definition, same target hosts
+	submit := goodJob.Request
+	submit.Id = ""
+	submit.RollbackOf = goodJob.Id
+	submit.Agent = request.Agent
+	submit.IsBelieve = request.IsBelieve
+	submit.PublishedAt = request.CreatedAt
+
+	if request.TimeoutSeconds != nil {
+		submit.TimeoutSeconds = request.TimeoutSeconds
+	}
+
+	return m.userSubmitJob(ctx, submit)
+}
+
+// lastKnownGoodJob returns the latest job that reached DEPLOYED / SUCCESS,
+// skipping the latest job (the one we want to rollback from) and every job that deploy the same versions as it.
+// The releases are tracked in the service job pointer, so it's not limited to the latest updated jobs.
+func (m *raftApp) lastKnownGoodJob(ctx context.Context, ns, service string) (*entity.DeploymentJob, error) {
+	serviceJob, _, err := m.serviceJob(ctx, ns, service)
+	if err != nil {
+		return nil, err
+	}
+	if serviceJob.LatestJobId == "" {
+		return nil, errNotFound("no job found for this service")
+	}
+
+	latest, err := m.getJob(ctx, ns, service, serviceJob.LatestJobId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, release := range serviceJob.Releases {
+		if release.BuildVersion == latest.Request.BuildVersion && release.EnvVersion == latest.Request.EnvVersion {
+			continue
+		}
+
+		return m.getJob(ctx, ns, service, release.JobId)
+	}
+
+	return nil, errNotFound("no known-good release found before job %v", latest.Id)
+}
+
+// job table uses incremental ID, so the ID is the job sequence
+func jobSequence(job *entity.DeploymentJob) uint64 {
+	return idSequence(job.Id)
+}
+
+func idSequence(id string) uint64 {
+	seq, _ := strconv.ParseUint(id, 10, 64)
+	return seq
+}
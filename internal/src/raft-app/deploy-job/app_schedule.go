@@ -0,0 +1,60 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// holdRestart records the restart confirmation that is not allowed yet (outside of the deploy window / namespace frozen).
+// The job stays in the current state; the leader confirm it again at PendingSchedule.OpenAt.
+func (m *raftApp) holdRestart(ctx context.Context, job *entity.DeploymentJob, request RestartConfirmation, openAt time.Time, reason string) (raft.OnAfterApply, error) {
+	confirmedBy := request.Agent
+	if job.PendingSchedule != nil {
+		// window passed before the leader confirm it; keep the original
+		confirmedBy = job.PendingSchedule.ConfirmedBy
+	}
+
+	job.PendingSchedule = &entity.PendingSchedule{
+		OpenAt:      openAt,
+		ConfirmedBy: confirmedBy,
+		Message:     request.Message,
+		ConfirmedAt: request.CreatedAt,
+	}
+
+	// waiting for the window, not for the host
+	job.Deadline = nil
+
+	job, err := m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("restart held until %v: %v", openAt, reason)
+	err = m.audit(ctx, job, auditRecord{previous: job.Status, actor: request.Agent, message: message, at: request.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := HostRestartConfirmationResponse{
+		Job:     *job,
+		Message: message,
+	}
+	if job.Deployment.CurrentOrder != nil {
+		resp.Step = int(*job.Deployment.CurrentOrder)
+	}
+
+	encResult, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
@@ -0,0 +1,142 @@
+package deployjob
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+
+	"github.com/desain-gratis/common/delivery/mycontent-api/mycontent"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// postJob store the job and keep the job pointer of the service up to date.
+// Every job update goes through here, so the pointer never miss a status change.
+func (m *raftApp) postJob(ctx context.Context, job *entity.DeploymentJob, meta any) (*entity.DeploymentJob, error) {
+	result, err := m.jobUsecase.Post(ctx, job, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceJob, stored, err := m.serviceJob(ctx, result.Ns, result.Request.Service.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !trackJob(serviceJob, result) && stored {
+		return result, nil
+	}
+
+	_, err = m.serviceJobs.Post(ctx, serviceJob, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// serviceJob returns the job pointer of the service, and whether it is already stored.
+// For service deployed before the pointer exists, it is built from the latest jobs.
+func (m *raftApp) serviceJob(ctx context.Context, ns, service string) (*entity.ServiceJob, bool, error) {
+	serviceJobs, err := m.serviceJobs.Get(ctx, ns, nil, service)
+	if err != nil && !errors.Is(err, mycontent.ErrNotFound) {
+		return nil, false, err
+	}
+	if len(serviceJobs) == 1 {
+		return serviceJobs[0], true, nil
+	}
+
+	jobs, err := m.jobUsecase.Get(ctx, ns, []string{service}, "")
+	if err != nil {
+		return nil, false, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobSequence(jobs[i]) < jobSequence(jobs[j])
+	})
+
+	serviceJob := &entity.ServiceJob{Ns: ns, Service: service}
+	for _, job := range jobs {
+		trackJob(serviceJob, job)
+	}
+
+	return serviceJob, false, nil
+}
+
+// trackJob update the pointer based on the job status; returns true if changed
+func trackJob(serviceJob *entity.ServiceJob, job *entity.DeploymentJob) bool {
+	latest, active, queued := serviceJob.LatestJobId, serviceJob.ActiveJobId, slices.Clone(serviceJob.QueuedJobIds)
+	releases := slices.Clone(serviceJob.Releases)
+
+	if serviceJob.LatestJobId == "" || jobSequence(job) > idSequence(serviceJob.LatestJobId) {
+		serviceJob.LatestJobId = job.Id
+	}
+
+	serviceJob.QueuedJobIds = slices.DeleteFunc(serviceJob.QueuedJobIds, func(id string) bool { return id == job.Id })
+
+	switch {
+	case job.Status == entity.DeploymentJobStatusQueued:
+		serviceJob.QueuedJobIds = append(serviceJob.QueuedJobIds, job.Id)
+		sort.Slice(serviceJob.QueuedJobIds, func(i, j int) bool {
+			return idSequence(serviceJob.QueuedJobIds[i]) < idSequence(serviceJob.QueuedJobIds[j])
+		})
+		if serviceJob.ActiveJobId == job.Id {
+			serviceJob.ActiveJobId = ""
+		}
+	case !job.Status.IsTerminal():
+		serviceJob.ActiveJobId = job.Id
+	case serviceJob.ActiveJobId == job.Id:
+		serviceJob.ActiveJobId = ""
+	}
+
+	trackRelease(serviceJob, job)
+
+	return latest != serviceJob.LatestJobId || active != serviceJob.ActiveJobId ||
+		!slices.Equal(queued, serviceJob.QueuedJobIds) || !slices.Equal(releases, serviceJob.Releases)
+}
+
+// trackRelease keep the latest good job of each release; a job that is no longer good (eg. rolled back) is dropped
+func trackRelease(serviceJob *entity.ServiceJob, job *entity.DeploymentJob) {
+	serviceJob.Releases = slices.DeleteFunc(serviceJob.Releases, func(release entity.ServiceRelease) bool {
+		return release.JobId == job.Id
+	})
+
+	if job.Status != entity.DeploymentJobStatusDeployed && job.Status != entity.DeploymentJobStatusSuccess {
+		return
+	}
+
+	buildVersion, envVersion := job.Request.BuildVersion, job.Request.EnvVersion
+	for _, release := range serviceJob.Releases {
+		if release.BuildVersion == buildVersion && release.EnvVersion == envVersion && idSequence(release.JobId) > jobSequence(job) {
+			return
+		}
+	}
+
+	serviceJob.Releases = slices.DeleteFunc(serviceJob.Releases, func(release entity.ServiceRelease) bool {
+		return release.BuildVersion == buildVersion && release.EnvVersion == envVersion
+	})
+	serviceJob.Releases = append(serviceJob.Releases, entity.ServiceRelease{
+		JobId:        job.Id,
+		BuildVersion: buildVersion,
+		EnvVersion:   envVersion,
+	})
+	sort.Slice(serviceJob.Releases, func(i, j int) bool {
+		return idSequence(serviceJob.Releases[i].JobId) > idSequence(serviceJob.Releases[j].JobId)
+	})
+	if len(serviceJob.Releases) > maxServiceRelease {
+		serviceJob.Releases = serviceJob.Releases[:maxServiceRelease]
+	}
+}
+
+// getJob returns the job by ID; not limited to the latest jobs
+func (m *raftApp) getJob(ctx context.Context, ns, service, id string) (*entity.DeploymentJob, error) {
+	jobs, err := m.jobUsecase.Get(ctx, ns, []string{service}, id)
+	if err != nil && !errors.Is(err, mycontent.ErrNotFound) {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, errNotFound("job %v of service %v not found", id, service)
+	}
+	return jobs[0], nil
+}
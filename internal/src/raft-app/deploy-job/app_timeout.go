@@ -0,0 +1,106 @@
+package deployjob
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// timeoutJob moves a stuck job to TIMEOUT.
+// Raft does not have clock, so the leader is the one who decide the time (request.CreatedAt);
+// here we only validate it against the deadline we recorded.
+func (m *raftApp) timeoutJob(ctx context.Context, request JobTimeoutRequest) (raft.OnAfterApply, error) {
+	jobs, err := m.jobUsecase.Get(ctx, request.Ns, []string{request.Service}, request.JobId)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, errNotFound("job nengendi???? not found")
+	}
+
+	job := jobs[0]
+	previousStatus := job.Status
+
+	if job.Status.IsTerminal() {
+		return nil, errInvalidState("job is already finished with status %v", job.Status)
+	}
+
+	if job.Deadline == nil {
+		return nil, errInvalidState("job is waiting for user in %v state; no deadline", job.Status)
+	}
+
+	if request.CreatedAt.Before(*job.Deadline) {
+		// probably stale proposal from previous phase
+		return nil, errInvalidState("job deadline not yet passed (deadline: %v, requested at: %v)", job.Deadline, request.CreatedAt)
+	}
+
+	if job.Status == entity.DeploymentJobStatusDeployed {
+		// already deployed; only the clean up is late
+		return m.timeoutCleanup(ctx, job, request)
+	}
+
+	job.Status = entity.DeploymentJobStatusTimeOut
+	job.Deadline = nil
+
+	// mark every host that still work on it
+	for host, info := range job.Configuration.Status {
+		switch info.Status {
+		case entity.HostConfigurationStatusPending, entity.HostConfigurationStatusConfiguring:
+			info.Status = entity.HostConfigurationStatusTimeOut
+			job.Configuration.Status[host] = info
+		}
+	}
+
+	for host, info := range job.Deployment.Status {
+		switch info.Status {
+		case entity.HostDeploymentStatusPending, entity.HostDeploymentStatusSuccess,
+			entity.HostDeploymentStatusFailed, entity.HostDeploymentStatusTimeOut:
+			// not started or already finished
+		default:
+			info.Status = entity.HostDeploymentStatusTimeOut
+			job.Deployment.Status[host] = info
+		}
+	}
+
+	for host, info := range job.Decommission.Status {
+		if info.Status == entity.HostDecommissionStatusPending || info.Status == entity.HostDecommissionStatusUninstalling {
+			info.Status = entity.HostDecommissionStatusTimeOut
+			job.Decommission.Status[host] = info
+		}
+	}
+
+	job, err = m.postJob(ctx, job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, job, auditRecord{previous: previousStatus, actor: request.Agent, message: "deadline passed", at: request.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.syncInstances(ctx, job, job.Deployment.HostOrder...)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := m.startNextJob(ctx, job.Ns, request.Service, request.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	encResult, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (raft.Result, error) {
+		// let the host stop whatever they're doing
+		m.topic.Broadcast(context.Background(), EventDeploymentJobTimedOut{Job: *job})
+		m.broadcastNextJob(next)
+		return raft.Result{Data: encResult}, nil
+	}, nil
+}
@@ -2,6 +2,7 @@ package deployjob
 
 import (
 	"context"
+	"fmt"
 
 	raft_runner "github.com/desain-gratis/common/lib/raft/runner"
 	"github.com/desain-gratis/deployd/src/entity"
@@ -21,6 +22,7 @@ type SubmitJobStatus string
 const (
 	SubmitJobStatusNeedRetry SubmitJobStatus = "NEED_RETRY"
 	SubmitJobStatusSuccess   SubmitJobStatus = "SUCCESS"
+	SubmitJobStatusQueued    SubmitJobStatus = "QUEUED" // another job of the service is still active
 )
 
 type CancelJobResponse entity.DeploymentJob
@@ -38,8 +40,7 @@ func NewClient(raftClient *raft_runner.Client) *Client {
 func (c *Client) SubmitJob(ctx context.Context, request entity.SubmitDeploymentJobRequest) (SubmitJobResponse, error) {
 	raftResult, value, err := c.Publish(ctx, CommandUserSubmitJob, request)
 	if err != nil {
-		_ = value // can parse error based on value
-		return SubmitJobResponse{}, err
+		return SubmitJobResponse{}, parseError(value, err)
 	}
 
 	result, err := parseAs[SubmitJobResponse](raftResult)
@@ -50,11 +51,10 @@ func (c *Client) SubmitJob(ctx context.Context, request entity.SubmitDeploymentJ
 	return result, nil
 }
 
-func (c *Client) CancelJob(ctx context.Context, request entity.CancelJobRequest) (CancelJobResponse, error) {
+func (c *Client) CancelJob(ctx context.Context, request CancelJobRequest) (CancelJobResponse, error) {
 	raftResult, value, err := c.Publish(ctx, CommandUserCancelJob, request)
 	if err != nil {
-		_ = value // can parse error based on value
-		return CancelJobResponse{}, err
+		return CancelJobResponse{}, parseError(value, err)
 	}
 
 	result, err := parseAs[CancelJobResponse](raftResult)
@@ -65,11 +65,24 @@ func (c *Client) CancelJob(ctx context.Context, request entity.CancelJobRequest)
 	return result, nil
 }
 
+func (c *Client) RollbackJob(ctx context.Context, request RollbackJobRequest) (SubmitJobResponse, error) {
+	raftResult, value, err := c.Publish(ctx, CommandUserRollbackJob, request)
+	if err != nil {
+		return SubmitJobResponse{}, parseError(value, err)
+	}
+
+	result, err := parseAs[SubmitJobResponse](raftResult)
+	if err != nil {
+		return SubmitJobResponse{}, err
+	}
+
+	return result, nil
+}
+
 func (c *Client) FeedHostConfigurationUpdate(ctx context.Context, request ConfigurationUpdateRequest) (ConfigurationUpdateResponse, error) {
 	raftResult, value, err := c.Publish(ctx, CommandHostConfigurationUpdate, request)
 	if err != nil {
-		_ = value
-		return ConfigurationUpdateResponse{}, err
+		return ConfigurationUpdateResponse{}, parseError(value, err)
 	}
 
 	result, err := parseAs[ConfigurationUpdateResponse](raftResult)
@@ -80,11 +93,24 @@ func (c *Client) FeedHostConfigurationUpdate(ctx context.Context, request Config
 	return result, nil
 }
 
+func (c *Client) FeedHostCanaryUpdate(ctx context.Context, request CanaryUpdateRequest) (HostRestartServiceUpdateResponse, error) {
+	raftResult, value, err := c.Publish(ctx, CommandHostCanaryUpdate, request)
+	if err != nil {
+		return HostRestartServiceUpdateResponse{}, parseError(value, err)
+	}
+
+	result, err := parseAs[HostRestartServiceUpdateResponse](raftResult)
+	if err != nil {
+		return HostRestartServiceUpdateResponse{}, err
+	}
+
+	return result, nil
+}
+
 func (c *Client) ConfirmRestartService(ctx context.Context, request RestartConfirmation) (HostRestartConfirmationResponse, error) {
 	raftResult, value, err := c.Publish(ctx, CommandRestartConfirmation, request)
 	if err != nil {
-		_ = value
-		return HostRestartConfirmationResponse{}, err
+		return HostRestartConfirmationResponse{}, parseError(value, err)
 	}
 
 	result, err := parseAs[HostRestartConfirmationResponse](raftResult)
@@ -98,10 +124,65 @@ func (c *Client) ConfirmRestartService(ctx context.Context, request RestartConfi
 func (c *Client) FeedHostRestartServiceUpdate(ctx context.Context, request HostRestartServiceUpdateRequest) (HostRestartServiceUpdateResponse, error) {
 	raftResult, value, err := c.Publish(ctx, CommandHostRestartServiceUpdate, request)
 	if err != nil {
-		_ = value
+		return HostRestartServiceUpdateResponse{}, parseError(value, err)
+	}
+
+	result, err := parseAs[HostRestartServiceUpdateResponse](raftResult)
+	if err != nil {
 		return HostRestartServiceUpdateResponse{}, err
 	}
 
+	return result, nil
+}
+
+func (c *Client) TimeoutJob(ctx context.Context, request JobTimeoutRequest) (entity.DeploymentJob, error) {
+	raftResult, value, err := c.Publish(ctx, CommandLeaderJobTimeout, request)
+	if err != nil {
+		return entity.DeploymentJob{}, parseError(value, err)
+	}
+
+	result, err := parseAs[entity.DeploymentJob](raftResult)
+	if err != nil {
+		return entity.DeploymentJob{}, err
+	}
+
+	return result, nil
+}
+
+func (c *Client) RetryHost(ctx context.Context, request RetryHostRequest) (EventHostRetry, error) {
+	raftResult, value, err := c.Publish(ctx, CommandUserRetryHost, request)
+	if err != nil {
+		return EventHostRetry{}, parseError(value, err)
+	}
+
+	result, err := parseAs[EventHostRetry](raftResult)
+	if err != nil {
+		return EventHostRetry{}, err
+	}
+
+	return result, nil
+}
+
+func (c *Client) RemoveService(ctx context.Context, request RemoveServiceRequest) (EventServiceRemoved, error) {
+	raftResult, value, err := c.Publish(ctx, CommandUserRemoveService, request)
+	if err != nil {
+		return EventServiceRemoved{}, parseError(value, err)
+	}
+
+	result, err := parseAs[EventServiceRemoved](raftResult)
+	if err != nil {
+		return EventServiceRemoved{}, err
+	}
+
+	return result, nil
+}
+
+func (c *Client) FeedHostDecommissionUpdate(ctx context.Context, request DecommissionUpdateRequest) (HostRestartServiceUpdateResponse, error) {
+	raftResult, value, err := c.Publish(ctx, CommandHostDecommissionUpdate, request)
+	if err != nil {
+		return HostRestartServiceUpdateResponse{}, parseError(value, err)
+	}
+
 	result, err := parseAs[HostRestartServiceUpdateResponse](raftResult)
 	if err != nil {
 		return HostRestartServiceUpdateResponse{}, err
@@ -109,3 +190,91 @@ func (c *Client) FeedHostRestartServiceUpdate(ctx context.Context, request HostR
 
 	return result, nil
 }
+
+func (c *Client) FeedHostCleanupUpdate(ctx context.Context, request CleanupUpdateRequest) (entity.DeploymentJob, error) {
+	raftResult, value, err := c.Publish(ctx, CommandHostCleanupUpdate, request)
+	if err != nil {
+		return entity.DeploymentJob{}, parseError(value, err)
+	}
+
+	result, err := parseAs[entity.DeploymentJob](raftResult)
+	if err != nil {
+		return entity.DeploymentJob{}, err
+	}
+
+	return result, nil
+}
+
+func (c *Client) FeedHostCancelUpdate(ctx context.Context, request HostCancelUpdateRequest) (entity.DeploymentJob, error) {
+	raftResult, value, err := c.Publish(ctx, CommandHostCancelUpdate, request)
+	if err != nil {
+		return entity.DeploymentJob{}, parseError(value, err)
+	}
+
+	result, err := parseAs[entity.DeploymentJob](raftResult)
+	if err != nil {
+		return entity.DeploymentJob{}, err
+	}
+
+	return result, nil
+}
+
+func (c *Client) Freeze(ctx context.Context, request FreezeRequest) (entity.Freeze, error) {
+	raftResult, value, err := c.Publish(ctx, CommandUserFreeze, request)
+	if err != nil {
+		return entity.Freeze{}, parseError(value, err)
+	}
+
+	result, err := parseAs[entity.Freeze](raftResult)
+	if err != nil {
+		return entity.Freeze{}, err
+	}
+
+	return result, nil
+}
+
+func (c *Client) Unfreeze(ctx context.Context, request UnfreezeRequest) (entity.Freeze, error) {
+	raftResult, value, err := c.Publish(ctx, CommandUserUnfreeze, request)
+	if err != nil {
+		return entity.Freeze{}, parseError(value, err)
+	}
+
+	result, err := parseAs[entity.Freeze](raftResult)
+	if err != nil {
+		return entity.Freeze{}, err
+	}
+
+	return result, nil
+}
+
+// Plan returns what the job would do if submitted now, without proposing to raft
+func (c *Client) Plan(ctx context.Context, request entity.SubmitDeploymentJobRequest) (entity.DeploymentPlan, error) {
+	res, err := c.Query(ctx, PlanQuery{Request: request})
+	if err != nil {
+		return entity.DeploymentPlan{}, parseError(0, err)
+	}
+
+	result, ok := res.(PlanResponse)
+	if !ok {
+		return entity.DeploymentPlan{}, &Error{Code: ErrorCodeUnknown, Message: fmt.Sprintf("unexpected plan result: %T", res)}
+	}
+	if result.Err != nil {
+		return entity.DeploymentPlan{}, result.Err
+	}
+
+	return result.Plan, nil
+}
+
+func (c *Client) FeedHostDriftUpdate(ctx context.Context, request DriftUpdateRequest) (entity.ServiceInstanceHost, error) {
+	raftResult, value, err := c.Publish(ctx, CommandHostDriftUpdate, request)
+	if err != nil {
+		return entity.ServiceInstanceHost{}, parseError(value, err)
+	}
+
+	result, err := parseAs[entity.ServiceInstanceHost](raftResult)
+	if err != nil {
+		return entity.ServiceInstanceHost{}, err
+	}
+
+	return result, nil
+}
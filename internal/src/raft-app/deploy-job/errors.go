@@ -0,0 +1,153 @@
+package deployjob
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/desain-gratis/common/lib/raft"
+	raft_runner "github.com/desain-gratis/common/lib/raft/runner"
+)
+
+// ErrorCode is carried in raft.Result.Value, so the client knows what kind of error happened.
+// 0 is success; 1 is used by the raft runner for any error it does not know.
+type ErrorCode uint64
+
+const (
+	ErrorCodeUnknown      ErrorCode = 1
+	ErrorCodeNotFound     ErrorCode = 2
+	ErrorCodeInvalidState ErrorCode = 3
+	ErrorCodeConflict     ErrorCode = 4
+	ErrorCodeValidation   ErrorCode = 5
+	ErrorCodeNotLeader    ErrorCode = 6
+	ErrorCodeFrozen       ErrorCode = 7
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case 0:
+		return "OK"
+	case ErrorCodeNotFound:
+		return "NOT_FOUND"
+	case ErrorCodeInvalidState:
+		return "INVALID_STATE"
+	case ErrorCodeConflict:
+		return "CONFLICT"
+	case ErrorCodeValidation:
+		return "VALIDATION"
+	case ErrorCodeNotLeader:
+		return "NOT_LEADER"
+	case ErrorCodeFrozen:
+		return "FROZEN"
+	}
+	return "UNKNOWN"
+}
+
+func (c ErrorCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *ErrorCode) UnmarshalText(text []byte) error {
+	for _, code := range []ErrorCode{ErrorCodeNotFound, ErrorCodeInvalidState, ErrorCodeConflict, ErrorCodeValidation, ErrorCodeNotLeader, ErrorCodeFrozen} {
+		if code.String() == string(text) {
+			*c = code
+			return nil
+		}
+	}
+	*c = ErrorCodeUnknown
+	return nil
+}
+
+// Error is the typed error of the deploy job raft app.
+// Use errors.Is(err, ErrNotFound) etc. to check the kind.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code
+}
+
+var (
+	ErrUnknown      = &Error{Code: ErrorCodeUnknown, Message: "unknown error"}
+	ErrNotFound     = &Error{Code: ErrorCodeNotFound, Message: "not found"}
+	ErrInvalidState = &Error{Code: ErrorCodeInvalidState, Message: "invalid state"}
+	ErrConflict     = &Error{Code: ErrorCodeConflict, Message: "conflict"}
+	ErrValidation   = &Error{Code: ErrorCodeValidation, Message: "validation error"}
+	ErrNotLeader    = &Error{Code: ErrorCodeNotLeader, Message: "not leader"}
+	ErrFrozen       = &Error{Code: ErrorCodeFrozen, Message: "namespace is frozen"}
+)
+
+func newError(code ErrorCode, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func errNotFound(format string, args ...any) error {
+	return newError(ErrorCodeNotFound, format, args...)
+}
+
+func errInvalidState(format string, args ...any) error {
+	return newError(ErrorCodeInvalidState, format, args...)
+}
+
+func errConflict(format string, args ...any) error {
+	return newError(ErrorCodeConflict, format, args...)
+}
+
+func errValidation(format string, args ...any) error {
+	return newError(ErrorCodeValidation, format, args...)
+}
+
+func errFrozen(format string, args ...any) error {
+	return newError(ErrorCodeFrozen, format, args...)
+}
+
+// errorResult convert the error of a command to raft.Result, so the code survive the raft runner.
+// (the runner only keep the message, with code 1)
+func errorResult(err error) raft.OnAfterApply {
+	var appErr *Error
+	if !errors.As(err, &appErr) {
+		appErr = &Error{Code: ErrorCodeUnknown, Message: err.Error()}
+	}
+
+	encResult, _ := json.Marshal(appErr)
+
+	return func() (raft.Result, error) {
+		return raft.Result{Value: uint64(appErr.Code), Data: encResult}, nil
+	}
+}
+
+// parseError convert the error returned by Publish back into *Error
+func parseError(value uint64, err error) error {
+	if errors.Is(err, raft_runner.ErrNotReady) {
+		return &Error{Code: ErrorCodeNotLeader, Message: err.Error()}
+	}
+
+	if value == 0 {
+		// not from the raft app
+		return &Error{Code: ErrorCodeUnknown, Message: err.Error()}
+	}
+
+	// the runner format it as: raft app: '<data>'
+	msg := err.Error()
+	if start, end := strings.Index(msg, "'"), strings.LastIndex(msg, "'"); start >= 0 && end > start {
+		var appErr Error
+		if json.Unmarshal([]byte(msg[start+1:end]), &appErr) == nil && appErr.Message != "" {
+			appErr.Code = ErrorCode(value)
+			return &appErr
+		}
+		msg = msg[start+1 : end]
+	}
+
+	return &Error{Code: ErrorCode(value), Message: msg}
+}
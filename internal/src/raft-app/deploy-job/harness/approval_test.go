@@ -0,0 +1,50 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestApproval(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "two approvers before restart", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(configureJob("0", withApprovals(submit(0, false), 2)), []Step{
+				{
+					Name: "first approval", Command: deployjob.CommandRestartConfirmation, Request: approve("0", "alice", 3),
+					ExpectJobStatus: entity.DeploymentJobStatusConfigured,
+					ExpectEvents:    []string{},
+				},
+				{
+					Name: "second approval", Command: deployjob.CommandRestartConfirmation, Request: approve("0", "bob", 5),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventRestartConfirmed"},
+				},
+			}),
+		},
+	})
+}
+
+func TestApprovalRejected(t *testing.T) {
+	runScenarios(t, rejections([]rejection{
+		{
+			name: "same approver twice", setup: concat(configureJob("0", withApprovals(submit(0, false), 2)), []Step{
+				{Name: "first approval", Command: deployjob.CommandRestartConfirmation, Request: approve("0", "alice", 3)},
+			}),
+			command: deployjob.CommandRestartConfirmation, request: approve("0", "alice", 4),
+			code: deployjob.ErrorCodeConflict, status: entity.DeploymentJobStatusConfigured,
+		},
+		{
+			name: "approval without approver", setup: configureJob("0", withApprovals(submit(0, false), 2)),
+			command: deployjob.CommandRestartConfirmation, request: approve("0", "", 3),
+			code: deployjob.ErrorCodeValidation, status: entity.DeploymentJobStatusConfigured,
+		},
+		{
+			name: "approval by the submitter", setup: configureJob("0", withApprovals(submit(0, false), 2)),
+			command: deployjob.CommandRestartConfirmation, request: approve("0", "harness", 3),
+			code: deployjob.ErrorCodeValidation, status: entity.DeploymentJobStatusConfigured,
+		},
+	}))
+}
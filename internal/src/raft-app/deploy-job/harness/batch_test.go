@@ -0,0 +1,48 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestBatch(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "restart in batches", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(configureJob("0", withStrategy(withHost3(submit(0, false)), &entity.RolloutStrategy{BatchSize: 2})), []Step{
+				{
+					Name: "confirm first batch", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 3),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectJob:       expectBatch("host-1", "host-2"),
+				},
+				{
+					Name: "host-3 restarted too early", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-3", entity.HostDeploymentStatusSuccess, 4),
+					ExpectError: deployjob.ErrorCodeInvalidState,
+				},
+				{
+					Name: "host-1 restarted", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-1", entity.HostDeploymentStatusSuccess, 5),
+					ExpectEvents: []string{"HostRestartServiceUpdateResponse"},
+					ExpectJob:    expectBatch("host-1", "host-2"),
+				},
+				{
+					Name: "confirm while batch in progress", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 6),
+					ExpectError: deployjob.ErrorCodeInvalidState,
+				},
+				{
+					Name: "host-2 restarted", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-2", entity.HostDeploymentStatusSuccess, 7),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventServiceRestarted"},
+					ExpectJob:       expectBatch("host-3"),
+				},
+				{Name: "confirm last batch", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 8)},
+				{
+					Name: "host-3 restarted", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-3", entity.HostDeploymentStatusSuccess, 9),
+					ExpectJobStatus: entity.DeploymentJobStatusDeployed,
+					ExpectEvents:    []string{"EventServiceRestarted", "EventAllServiceRestarted", "EventCleanupStarted"},
+				},
+			}),
+		},
+	})
+}
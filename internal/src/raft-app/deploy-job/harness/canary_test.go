@@ -0,0 +1,74 @@
+package harness
+
+import (
+	"fmt"
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestCanary(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "canary promoted after bake", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(configureJob("0", withStrategy(withHost3(submit(0, false)), canaryStrategy())), []Step{
+				{
+					Name: "confirm canary", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 3),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectJob:       expectBatch("host-1"),
+				},
+				{
+					Name: "canary restarted", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-1", entity.HostDeploymentStatusSuccess, 4),
+					ExpectJobStatus: entity.DeploymentJobStatusCanary,
+					ExpectEvents:    []string{"EventCanaryStarted"},
+				},
+				{
+					Name: "promote while baking", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 10),
+					ExpectError:     deployjob.ErrorCodeInvalidState,
+					ExpectJobStatus: entity.DeploymentJobStatusCanary,
+				},
+				{
+					Name: "promote after bake", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 15),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventRestartConfirmed"},
+					ExpectJob: func(job entity.DeploymentJob) []string {
+						if job.Deployment.PromotedBy != "harness" {
+							return []string{fmt.Sprintf("expected promoted by harness, got %q", job.Deployment.PromotedBy)}
+						}
+						return expectBatch("host-2")(job)
+					},
+				},
+				{
+					Name: "canary host fails after promotion", Command: deployjob.CommandHostCanaryUpdate, Request: canaryFailed("0", "host-1", 16),
+					ExpectError: deployjob.ErrorCodeInvalidState,
+				},
+			}),
+		},
+		{
+			Name: "canary failed while baking", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(configureJob("0", withStrategy(withHost3(submit(0, false)), canaryStrategy())), []Step{
+				{Name: "confirm canary", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 3)},
+				{
+					Name: "canary restarted", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-1", entity.HostDeploymentStatusSuccess, 4),
+					ExpectJobStatus: entity.DeploymentJobStatusCanary,
+				},
+				{
+					Name: "non-canary host report", Command: deployjob.CommandHostCanaryUpdate, Request: canaryFailed("0", "host-2", 5),
+					ExpectError: deployjob.ErrorCodeValidation,
+				},
+				{
+					Name: "canary unhealthy", Command: deployjob.CommandHostCanaryUpdate, Request: canaryFailed("0", "host-1", 6),
+					ExpectJobStatus: entity.DeploymentJobStatusFailed,
+					ExpectEvents:    []string{"EventDeploymentFailed"},
+					ExpectJob:       expectBatch("host-1"),
+				},
+				{
+					Name: "retry canary", Command: deployjob.CommandUserRetryHost, Request: retry("0", "host-1", 7),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventHostRetry"},
+				},
+			}),
+		},
+	})
+}
@@ -0,0 +1,91 @@
+package harness
+
+import (
+	"fmt"
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestCancel(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "cancel while configuring", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: []Step{
+				{
+					Name: "submit", Command: deployjob.CommandUserSubmitJob, Request: submit(0, false),
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+				},
+				{
+					Name: "cancel", Command: deployjob.CommandUserCancelJob, Request: cancel("0", 1),
+					ExpectJobStatus: entity.DeploymentJobStatusCancelled,
+					ExpectEvents:    []string{"EventDeploymentJobCancelled"},
+				},
+				{
+					Name: "late configuration", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-1", 2),
+					ExpectError:     deployjob.ErrorCodeInvalidState,
+					ExpectJobStatus: entity.DeploymentJobStatusCancelled,
+				},
+				{
+					Name: "host-1 acknowledge", Command: deployjob.CommandHostCancelUpdate,
+					Request: deployjob.HostCancelUpdateRequest{
+						Ns: scenarioNs, JobId: "0", Service: scenarioService, HostName: "host-1",
+						ConfigurationStatus: entity.HostConfigurationStatusCancelled,
+						DeploymentStatus:    entity.HostDeploymentStatusCancelled,
+						UpdatedAt:           at(3),
+					},
+					ExpectJobStatus: entity.DeploymentJobStatusCancelled,
+					ExpectEvents:    []string{},
+				},
+			},
+		},
+		{
+			Name: "cancel with rollback", Ns: scenarioNs, Service: scenarioService, JobId: "1",
+			Steps: concat(configureAll(), deployAll("0", 3), configureJob("1", withBuildVersion2(submit(4, false))), []Step{
+				{Name: "confirm host-1", Command: deployjob.CommandRestartConfirmation, Request: confirm("1", 5)},
+				{
+					Name: "host-1 restarted", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("1", "host-1", entity.HostDeploymentStatusSuccess, 6),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+				},
+				{
+					Name: "cancel with rollback", Command: deployjob.CommandUserCancelJob, Request: cancelWithRollback("1", 7),
+					ExpectJobStatus: entity.DeploymentJobStatusCancelled,
+					ExpectEvents:    []string{"EventDeploymentJobCancelled"},
+					ExpectJob: func(job entity.DeploymentJob) []string {
+						if job.Cancellation == nil || job.Cancellation.RollbackJobId != "0" || job.Cancellation.RollbackBuildVersion != 1 {
+							return []string{fmt.Sprintf("expected rollback to job 0 build 1, got %+v", job.Cancellation)}
+						}
+						return nil
+					},
+				},
+				{
+					Name: "host-1 rolled back", Command: deployjob.CommandHostCancelUpdate,
+					Request: deployjob.HostCancelUpdateRequest{
+						Ns: scenarioNs, JobId: "1", Service: scenarioService, HostName: "host-1",
+						DeploymentStatus: entity.HostDeploymentStatusRolledBack, UpdatedAt: at(8),
+					},
+					ExpectJobStatus: entity.DeploymentJobStatusCancelled,
+					ExpectJob: func(job entity.DeploymentJob) []string {
+						if status := job.Deployment.Status["host-1"].Status; status != entity.HostDeploymentStatusRolledBack {
+							return []string{fmt.Sprintf("expected host-1 ROLLED_BACK, got %v", status)}
+						}
+						return nil
+					},
+				},
+				{
+					Name: "host-2 acknowledge", Command: deployjob.CommandHostCancelUpdate,
+					Request: deployjob.HostCancelUpdateRequest{
+						Ns: scenarioNs, JobId: "1", Service: scenarioService, HostName: "host-2",
+						DeploymentStatus: entity.HostDeploymentStatusCancelled, UpdatedAt: at(8),
+					},
+				},
+				{
+					Name: "rollback after cancel", Command: deployjob.CommandUserRollbackJob, Request: rollback(9),
+					JobId: "2", ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+					ExpectJob: expectRollbackOf("0", 1),
+				},
+			}),
+		},
+	})
+}
@@ -0,0 +1,129 @@
+package harness
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestChangeHosts(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "change hosts", Ns: scenarioNs, Service: scenarioService, JobId: "1",
+			Steps: concat(configureAll(), deployAll("0", 3), []Step{
+				{
+					Name: "same hosts", Command: deployjob.CommandUserSubmitJob, Request: changeHosts(4, "host-1", "host-2"),
+					ExpectError: deployjob.ErrorCodeValidation,
+				},
+				{
+					Name: "replace host-1 with host-3", Command: deployjob.CommandUserSubmitJob, Request: changeHosts(5, "host-2", "host-3"),
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+					ExpectEvents:    []string{"EventDeploymentJobCreated"},
+					ExpectJob: func(job entity.DeploymentJob) []string {
+						var failures []string
+						if job.Request.BuildVersion != 1 || job.Request.EnvVersion != 1 {
+							failures = append(failures, fmt.Sprintf("expected the running release (build 1, env 1), got build %v env %v", job.Request.BuildVersion, job.Request.EnvVersion))
+						}
+						if !slices.Equal(job.Deployment.HostOrder, []string{"host-3"}) {
+							failures = append(failures, fmt.Sprintf("expected only host-3 deployed, got %v", job.Deployment.HostOrder))
+						}
+						if _, ok := job.Decommission.Status["host-1"]; !ok || len(job.Decommission.Status) != 1 {
+							failures = append(failures, fmt.Sprintf("expected only host-1 decommissioned, got %v", job.Decommission.Status))
+						}
+						return failures
+					},
+				},
+				{
+					Name: "change hosts while busy", Command: deployjob.CommandUserSubmitJob, Request: changeHosts(6, "host-1", "host-3"),
+					ExpectError: deployjob.ErrorCodeConflict,
+				},
+				{
+					Name: "host-3 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("1", "host-3", 7),
+					ExpectJobStatus: entity.DeploymentJobStatusConfigured,
+				},
+				{Name: "confirm host-3", Command: deployjob.CommandRestartConfirmation, Request: confirm("1", 8)},
+				{
+					Name: "host-3 restarted", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("1", "host-3", entity.HostDeploymentStatusSuccess, 9),
+					ExpectJobStatus: entity.DeploymentJobStatusDecommissioning,
+					ExpectEvents:    []string{"EventServiceRestarted", "EventDecommissionStarted"},
+				},
+				{
+					Name: "host-1 uninstalled", Command: deployjob.CommandHostDecommissionUpdate, Request: decommissioned("1", "host-1", 10),
+					ExpectJobStatus: entity.DeploymentJobStatusDeployed,
+					ExpectEvents:    []string{"EventAllServiceRestarted", "EventCleanupStarted"},
+				},
+				{
+					Name: "host-3 cleaned up", Command: deployjob.CommandHostCleanupUpdate, Request: cleaned("1", "host-3", entity.HostCleanupStatusSuccess, 11),
+					ExpectJobStatus: entity.DeploymentJobStatusSuccess,
+				},
+				{
+					Name: "plan after change hosts", Request: deployjob.PlanQuery{Request: submit(12, false)},
+					ExpectPlan: expectPlanHosts("host-2", "host-3"),
+				},
+			}),
+		},
+		{
+			Name: "retry failed decommission", Ns: scenarioNs, Service: scenarioService, JobId: "1",
+			Steps: concat(configureAll(), deployAll("0", 3), []Step{
+				{Name: "replace host-1 with host-3", Command: deployjob.CommandUserSubmitJob, Request: changeHosts(4, "host-2", "host-3")},
+				{Name: "host-3 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("1", "host-3", 5)},
+				{Name: "confirm host-3", Command: deployjob.CommandRestartConfirmation, Request: confirm("1", 6)},
+				{
+					Name: "host-3 restarted", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("1", "host-3", entity.HostDeploymentStatusSuccess, 7),
+					ExpectJobStatus: entity.DeploymentJobStatusDecommissioning,
+				},
+				{
+					Name: "host-1 uninstall failed", Command: deployjob.CommandHostDecommissionUpdate, Request: decommissionFailed("1", "host-1", 8),
+					ExpectJobStatus: entity.DeploymentJobStatusFailed,
+				},
+				{
+					Name: "retry host not decommissioned", Command: deployjob.CommandUserRetryHost, Request: retry("1", "host-3", 9),
+					ExpectError: deployjob.ErrorCodeInvalidState, ExpectJobStatus: entity.DeploymentJobStatusFailed,
+				},
+				{
+					Name: "retry host-1", Command: deployjob.CommandUserRetryHost, Request: retry("1", "host-1", 10),
+					ExpectJobStatus: entity.DeploymentJobStatusDecommissioning,
+					ExpectEvents:    []string{"EventHostRetry"},
+					ExpectJob: func(job entity.DeploymentJob) []string {
+						if info := job.Decommission.Status["host-1"]; info.Status != entity.HostDecommissionStatusPending || info.RetryCount != 1 {
+							return []string{fmt.Sprintf("expected host-1 PENDING (retry 1), got %v (retry %v)", info.Status, info.RetryCount)}
+						}
+						return nil
+					},
+				},
+				{
+					Name: "host-1 uninstalled", Command: deployjob.CommandHostDecommissionUpdate, Request: decommissioned("1", "host-1", 11),
+					ExpectJobStatus: entity.DeploymentJobStatusDeployed,
+				},
+				{
+					Name: "plan after retry", Request: deployjob.PlanQuery{Request: submit(12, true)},
+					ExpectPlan: expectPlanHosts("host-2", "host-3"),
+				},
+			}),
+		},
+	})
+}
+
+func decommissionFailed(jobId, host string, minute int) deployjob.DecommissionUpdateRequest {
+	request := decommissioned(jobId, host, minute)
+	request.Status = entity.HostDecommissionStatusFailed
+	return request
+}
+
+// expectPlanHosts checks the hosts of the plan, in any order
+func expectPlanHosts(expected ...string) func(plan entity.DeploymentPlan) []string {
+	return func(plan entity.DeploymentPlan) []string {
+		hosts := make([]string, 0, len(plan.Hosts))
+		for _, host := range plan.Hosts {
+			hosts = append(hosts, host.Host)
+		}
+		slices.Sort(hosts)
+		if !slices.Equal(hosts, expected) {
+			return []string{fmt.Sprintf("expected hosts %v, got %v", expected, hosts)}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestCleanupRejected(t *testing.T) {
+	runScenarios(t, rejections([]rejection{
+		{
+			name: "cleanup report before deployed", setup: configureAll(),
+			command: deployjob.CommandHostCleanupUpdate, request: cleaned("0", "host-1", entity.HostCleanupStatusSuccess, 3),
+			code: deployjob.ErrorCodeInvalidState, status: entity.DeploymentJobStatusConfigured,
+		},
+		{
+			name: "cleanup report of host not cleaning up", setup: concat(configureAll(), deployAll("0", 3)[:4]),
+			command: deployjob.CommandHostCleanupUpdate, request: cleaned("0", "host-3", entity.HostCleanupStatusSuccess, 4),
+			code: deployjob.ErrorCodeValidation, status: entity.DeploymentJobStatusDeployed,
+		},
+		{
+			name: "cancel while cleaning up", setup: concat(configureAll(), deployAll("0", 3)[:4]),
+			command: deployjob.CommandUserCancelJob, request: cancel("0", 4),
+			code: deployjob.ErrorCodeInvalidState, status: entity.DeploymentJobStatusDeployed,
+		},
+	}))
+}
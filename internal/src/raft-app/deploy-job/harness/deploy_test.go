@@ -0,0 +1,83 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestDeploy(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "deploy to two hosts", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: append(configureAll(),
+				Step{
+					Name: "confirm host-1", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 3),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventRestartConfirmed"},
+				},
+				Step{
+					Name: "host-1 restarting", Command: deployjob.CommandHostRestartServiceUpdate,
+					Request:         restarted("0", "host-1", entity.HostDeploymentStatusRestarting, 4),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"HostRestartServiceUpdateResponse"},
+				},
+				Step{
+					Name: "host-1 restarted", Command: deployjob.CommandHostRestartServiceUpdate,
+					Request:         restarted("0", "host-1", entity.HostDeploymentStatusSuccess, 5),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventServiceRestarted"},
+				},
+				Step{
+					Name: "host-1 reports again", Command: deployjob.CommandHostRestartServiceUpdate,
+					Request:     restarted("0", "host-1", entity.HostDeploymentStatusSuccess, 6),
+					ExpectError: deployjob.ErrorCodeInvalidState,
+				},
+				Step{
+					Name: "confirm host-2", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 7),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventRestartConfirmed"},
+				},
+				Step{
+					Name: "host-2 restarted", Command: deployjob.CommandHostRestartServiceUpdate,
+					Request:         restarted("0", "host-2", entity.HostDeploymentStatusSuccess, 8),
+					ExpectJobStatus: entity.DeploymentJobStatusDeployed,
+					ExpectEvents:    []string{"EventServiceRestarted", "EventAllServiceRestarted", "EventCleanupStarted"},
+				},
+				Step{
+					Name: "host-1 cleaned up", Command: deployjob.CommandHostCleanupUpdate,
+					Request:         cleaned("0", "host-1", entity.HostCleanupStatusSuccess, 10),
+					ExpectJobStatus: entity.DeploymentJobStatusDeployed,
+					ExpectEvents:    []string{},
+				},
+				Step{
+					Name: "host-2 clean up failed", Command: deployjob.CommandHostCleanupUpdate,
+					Request:         cleaned("0", "host-2", entity.HostCleanupStatusFailed, 11),
+					ExpectJobStatus: entity.DeploymentJobStatusSuccess,
+					ExpectEvents:    []string{"EventCleanupFinished"},
+				},
+			),
+		},
+		{
+			Name: "host restart failure", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: append(configureAll(),
+				Step{
+					Name: "confirm host-1", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 3),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+				},
+				Step{
+					Name: "host-1 failed", Command: deployjob.CommandHostRestartServiceUpdate,
+					Request:         restarted("0", "host-1", entity.HostDeploymentStatusFailed, 4),
+					ExpectJobStatus: entity.DeploymentJobStatusFailed,
+					ExpectEvents:    []string{"EventDeploymentFailed"},
+				},
+				Step{
+					Name: "confirm failed job", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 5),
+					ExpectError:     deployjob.ErrorCodeInvalidState,
+					ExpectJobStatus: entity.DeploymentJobStatusFailed,
+				},
+			),
+		},
+	})
+}
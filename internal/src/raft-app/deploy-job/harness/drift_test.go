@@ -0,0 +1,47 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestDrift(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "host drift", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(configureAll(), restartHost1(), []Step{
+				{
+					Name: "host-1 service stopped", Command: deployjob.CommandHostDriftUpdate,
+					Request:      drift("host-1", false, 6, serviceStopped()),
+					ExpectEvents: []string{"EventHostDriftDetected"},
+				},
+				{
+					Name: "host-1 service started again", Command: deployjob.CommandHostDriftUpdate, Request: drift("host-1", false, 7),
+					ExpectEvents: []string{},
+				},
+				{
+					Name: "host-1 unit repaired", Command: deployjob.CommandHostDriftUpdate,
+					Request:      drift("host-1", true, 9, entity.DriftItem{Kind: entity.DriftKindUnit, Expected: "rendered unit", Actual: "modified"}),
+					ExpectEvents: []string{},
+				},
+			}),
+		},
+	})
+}
+
+func TestDriftRejected(t *testing.T) {
+	runScenarios(t, rejections([]rejection{
+		{
+			name: "drift of host without the service", setup: concat(configureAll(), restartHost1()),
+			command: deployjob.CommandHostDriftUpdate, request: drift("host-3", false, 6, serviceStopped()),
+			code: deployjob.ErrorCodeNotFound, status: entity.DeploymentJobStatusDeploying,
+		},
+		{
+			name: "clear drift that is not reported", setup: concat(configureAll(), restartHost1()),
+			command: deployjob.CommandHostDriftUpdate, request: drift("host-1", false, 6),
+			code: deployjob.ErrorCodeInvalidState, status: entity.DeploymentJobStatusDeploying,
+		},
+	}))
+}
@@ -0,0 +1,415 @@
+package harness
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+const (
+	scenarioNs      = "harness"
+	scenarioService = "user-profile"
+)
+
+// fixed clock, so the replay is deterministic
+var scenarioTime = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func at(minute int) time.Time {
+	return scenarioTime.Add(time.Duration(minute) * time.Minute)
+}
+
+func scenarioHosts() []*entity.Host {
+	return []*entity.Host{
+		{Ns: scenarioNs, Host: "host-1", RaftConfig: entity.DeploydRaftConfig{ReplicaID: 1, WALDir: "/var/wal", NodeHostDir: "/var/nodehost"}},
+		{Ns: scenarioNs, Host: "host-2", RaftConfig: entity.DeploydRaftConfig{ReplicaID: 2, WALDir: "/var/wal", NodeHostDir: "/var/nodehost"}},
+	}
+}
+
+func submit(minute int, queueIfBusy bool) entity.SubmitDeploymentJobRequest {
+	return entity.SubmitDeploymentJobRequest{
+		Ns:           scenarioNs,
+		Service:      entity.ServiceDefinition{Ns: scenarioNs, Id: scenarioService},
+		BuildVersion: 1,
+		EnvVersion:   1,
+		TargetHosts:  scenarioHosts(),
+		QueueIfBusy:  queueIfBusy,
+		Agent:        "harness",
+		PublishedAt:  at(minute),
+	}
+}
+
+func scheduled(minute int, schedule *entity.DeploySchedule) entity.SubmitDeploymentJobRequest {
+	request := submit(minute, false)
+	request.Schedule = schedule
+	return request
+}
+
+func configured(jobId, host string, minute int) deployjob.ConfigurationUpdateRequest {
+	return deployjob.ConfigurationUpdateRequest{
+		Ns: scenarioNs, JobId: jobId, Service: scenarioService,
+		HostName: host, Status: entity.HostConfigurationStatusSuccess, UpdatedAt: at(minute),
+	}
+}
+
+func confirm(jobId string, minute int) deployjob.RestartConfirmation {
+	return deployjob.RestartConfirmation{
+		Ns: scenarioNs, JobId: jobId, Service: scenarioService,
+		Message: "LGTM", Agent: "harness", CreatedAt: at(minute),
+	}
+}
+
+func approve(jobId, agent string, minute int) deployjob.RestartConfirmation {
+	request := confirm(jobId, minute)
+	request.Agent = agent
+	return request
+}
+
+func restarted(jobId, host string, status entity.HostDeploymentStatus, minute int) deployjob.HostRestartServiceUpdateRequest {
+	return deployjob.HostRestartServiceUpdateRequest{
+		Ns: scenarioNs, JobId: jobId, Service: scenarioService,
+		HostName: host, Status: status, UpdatedAt: at(minute),
+	}
+}
+
+func configuring(jobId, host string, minute int, progress *entity.HostProgress) deployjob.ConfigurationUpdateRequest {
+	return deployjob.ConfigurationUpdateRequest{
+		Ns: scenarioNs, JobId: jobId, Service: scenarioService,
+		HostName: host, Status: entity.HostConfigurationStatusConfiguring, Progress: progress, UpdatedAt: at(minute),
+	}
+}
+
+func progress(step string, current uint8, downloaded uint64, minute int) *entity.HostProgress {
+	return &entity.HostProgress{
+		Step: step, CurrentStep: current, TotalSteps: 6,
+		BytesDownloaded: downloaded, BytesTotal: 1000,
+		StartedAt: at(0), UpdatedAt: at(minute),
+	}
+}
+
+// expectProgress checks the last configuration progress of the host
+func expectProgress(host, step string, downloaded uint64) func(job entity.DeploymentJob) []string {
+	return func(job entity.DeploymentJob) []string {
+		progress := job.Configuration.Status[host].Progress
+		if progress == nil {
+			return []string{fmt.Sprintf("expected progress of %v, got none", host)}
+		}
+		if progress.Step != step || progress.BytesDownloaded != downloaded {
+			return []string{fmt.Sprintf("expected progress of %v at %v (%v bytes), got %v (%v bytes)", host, step, downloaded, progress.Step, progress.BytesDownloaded)}
+		}
+		return nil
+	}
+}
+
+func cleaned(jobId, host string, status entity.HostCleanupStatus, minute int) deployjob.CleanupUpdateRequest {
+	return deployjob.CleanupUpdateRequest{
+		Ns: scenarioNs, JobId: jobId, Service: scenarioService,
+		HostName: host, Status: status, UpdatedAt: at(minute),
+	}
+}
+
+func drift(host string, repaired bool, minute int, items ...entity.DriftItem) deployjob.DriftUpdateRequest {
+	return deployjob.DriftUpdateRequest{
+		Ns: scenarioNs, Service: scenarioService, HostName: host,
+		Items: items, Repaired: repaired, CheckedAt: at(minute),
+	}
+}
+
+func cancel(jobId string, minute int) deployjob.CancelJobRequest {
+	return deployjob.CancelJobRequest{
+		Ns: scenarioNs, JobId: jobId, Service: scenarioService,
+		Reason: "harness", Agent: "harness", CreatedAt: at(minute),
+	}
+}
+
+func retry(jobId, host string, minute int) deployjob.RetryHostRequest {
+	return deployjob.RetryHostRequest{
+		Ns: scenarioNs, JobId: jobId, Service: scenarioService,
+		HostName: host, Agent: "harness", CreatedAt: at(minute),
+	}
+}
+
+func timeout(jobId string, minute int) deployjob.JobTimeoutRequest {
+	return deployjob.JobTimeoutRequest{
+		Ns: scenarioNs, JobId: jobId, Service: scenarioService,
+		Agent: "leader", CreatedAt: at(minute),
+	}
+}
+
+func canaryFailed(jobId, host string, minute int) deployjob.CanaryUpdateRequest {
+	return deployjob.CanaryUpdateRequest{
+		Ns: scenarioNs, JobId: jobId, Service: scenarioService,
+		HostName: host, ActiveState: "failed", UpdatedAt: at(minute),
+	}
+}
+
+func decommissioned(jobId, host string, minute int) deployjob.DecommissionUpdateRequest {
+	return deployjob.DecommissionUpdateRequest{
+		Ns: scenarioNs, JobId: jobId, Service: scenarioService,
+		HostName: host, Status: entity.HostDecommissionStatusSuccess, UpdatedAt: at(minute),
+	}
+}
+
+// withHost3 add the third host to the submit request
+func withHost3(request entity.SubmitDeploymentJobRequest) entity.SubmitDeploymentJobRequest {
+	request.TargetHosts = append(request.TargetHosts, &entity.Host{
+		Ns: scenarioNs, Host: "host-3",
+		RaftConfig: entity.DeploydRaftConfig{ReplicaID: 3, WALDir: "/var/wal", NodeHostDir: "/var/nodehost"},
+	})
+	return request
+}
+
+// expectBatch checks the hosts restarted in the current step
+func expectBatch(hosts ...string) func(job entity.DeploymentJob) []string {
+	return func(job entity.DeploymentJob) []string {
+		if batch := job.Deployment.CurrentBatch(); !slices.Equal(batch, hosts) {
+			return []string{fmt.Sprintf("expected current batch %v, got %v", hosts, batch)}
+		}
+		return nil
+	}
+}
+
+// configureJob submit the request as jobId and configure all of its target hosts
+func configureJob(jobId string, request entity.SubmitDeploymentJobRequest) []Step {
+	steps := []Step{{
+		Name: "submit " + jobId, Command: deployjob.CommandUserSubmitJob, Request: request,
+		JobId: jobId, ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+	}}
+	for _, host := range request.TargetHosts {
+		steps = append(steps, Step{
+			Name: host.Host + " configured " + jobId, Command: deployjob.CommandHostConfigurationUpdate,
+			Request: configured(jobId, host.Host, int(request.PublishedAt.Sub(scenarioTime).Minutes())),
+		})
+	}
+	steps[len(steps)-1].JobId = jobId
+	steps[len(steps)-1].ExpectJobStatus = entity.DeploymentJobStatusConfigured
+	return steps
+}
+
+// deployAll restart both hosts one by one and clean up, until the job is SUCCESS
+func deployAll(jobId string, minute int) []Step {
+	return []Step{
+		{Name: "confirm host-1 " + jobId, Command: deployjob.CommandRestartConfirmation, Request: confirm(jobId, minute)},
+		{Name: "host-1 restarted " + jobId, Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted(jobId, "host-1", entity.HostDeploymentStatusSuccess, minute)},
+		{Name: "confirm host-2 " + jobId, Command: deployjob.CommandRestartConfirmation, Request: confirm(jobId, minute)},
+		{Name: "host-2 restarted " + jobId, Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted(jobId, "host-2", entity.HostDeploymentStatusSuccess, minute)},
+		{Name: "host-1 cleaned up " + jobId, Command: deployjob.CommandHostCleanupUpdate, Request: cleaned(jobId, "host-1", entity.HostCleanupStatusSuccess, minute)},
+		{
+			Name: "host-2 cleaned up " + jobId, Command: deployjob.CommandHostCleanupUpdate, Request: cleaned(jobId, "host-2", entity.HostCleanupStatusSuccess, minute),
+			JobId: jobId, ExpectJobStatus: entity.DeploymentJobStatusSuccess,
+		},
+	}
+}
+
+// withService deploy other service on the same hosts
+func withService(request entity.SubmitDeploymentJobRequest, service string, ports ...int) entity.SubmitDeploymentJobRequest {
+	request.Service.Id = service
+	for _, port := range ports {
+		request.Service.BoundAddresses = append(request.Service.BoundAddresses, entity.BoundAddress{Host: "0.0.0.0", Port: port})
+	}
+	return request
+}
+
+// expectPlan checks the submit status & the planned raft port of every host
+func expectPlan(submitStatus deployjob.SubmitJobStatus, raftPort uint16, newPort bool) func(plan entity.DeploymentPlan) []string {
+	return func(plan entity.DeploymentPlan) []string {
+		var failures []string
+		if plan.SubmitStatus != string(submitStatus) {
+			failures = append(failures, fmt.Sprintf("expected submit status %v, got %v", submitStatus, plan.SubmitStatus))
+		}
+		if len(plan.Hosts) != len(scenarioHosts()) {
+			failures = append(failures, fmt.Sprintf("expected %v hosts, got %v", len(scenarioHosts()), len(plan.Hosts)))
+		}
+		for _, port := range plan.Ports {
+			if port.Purpose == entity.HostPortPurposeRaft && (port.Port != raftPort || port.New != newPort) {
+				failures = append(failures, fmt.Sprintf("expected raft port %v (new: %v) in %v, got %v (new: %v)", raftPort, newPort, port.Host, port.Port, port.New))
+			}
+		}
+		return failures
+	}
+}
+
+// configureAll is the common prefix: submit and configure both hosts
+func configureAll() []Step {
+	return []Step{
+		{
+			Name: "submit", Command: deployjob.CommandUserSubmitJob, Request: submit(0, false),
+			JobId: "0", ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+			ExpectEvents: []string{"EventDeploymentJobCreated"},
+		},
+		{
+			Name: "host-1 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-1", 1),
+			JobId: "0", ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+			ExpectEvents: []string{"EventHostConfigured"},
+		},
+		{
+			Name: "host-2 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-2", 2),
+			JobId: "0", ExpectJobStatus: entity.DeploymentJobStatusConfigured,
+			ExpectEvents: []string{"EventHostConfigured", "EventAllHostConfigured"},
+		},
+	}
+}
+
+// queueChurn submit & cancel queued jobs, so the older jobs are out of the latest updated jobs
+func queueChurn(firstJobId, count, minute int) []Step {
+	steps := make([]Step, 0, count*2)
+	for idx := range count {
+		jobId := strconv.Itoa(firstJobId + idx)
+		steps = append(steps,
+			Step{
+				Name: "submit queued " + jobId, Command: deployjob.CommandUserSubmitJob, Request: submit(minute, true),
+				JobId: jobId, ExpectJobStatus: entity.DeploymentJobStatusQueued,
+			},
+			Step{
+				Name: "cancel queued " + jobId, Command: deployjob.CommandUserCancelJob, Request: cancel(jobId, minute),
+				JobId: jobId, ExpectJobStatus: entity.DeploymentJobStatusCancelled,
+				ExpectEvents: []string{},
+			},
+		)
+	}
+	return steps
+}
+
+func freeze(until *time.Time, minute int) Step {
+	return Step{
+		Name: "freeze", Command: deployjob.CommandUserFreeze,
+		Request: deployjob.FreezeRequest{Ns: scenarioNs, Reason: "incident", Until: until, Agent: "harness", CreatedAt: at(minute)},
+	}
+}
+
+func unfreeze(minute int) deployjob.UnfreezeRequest {
+	return deployjob.UnfreezeRequest{Ns: scenarioNs, Agent: "harness", CreatedAt: at(minute)}
+}
+
+func withApprovals(request entity.SubmitDeploymentJobRequest, approvals uint) entity.SubmitDeploymentJobRequest {
+	request.Service.RequiredApprovals = approvals
+	return request
+}
+
+func withOverrideFreeze(request entity.SubmitDeploymentJobRequest) entity.SubmitDeploymentJobRequest {
+	request.OverrideFreeze = true
+	return request
+}
+
+// restartHost1 confirm & restart the first host of job "0"
+func restartHost1() []Step {
+	return []Step{
+		{Name: "confirm host-1", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 3)},
+		{Name: "host-1 restarted", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-1", entity.HostDeploymentStatusSuccess, 4)},
+	}
+}
+
+func serviceStopped() entity.DriftItem {
+	return entity.DriftItem{Kind: entity.DriftKindServiceState, Expected: "active", Actual: "inactive"}
+}
+
+// queued submit count jobs behind job "0"
+func queued(minute, count int) []Step {
+	steps := make([]Step, 0, count)
+	for idx := range count {
+		steps = append(steps, Step{Name: "submit queued " + strconv.Itoa(idx+1), Command: deployjob.CommandUserSubmitJob, Request: submit(minute, true)})
+	}
+	return steps
+}
+
+func rollback(minute int) deployjob.RollbackJobRequest {
+	return deployjob.RollbackJobRequest{Ns: scenarioNs, Service: scenarioService, Agent: "harness", CreatedAt: at(minute)}
+}
+
+func cancelWithRollback(jobId string, minute int) deployjob.CancelJobRequest {
+	request := cancel(jobId, minute)
+	request.Rollback = true
+	return request
+}
+
+// changeHosts submit CHANGE_HOSTS job with the running release
+func changeHosts(minute int, hosts ...string) entity.SubmitDeploymentJobRequest {
+	request := withHost3(submit(minute, false))
+	request.Type = entity.DeploymentJobTypeChangeHosts
+	request.BuildVersion, request.EnvVersion = 0, 0
+	request.TargetHosts = slices.DeleteFunc(request.TargetHosts, func(host *entity.Host) bool {
+		return !slices.Contains(hosts, host.Host)
+	})
+	return request
+}
+
+func withStrategy(request entity.SubmitDeploymentJobRequest, strategy *entity.RolloutStrategy) entity.SubmitDeploymentJobRequest {
+	request.Strategy = strategy
+	return request
+}
+
+// one canary host, baked for 10 minutes
+func canaryStrategy() *entity.RolloutStrategy {
+	return &entity.RolloutStrategy{Canary: &entity.CanaryStrategy{Hosts: 1, BakeSeconds: 600}}
+}
+
+func withBuildVersion2(request entity.SubmitDeploymentJobRequest) entity.SubmitDeploymentJobRequest {
+	request.BuildVersion = 2
+	return request
+}
+
+// expectRollbackOf checks the job re-deploy the release of the known-good job
+func expectRollbackOf(jobId string, buildVersion uint64) func(job entity.DeploymentJob) []string {
+	return func(job entity.DeploymentJob) []string {
+		if job.Request.RollbackOf != jobId || job.Request.BuildVersion != buildVersion {
+			return []string{fmt.Sprintf("expected rollback to job %v build %v, got job %q build %v", jobId, buildVersion, job.Request.RollbackOf, job.Request.BuildVersion)}
+		}
+		return nil
+	}
+}
+
+// expectKeep checks the last step keep the release for rollback on clean up
+func expectKeep(steps []Step, buildVersion, envVersion uint64) []Step {
+	steps[len(steps)-1].ExpectJob = func(job entity.DeploymentJob) []string {
+		if job.Cleanup.KeepBuildVersion != buildVersion || job.Cleanup.KeepEnvVersion != envVersion {
+			return []string{fmt.Sprintf("expected build %v env %v kept on clean up, got build %v env %v",
+				buildVersion, envVersion, job.Cleanup.KeepBuildVersion, job.Cleanup.KeepEnvVersion)}
+		}
+		return nil
+	}
+	return steps
+}
+
+func concat(steps ...[]Step) []Step {
+	return slices.Concat(steps...)
+}
+
+// withBuildVersion set the build version of the submitted jobs
+func withBuildVersion(steps []Step, version uint64) []Step {
+	for idx := range steps {
+		if request, ok := steps[idx].Request.(entity.SubmitDeploymentJobRequest); ok {
+			request.BuildVersion = version
+			steps[idx].Request = request
+		}
+	}
+	return steps
+}
+
+// rejection is a command refused after the setup; the job (if any) must stay in the expected status
+type rejection struct {
+	name    string
+	setup   []Step
+	command raft.Command
+	request any
+	code    deployjob.ErrorCode
+	status  entity.DeploymentJobStatus // of job "0"; empty if there is no job
+}
+
+func rejections(rows []rejection) []Scenario {
+	scenarios := make([]Scenario, 0, len(rows))
+	for _, row := range rows {
+		scenarios = append(scenarios, Scenario{
+			Name: "reject " + row.name, Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(row.setup, []Step{{
+				Name: row.name, Command: row.command, Request: row.request,
+				ExpectError: row.code, ExpectJobStatus: row.status, ExpectEvents: []string{},
+			}}),
+		})
+	}
+	return scenarios
+}
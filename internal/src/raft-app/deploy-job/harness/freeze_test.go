@@ -0,0 +1,62 @@
+package harness
+
+import (
+	"testing"
+	"time"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestFreeze(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			// the override apply to the whole job
+			Name: "emergency fix while frozen", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat([]Step{freeze(nil, 0)}, configureJob("0", withOverrideFreeze(submit(1, false))), []Step{
+				{
+					Name: "confirm while frozen", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 3),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventRestartConfirmed"},
+				},
+				{Name: "unfreeze", Command: deployjob.CommandUserUnfreeze, Request: unfreeze(4)},
+			}),
+		},
+		{
+			Name: "restart held until freeze is over", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(configureAll(), []Step{
+				freeze(func() *time.Time { t := at(63); return &t }(), 3),
+				{
+					Name: "confirm while frozen", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 4),
+					ExpectJobStatus: entity.DeploymentJobStatusConfigured,
+					ExpectEvents:    []string{},
+				},
+				{
+					Name: "leader confirm when freeze is over", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 63),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventRestartConfirmed"},
+				},
+			}),
+		},
+	})
+}
+
+func TestFreezeRejected(t *testing.T) {
+	runScenarios(t, rejections([]rejection{
+		{
+			name: "submit while frozen", setup: []Step{freeze(nil, 0)},
+			command: deployjob.CommandUserSubmitJob, request: submit(1, false),
+			code: deployjob.ErrorCodeFrozen,
+		},
+		{
+			name: "confirm while frozen indefinitely", setup: concat(configureAll(), []Step{freeze(nil, 3)}),
+			command: deployjob.CommandRestartConfirmation, request: confirm("0", 4),
+			code: deployjob.ErrorCodeFrozen, status: entity.DeploymentJobStatusConfigured,
+		},
+		{
+			name:    "unfreeze when not frozen",
+			command: deployjob.CommandUserUnfreeze, request: unfreeze(0),
+			code: deployjob.ErrorCodeNotFound,
+		},
+	}))
+}
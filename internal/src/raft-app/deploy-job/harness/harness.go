@@ -0,0 +1,167 @@
+// Package harness drives the deploy-job raft app without raft & ClickHouse:
+// commands are applied one by one to raftApp.OnUpdate on top of an in-memory store,
+// and the broadcasted events are captured, so the state transitions can be checked and replayed deterministically.
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	mycontent_base "github.com/desain-gratis/common/delivery/mycontent-api/mycontent/base"
+	"github.com/desain-gratis/common/lib/raft"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+type Harness struct {
+	app   raft.Application
+	store *memoryStore
+	topic *recordingTopic
+
+	jobUsecase *mycontent_base.Handler[*entity.DeploymentJob]
+
+	index uint64
+}
+
+// Result of a single applied command
+type Result struct {
+	Index  uint64
+	Value  uint64
+	Data   []byte
+	Err    *deployjob.Error // if Value > 0
+	Events []any            // broadcasted after apply, in order
+}
+
+func New(config deployjob.Config) *Harness {
+	store := newMemoryStore(deployjob.TableConfigs()...)
+	topic := &recordingTopic{}
+
+	jobStorage, _ := store.GetStorage(deployjob.TableDeploymentJob)
+
+	return &Harness{
+		app:        deployjob.NewWithStore(topic, config, store),
+		store:      store,
+		topic:      topic,
+		jobUsecase: mycontent_base.New[*entity.DeploymentJob](jobStorage, 1),
+	}
+}
+
+// Apply the command as if it's committed by raft. Same as the raft runner, the error of the command is part of the result.
+// Error is returned only if the harness itself fail.
+func (h *Harness) Apply(command raft.Command, request any) (Result, error) {
+	value, err := json.Marshal(request)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	h.index++
+
+	ctx, cleanup, err := h.app.PrepareUpdate(context.Background())
+	if err != nil {
+		return Result{}, err
+	}
+	defer cleanup()
+
+	afterApply, err := h.app.OnUpdate(ctx, raft.Entry{
+		Index:   h.index,
+		Command: command,
+		Value:   value,
+	})
+	if err != nil {
+		// the runner return the message with code 1
+		return Result{
+			Index: h.index,
+			Value: uint64(deployjob.ErrorCodeUnknown),
+			Data:  []byte(err.Error()),
+			Err:   &deployjob.Error{Code: deployjob.ErrorCodeUnknown, Message: err.Error()},
+		}, nil
+	}
+
+	err = h.app.Apply(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+
+	raftResult, err := afterApply()
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{
+		Index:  h.index,
+		Value:  raftResult.Value,
+		Data:   raftResult.Data,
+		Events: h.topic.drain(),
+	}
+
+	if result.Value > 0 {
+		result.Err = &deployjob.Error{}
+		if err := json.Unmarshal(result.Data, result.Err); err != nil {
+			result.Err.Message = string(result.Data)
+		}
+		result.Err.Code = deployjob.ErrorCode(result.Value)
+	}
+
+	return result, nil
+}
+
+// Plan query the plan of the request (raft Lookup); the store must stay the same.
+// Same as Apply, the error of the plan is part of the result.
+func (h *Harness) Plan(request entity.SubmitDeploymentJobRequest) (entity.DeploymentPlan, Result, error) {
+	before := h.store.dump()
+
+	res, err := h.app.Lookup(context.Background(), deployjob.PlanQuery{Request: request})
+	if err != nil {
+		return entity.DeploymentPlan{}, Result{}, err
+	}
+
+	response, ok := res.(deployjob.PlanResponse)
+	if !ok {
+		return entity.DeploymentPlan{}, Result{}, fmt.Errorf("unexpected plan result: %T", res)
+	}
+
+	if !reflect.DeepEqual(before, h.store.dump()) {
+		return entity.DeploymentPlan{}, Result{}, errors.New("plan modified the state")
+	}
+
+	result := Result{Index: h.index, Err: response.Err}
+	if response.Err != nil {
+		result.Value = uint64(response.Err.Code)
+		result.Data, _ = json.Marshal(response.Err)
+	} else {
+		result.Data, _ = json.Marshal(response.Plan)
+	}
+
+	return response.Plan, result, nil
+}
+
+// Job returns the current state of the job
+func (h *Harness) Job(ns, service, id string) (*entity.DeploymentJob, error) {
+	jobs, err := h.jobUsecase.Get(context.Background(), ns, []string{service}, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, errors.New("job not found")
+	}
+
+	return jobs[0], nil
+}
+
+// Dump all the rows in the store, sorted
+func (h *Harness) Dump() []string {
+	return h.store.dump()
+}
+
+// EventNames returns the type name of the events, eg. "EventDeploymentJobCreated"
+func EventNames(events []any) []string {
+	names := make([]string, 0, len(events))
+	for _, event := range events {
+		names = append(names, reflect.TypeOf(event).Name())
+	}
+	return names
+}
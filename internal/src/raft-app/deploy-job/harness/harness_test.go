@@ -0,0 +1,36 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+)
+
+var scenarioConfig = deployjob.Config{
+	PortRangeStart: 20000,
+	PortRangeEnd:   20100,
+}
+
+// runScenarios run each scenario as a subtest, then replay it to make sure the state is deterministic
+func runScenarios(t *testing.T, scenarios []Scenario) {
+	t.Helper()
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.Name, func(t *testing.T) {
+			results, _, err := Run(scenario, scenarioConfig)
+			if err != nil {
+				t.Fatalf("harness error: %v", err)
+			}
+
+			for _, result := range results {
+				for _, failure := range result.Failures {
+					t.Errorf("%v: %v", result.Step, failure)
+				}
+			}
+
+			if err := Replay(scenario, scenarioConfig); err != nil {
+				t.Errorf("replay: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/desain-gratis/common/delivery/mycontent-api/storage/content"
+	content_chraft "github.com/desain-gratis/common/delivery/mycontent-api/storage/content/clickhouse-raft"
+	"github.com/desain-gratis/common/lib/raft"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+)
+
+var _ deployjob.Store = &memoryStore{}
+
+// memoryStore is the in-memory stand in of content_chraft.ContentApp.
+// Only behave the same as far as the deploy-job raft app is concerned:
+//   - namespace "*" query all namespace
+//   - refIDs can be partial (prefix)
+//   - IncrementalID table assign incremental ID per (namespace, refIDs), and returns the latest first (limited)
+//   - Delete returns a placeholder ("{}"), not the deleted data
+type memoryStore struct {
+	tables map[string]*memoryRepository
+}
+
+func newMemoryStore(tableConfigs ...content_chraft.TableConfig) *memoryStore {
+	store := &memoryStore{tables: make(map[string]*memoryRepository, len(tableConfigs))}
+	for _, config := range tableConfigs {
+		store.tables[config.Name] = &memoryRepository{
+			config:  config,
+			rows:    make(map[string]*content.Data),
+			version: make(map[string]uint64),
+		}
+	}
+	return store
+}
+
+func (s *memoryStore) Init(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) PrepareUpdate(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	return ctx, func() {}, nil
+}
+
+func (s *memoryStore) OnUpdate(ctx context.Context, e raft.Entry) (raft.OnAfterApply, error) {
+	return nil, fmt.Errorf("raft update %w: %v", raft.ErrUnsupported, e.Command)
+}
+
+func (s *memoryStore) Apply(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) Lookup(ctx context.Context, key interface{}) (interface{}, error) {
+	return nil, raft.ErrUnsupported
+}
+
+func (s *memoryStore) GetStorage(tableName string) (content.Repository, error) {
+	table, ok := s.tables[tableName]
+	if !ok {
+		return nil, errors.New("table not found")
+	}
+	return table, nil
+}
+
+// dump returns all the rows of all tables, sorted; to compare two runs
+func (s *memoryStore) dump() []string {
+	result := make([]string, 0)
+	for name, table := range s.tables {
+		for key, row := range table.rows {
+			result = append(result, name+"\\"+key+" "+string(row.Data))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+const keySeparator = "\\"
+
+type memoryRepository struct {
+	config content_chraft.TableConfig
+
+	rows    map[string]*content.Data // by full key
+	version map[string]uint64        // incremental ID by (namespace, refIDs)
+	eventID uint64
+}
+
+func (r *memoryRepository) Post(ctx context.Context, namespace string, refIDs []string, ID string, data content.Data) (content.Data, error) {
+	if len(refIDs) != r.config.RefSize {
+		return content.Data{}, fmt.Errorf("%w: expected %v ref, got %v", content.ErrInvalidKey, r.config.RefSize, len(refIDs))
+	}
+
+	parentKey := strings.Join(append([]string{namespace}, refIDs...), keySeparator)
+
+	if ID == "" {
+		if r.config.IncrementalID {
+			ID = strconv.FormatUint(r.version[parentKey], 10)
+			r.version[parentKey]++
+		} else {
+			// uuid shaped like production, but deterministic
+			ID = fmt.Sprintf("00000000-0000-4000-8000-%012x", r.eventID)
+		}
+	}
+
+	row := data
+	row.Namespace = namespace
+	row.RefIDs = append([]string{}, refIDs...)
+	row.ID = ID
+	row.EventID = r.eventID
+	r.eventID++
+
+	r.rows[parentKey+keySeparator+ID] = &row
+
+	return row, nil
+}
+
+func (r *memoryRepository) Get(ctx context.Context, namespace string, refIDs []string, ID string) ([]content.Data, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("%w: namespace must be specified", content.ErrInvalidKey)
+	}
+	if len(refIDs) > r.config.RefSize {
+		return nil, fmt.Errorf("%w: ref size is greater than expected", content.ErrInvalidKey)
+	}
+	if ID != "" && len(refIDs) < r.config.RefSize {
+		return nil, fmt.Errorf("%w: id provided without complete parent references", content.ErrInvalidKey)
+	}
+
+	result := make([]content.Data, 0)
+	for _, row := range r.rows {
+		if namespace != "*" && row.Namespace != namespace {
+			continue
+		}
+		if !hasPrefix(row.RefIDs, refIDs) {
+			continue
+		}
+		if ID != "" && row.ID != ID {
+			continue
+		}
+		result = append(result, *row)
+	}
+
+	if r.config.IncrementalID {
+		sort.Slice(result, func(i, j int) bool { return result[i].EventID > result[j].EventID })
+
+		limit := 20
+		if r.config.IncrementalIDGetLimit > 0 {
+			limit = int(r.config.IncrementalIDGetLimit)
+		}
+		if len(result) > limit {
+			result = result[:limit]
+		}
+		return result, nil
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].EventID < result[j].EventID })
+	return result, nil
+}
+
+func (r *memoryRepository) Delete(ctx context.Context, namespace string, refIDs []string, ID string) (content.Data, error) {
+	key := strings.Join(append(append([]string{namespace}, refIDs...), ID), keySeparator)
+
+	row, ok := r.rows[key]
+	if !ok {
+		return content.Data{}, errors.New("not found")
+	}
+	delete(r.rows, key)
+	r.eventID++
+
+	// same as production: only a placeholder, not the deleted data
+	placeholder := json.RawMessage("{}")
+	return content.Data{
+		EventID:   row.EventID,
+		Namespace: namespace,
+		RefIDs:    refIDs,
+		ID:        row.ID,
+		Data:      placeholder,
+		Meta:      placeholder,
+	}, nil
+}
+
+func (r *memoryRepository) Stream(ctx context.Context, namespace string, refIDs []string, ID string) (<-chan content.Data, error) {
+	rows, err := r.Get(ctx, namespace, refIDs, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan content.Data, len(rows))
+	for _, row := range rows {
+		out <- row
+	}
+	close(out)
+
+	return out, nil
+}
+
+func hasPrefix(refIDs, prefix []string) bool {
+	if len(prefix) > len(refIDs) {
+		return false
+	}
+	for idx := range prefix {
+		if refIDs[idx] != prefix[idx] {
+			return false
+		}
+	}
+	return true
+}
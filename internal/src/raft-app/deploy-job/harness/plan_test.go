@@ -0,0 +1,40 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestPlan(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "plan without submitting", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: []Step{
+				{
+					Name: "plan new deployment", Request: deployjob.PlanQuery{Request: submit(0, false)},
+					ExpectPlan: expectPlan(deployjob.SubmitJobStatusSuccess, 20000, true),
+				},
+				{
+					Name: "submit", Command: deployjob.CommandUserSubmitJob, Request: submit(1, false),
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+				},
+				{
+					Name: "plan queued", Request: deployjob.PlanQuery{Request: submit(3, true)},
+					ExpectPlan: expectPlan(deployjob.SubmitJobStatusQueued, 20000, false),
+				},
+			},
+		},
+	})
+}
+
+func TestPlanRejected(t *testing.T) {
+	runScenarios(t, rejections([]rejection{
+		{
+			name: "plan while busy", setup: configureAll(),
+			request: deployjob.PlanQuery{Request: submit(3, false)},
+			code:    deployjob.ErrorCodeConflict, status: entity.DeploymentJobStatusConfigured,
+		},
+	}))
+}
@@ -0,0 +1,67 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestPort(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "ports across services", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: []Step{
+				{
+					Name: "plan first service", Request: deployjob.PlanQuery{Request: withService(submit(0, false), scenarioService, 8080)},
+					ExpectPlan: expectPlan(deployjob.SubmitJobStatusSuccess, 20000, true),
+				},
+				{
+					Name: "submit first service", Command: deployjob.CommandUserSubmitJob, Request: withService(submit(0, false), scenarioService, 8080),
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+				},
+				{
+					Name: "plan second service", Request: deployjob.PlanQuery{Request: withService(submit(1, false), "order-service", 8081)},
+					ExpectPlan: expectPlan(deployjob.SubmitJobStatusSuccess, 20001, true),
+				},
+				{
+					Name: "second service with the same bound port", Command: deployjob.CommandUserSubmitJob, Request: withService(submit(2, false), "order-service", 8080),
+					ExpectError: deployjob.ErrorCodeConflict,
+				},
+				{
+					Name: "second service with invalid bound port", Command: deployjob.CommandUserSubmitJob, Request: withService(submit(3, false), "order-service", 70000),
+					ExpectError: deployjob.ErrorCodeValidation,
+				},
+				{
+					Name: "plan second service again", Request: deployjob.PlanQuery{Request: withService(submit(4, false), "order-service", 8081)},
+					ExpectPlan: expectPlan(deployjob.SubmitJobStatusSuccess, 20001, true),
+				},
+				{
+					Name: "submit second service", Command: deployjob.CommandUserSubmitJob, Request: withService(submit(5, false), "order-service", 8081),
+					ExpectEvents: []string{"EventDeploymentJobCreated"},
+				},
+				{
+					Name: "plan first service while busy", Request: deployjob.PlanQuery{Request: withService(submit(6, true), scenarioService, 8080)},
+					ExpectPlan: expectPlan(deployjob.SubmitJobStatusQueued, 20000, false),
+				},
+			},
+		},
+		{
+			Name: "bound ports inside the port range", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: []Step{
+				{
+					Name: "plan", Request: deployjob.PlanQuery{Request: withService(submit(0, false), scenarioService, 20000, 20001)},
+					ExpectPlan: expectPlan(deployjob.SubmitJobStatusSuccess, 20002, true),
+				},
+				{
+					Name: "submit", Command: deployjob.CommandUserSubmitJob, Request: withService(submit(0, false), scenarioService, 20000, 20001),
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+				},
+				{
+					Name: "plan while busy", Request: deployjob.PlanQuery{Request: withService(submit(1, true), scenarioService, 20000, 20001)},
+					ExpectPlan: expectPlan(deployjob.SubmitJobStatusQueued, 20002, false),
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestProgress(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "host progress", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: []Step{
+				{
+					Name: "submit", Command: deployjob.CommandUserSubmitJob, Request: submit(0, false),
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+				},
+				{
+					Name: "host-1 configuring", Command: deployjob.CommandHostConfigurationUpdate, Request: configuring("0", "host-1", 1, nil),
+					ExpectEvents: []string{"EventHostConfigured"},
+				},
+				{
+					Name: "host-1 downloading", Command: deployjob.CommandHostConfigurationUpdate,
+					Request:      configuring("0", "host-1", 2, progress("download", 3, 500, 2)),
+					ExpectEvents: []string{},
+					ExpectJob:    expectProgress("host-1", "download", 500),
+				},
+				{
+					Name: "host-1 reloading systemd", Command: deployjob.CommandHostConfigurationUpdate,
+					Request:      configuring("0", "host-1", 3, progress("daemon-reload", 5, 1000, 3)),
+					ExpectEvents: []string{},
+					ExpectJob:    expectProgress("host-1", "daemon-reload", 1000),
+				},
+				{
+					Name: "host-1 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-1", 4),
+					ExpectEvents: []string{"EventHostConfigured"},
+					ExpectJob:    expectProgress("host-1", "daemon-reload", 1000), // kept, the final report has no progress
+				},
+				{
+					Name: "host-2 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-2", 5),
+					ExpectJobStatus: entity.DeploymentJobStatusConfigured,
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,88 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestQueue(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "queue behind active job", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: []Step{
+				{
+					Name: "submit", Command: deployjob.CommandUserSubmitJob, Request: submit(0, false),
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+				},
+				{
+					Name: "submit queued", Command: deployjob.CommandUserSubmitJob, Request: submit(2, true),
+					JobId: "1", ExpectJobStatus: entity.DeploymentJobStatusQueued,
+					ExpectEvents: []string{},
+				},
+				{
+					Name: "cancel active", Command: deployjob.CommandUserCancelJob, Request: cancel("0", 3),
+					JobId: "1", ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+					ExpectEvents: []string{"EventDeploymentJobCancelled", "EventDeploymentJobCreated"},
+				},
+			},
+		},
+		{
+			// the active job is waiting for approval, while the queue is busy
+			Name: "active job out of the latest jobs", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: append(append([]Step{
+				{
+					Name: "submit", Command: deployjob.CommandUserSubmitJob,
+					Request: func() entity.SubmitDeploymentJobRequest {
+						request := submit(0, false)
+						request.Service.RequiredApprovals = 2
+						return request
+					}(),
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+				},
+				{Name: "host-1 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-1", 1)},
+				{
+					Name: "host-2 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-2", 2),
+					ExpectJobStatus: entity.DeploymentJobStatusConfigured,
+				},
+			}, queueChurn(1, 6, 3)...),
+				Step{
+					Name: "submit while busy", Command: deployjob.CommandUserSubmitJob, Request: submit(4, false),
+					ExpectError:     deployjob.ErrorCodeConflict,
+					ExpectJobStatus: entity.DeploymentJobStatusConfigured,
+				},
+				Step{
+					Name: "submit queued", Command: deployjob.CommandUserSubmitJob, Request: submit(5, true),
+					JobId: "7", ExpectJobStatus: entity.DeploymentJobStatusQueued,
+					ExpectEvents: []string{},
+				},
+				Step{
+					Name: "cancel active", Command: deployjob.CommandUserCancelJob, Request: cancel("0", 6),
+					JobId: "7", ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+					ExpectEvents: []string{"EventDeploymentJobCancelled", "EventDeploymentJobCreated"},
+				},
+			),
+		},
+	})
+}
+
+func TestQueueRejected(t *testing.T) {
+	runScenarios(t, rejections([]rejection{
+		{
+			name: "submit while busy", setup: configureAll(),
+			command: deployjob.CommandUserSubmitJob, request: submit(3, false),
+			code: deployjob.ErrorCodeConflict, status: entity.DeploymentJobStatusConfigured,
+		},
+		{
+			name: "too many queued jobs", setup: concat(configureAll(), queued(3, 5)),
+			command: deployjob.CommandUserSubmitJob, request: submit(4, true),
+			code: deployjob.ErrorCodeConflict, status: entity.DeploymentJobStatusConfigured,
+		},
+		{
+			name: "configuration of queued job", setup: concat(configureAll(), queued(3, 1)),
+			command: deployjob.CommandHostConfigurationUpdate, request: configured("1", "host-1", 4),
+			code: deployjob.ErrorCodeInvalidState, status: entity.DeploymentJobStatusConfigured,
+		},
+	}))
+}
@@ -0,0 +1,97 @@
+package harness
+
+import (
+	"fmt"
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestRetry(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "retry timed out configuration", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: []Step{
+				{Name: "submit", Command: deployjob.CommandUserSubmitJob, Request: submit(0, false)},
+				{Name: "host-1 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-1", 1)},
+				{
+					Name: "timeout", Command: deployjob.CommandLeaderJobTimeout, Request: timeout("0", 16),
+					ExpectJobStatus: entity.DeploymentJobStatusTimeOut,
+				},
+				{
+					Name: "retry configured host", Command: deployjob.CommandUserRetryHost, Request: retry("0", "host-1", 17),
+					ExpectError:     deployjob.ErrorCodeInvalidState,
+					ExpectJobStatus: entity.DeploymentJobStatusTimeOut,
+				},
+				{
+					Name: "retry timed out host", Command: deployjob.CommandUserRetryHost, Request: retry("0", "host-2", 18),
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+					ExpectEvents:    []string{"EventHostRetry"},
+					ExpectJob: func(job entity.DeploymentJob) []string {
+						var failures []string
+						if info := job.Configuration.Status["host-2"]; info.Status != entity.HostConfigurationStatusPending || info.RetryCount != 1 {
+							failures = append(failures, fmt.Sprintf("expected host-2 PENDING (retry 1), got %v (retry %v)", info.Status, info.RetryCount))
+						}
+						if job.Deadline == nil || !job.Deadline.Equal(at(33)) {
+							failures = append(failures, fmt.Sprintf("expected new deadline %v, got %v", at(33), job.Deadline))
+						}
+						return failures
+					},
+				},
+				{
+					Name: "host-2 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-2", 19),
+					ExpectJobStatus: entity.DeploymentJobStatusConfigured,
+				},
+			},
+		},
+		{
+			Name: "retry failed restart", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(configureAll(), []Step{
+				{Name: "confirm host-1", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 3)},
+				{
+					Name: "host-1 failed", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-1", entity.HostDeploymentStatusFailed, 4),
+					ExpectJobStatus: entity.DeploymentJobStatusFailed,
+				},
+				{
+					Name: "retry host not restarted yet", Command: deployjob.CommandUserRetryHost, Request: retry("0", "host-2", 5),
+					ExpectError: deployjob.ErrorCodeInvalidState,
+				},
+				{Name: "retry unknown host", Command: deployjob.CommandUserRetryHost, Request: retry("0", "host-9", 5), ExpectError: deployjob.ErrorCodeValidation},
+				{
+					Name: "retry host-1", Command: deployjob.CommandUserRetryHost, Request: retry("0", "host-1", 6),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventHostRetry"},
+				},
+				{
+					Name: "host-1 restarted", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-1", entity.HostDeploymentStatusSuccess, 7),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventServiceRestarted"},
+				},
+				{Name: "confirm host-2", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 8)},
+				{
+					Name: "host-2 timed out", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-2", entity.HostDeploymentStatusTimeOut, 9),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+				},
+				{
+					Name: "retry host-2 while deploying", Command: deployjob.CommandUserRetryHost, Request: retry("0", "host-2", 10),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventHostRetry"},
+				},
+				{
+					Name: "host-2 failed", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-2", entity.HostDeploymentStatusFailed, 11),
+					ExpectJobStatus: entity.DeploymentJobStatusFailed,
+				},
+				{
+					Name: "submit newer job", Command: deployjob.CommandUserSubmitJob, Request: submit(12, false),
+					JobId: "1", ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+				},
+				{
+					Name: "retry superseded job", Command: deployjob.CommandUserRetryHost, Request: retry("0", "host-2", 13),
+					ExpectError:     deployjob.ErrorCodeConflict,
+					ExpectJobStatus: entity.DeploymentJobStatusFailed,
+				},
+			}),
+		},
+	})
+}
@@ -0,0 +1,56 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestRollback(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			// the known-good job is out of the latest updated jobs when the new release fails
+			Name: "rollback to a release out of the latest jobs", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(configureAll(), deployAll("0", 3),
+				configureJob("1", withBuildVersion2(submit(9, false))),
+				withBuildVersion(queueChurn(2, 10, 12), 2),
+				[]Step{
+					{Name: "confirm new build", Command: deployjob.CommandRestartConfirmation, Request: confirm("1", 13)},
+					{
+						Name: "new build failed", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("1", "host-1", entity.HostDeploymentStatusFailed, 14),
+						JobId: "1", ExpectJobStatus: entity.DeploymentJobStatusFailed,
+					},
+					{
+						Name: "rollback", Command: deployjob.CommandUserRollbackJob, Request: rollback(15),
+						JobId: "12", ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+						ExpectEvents: []string{"EventDeploymentJobCreated"},
+						ExpectJob:    expectRollbackOf("0", 1),
+					},
+				},
+			),
+		},
+		{
+			Name: "rollback without known-good release", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(configureAll(), []Step{
+				{Name: "confirm host-1", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 3)},
+				{
+					Name: "host-1 failed", Command: deployjob.CommandHostRestartServiceUpdate, Request: restarted("0", "host-1", entity.HostDeploymentStatusFailed, 4),
+					ExpectJobStatus: entity.DeploymentJobStatusFailed,
+				},
+				{Name: "rollback", Command: deployjob.CommandUserRollbackJob, Request: rollback(5), ExpectError: deployjob.ErrorCodeNotFound},
+			}),
+		},
+		{
+			Name: "rollback skips the release being rolled back", Ns: scenarioNs, Service: scenarioService, JobId: "2",
+			Steps: concat(configureAll(), deployAll("0", 3),
+				configureJob("1", withBuildVersion2(submit(4, false))), expectKeep(deployAll("1", 5), 1, 1),
+				[]Step{{
+					Name: "rollback", Command: deployjob.CommandUserRollbackJob, Request: rollback(6),
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+					ExpectJob:       expectRollbackOf("0", 1),
+				}},
+			),
+		},
+	})
+}
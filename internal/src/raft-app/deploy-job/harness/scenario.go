@@ -0,0 +1,141 @@
+package harness
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/desain-gratis/common/lib/raft"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+// Scenario is a sequence of commands applied to a fresh harness
+type Scenario struct {
+	Name string
+
+	// the job checked after each step (unless the step specify its own)
+	Ns      string
+	Service string
+	JobId   string
+
+	Steps []Step
+}
+
+type Step struct {
+	Name    string
+	Command raft.Command
+	Request any // deployjob.PlanQuery is queried instead of applied (Command is ignored)
+
+	// the job to check; default to the scenario job
+	JobId string
+
+	ExpectError     deployjob.ErrorCode        // 0 means the command must succeed
+	ExpectJobStatus entity.DeploymentJobStatus // empty means not checked
+	ExpectEvents    []string                   // type name of the events in order; nil means not checked
+
+	// for PlanQuery step; returns the mismatches
+	ExpectPlan func(plan entity.DeploymentPlan) []string
+
+	// checks the job after the step; returns the mismatches
+	ExpectJob func(job entity.DeploymentJob) []string
+}
+
+type StepResult struct {
+	Step     string
+	Result   Result
+	Failures []string
+}
+
+// Run the scenario on a fresh harness. Returns the result of each step and the final store dump.
+func Run(scenario Scenario, config deployjob.Config) ([]StepResult, []string, error) {
+	h := New(config)
+
+	results := make([]StepResult, 0, len(scenario.Steps))
+	for _, step := range scenario.Steps {
+		var result Result
+		var plan *entity.DeploymentPlan
+		var err error
+		if query, ok := step.Request.(deployjob.PlanQuery); ok {
+			var planResult entity.DeploymentPlan
+			planResult, result, err = h.Plan(query.Request)
+			plan = &planResult
+		} else {
+			result, err = h.Apply(step.Command, step.Request)
+		}
+		if err != nil {
+			return results, nil, fmt.Errorf("step %v: %w", step.Name, err)
+		}
+
+		stepResult := StepResult{Step: step.Name, Result: result}
+
+		if step.ExpectPlan != nil && plan != nil && result.Err == nil {
+			stepResult.Failures = append(stepResult.Failures, step.ExpectPlan(*plan)...)
+		}
+
+		var code deployjob.ErrorCode
+		if result.Err != nil {
+			code = result.Err.Code
+		}
+		if code != step.ExpectError {
+			stepResult.Failures = append(stepResult.Failures,
+				fmt.Sprintf("expected error %v, got %v (%v)", step.ExpectError, code, string(result.Data)))
+		}
+
+		if step.ExpectJobStatus != "" || step.ExpectJob != nil {
+			jobId := step.JobId
+			if jobId == "" {
+				jobId = scenario.JobId
+			}
+
+			job, err := h.Job(scenario.Ns, scenario.Service, jobId)
+			if err != nil {
+				stepResult.Failures = append(stepResult.Failures, fmt.Sprintf("job %v: %v", jobId, err))
+			} else {
+				if step.ExpectJobStatus != "" && job.Status != step.ExpectJobStatus {
+					stepResult.Failures = append(stepResult.Failures,
+						fmt.Sprintf("expected job %v status %v, got %v", jobId, step.ExpectJobStatus, job.Status))
+				}
+				if step.ExpectJob != nil {
+					stepResult.Failures = append(stepResult.Failures, step.ExpectJob(*job)...)
+				}
+			}
+		}
+
+		if step.ExpectEvents != nil {
+			events := EventNames(result.Events)
+			if !slices.Equal(events, step.ExpectEvents) {
+				stepResult.Failures = append(stepResult.Failures,
+					fmt.Sprintf("expected events %v, got %v", step.ExpectEvents, events))
+			}
+		}
+
+		results = append(results, stepResult)
+	}
+
+	return results, h.Dump(), nil
+}
+
+// Replay run the scenario twice and make sure both runs end up in the same state
+func Replay(scenario Scenario, config deployjob.Config) error {
+	_, first, err := Run(scenario, config)
+	if err != nil {
+		return err
+	}
+
+	_, second, err := Run(scenario, config)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Equal(first, second) {
+		for idx := range min(len(first), len(second)) {
+			if first[idx] != second[idx] {
+				return fmt.Errorf("replay diverged at row %v:\n  %v\n  %v", idx, first[idx], second[idx])
+			}
+		}
+		return fmt.Errorf("replay diverged: %v rows vs %v rows", len(first), len(second))
+	}
+
+	return nil
+}
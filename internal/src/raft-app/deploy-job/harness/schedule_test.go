@@ -0,0 +1,39 @@
+package harness
+
+import (
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestSchedule(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			// 01:00 - 02:00 UTC+7 is 18:00 - 19:00 UTC
+			Name: "restart held until deploy window", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: concat(configureJob("0", scheduled(0, &entity.DeploySchedule{Windows: []entity.DeployWindow{{Start: "01:00", End: "02:00", UTCOffsetMinutes: 7 * 60}}})), []Step{
+				{
+					Name: "confirm outside window", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 3),
+					ExpectJobStatus: entity.DeploymentJobStatusConfigured,
+					ExpectEvents:    []string{},
+				},
+				{
+					Name: "leader confirm when window opens", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 18*60),
+					ExpectJobStatus: entity.DeploymentJobStatusDeploying,
+					ExpectEvents:    []string{"EventRestartConfirmed"},
+				},
+			}),
+		},
+	})
+}
+
+func TestScheduleRejected(t *testing.T) {
+	runScenarios(t, rejections([]rejection{
+		{
+			name:    "invalid deploy window",
+			command: deployjob.CommandUserSubmitJob, request: scheduled(0, &entity.DeploySchedule{Windows: []entity.DeployWindow{{Start: "22:00", End: "25:00"}}}),
+			code: deployjob.ErrorCodeValidation,
+		},
+	}))
+}
@@ -0,0 +1,43 @@
+package harness
+
+import (
+	"fmt"
+	"testing"
+
+	deployjob "github.com/desain-gratis/deployd/internal/src/raft-app/deploy-job"
+	"github.com/desain-gratis/deployd/src/entity"
+)
+
+func TestTimeout(t *testing.T) {
+	runScenarios(t, []Scenario{
+		{
+			Name: "phase timeout", Ns: scenarioNs, Service: scenarioService, JobId: "0",
+			Steps: []Step{
+				{Name: "submit", Command: deployjob.CommandUserSubmitJob, Request: submit(0, false)},
+				{Name: "host-1 configured", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-1", 1)},
+				{
+					Name: "timeout before deadline", Command: deployjob.CommandLeaderJobTimeout, Request: timeout("0", 10),
+					ExpectError:     deployjob.ErrorCodeInvalidState,
+					ExpectJobStatus: entity.DeploymentJobStatusConfiguring,
+				},
+				{
+					Name: "timeout after deadline", Command: deployjob.CommandLeaderJobTimeout, Request: timeout("0", 16),
+					ExpectJobStatus: entity.DeploymentJobStatusTimeOut,
+					ExpectEvents:    []string{"EventDeploymentJobTimedOut"},
+					ExpectJob: func(job entity.DeploymentJob) []string {
+						if status := job.Configuration.Status["host-2"].Status; status != entity.HostConfigurationStatusTimeOut {
+							return []string{fmt.Sprintf("expected host-2 configuration TIMEOUT, got %v", status)}
+						}
+						if job.Deadline != nil {
+							return []string{fmt.Sprintf("expected no deadline, got %v", job.Deadline)}
+						}
+						return nil
+					},
+				},
+				{Name: "timeout again", Command: deployjob.CommandLeaderJobTimeout, Request: timeout("0", 17), ExpectError: deployjob.ErrorCodeInvalidState},
+				{Name: "late configuration", Command: deployjob.CommandHostConfigurationUpdate, Request: configured("0", "host-2", 17), ExpectError: deployjob.ErrorCodeInvalidState},
+				{Name: "confirm timed out job", Command: deployjob.CommandRestartConfirmation, Request: confirm("0", 17), ExpectError: deployjob.ErrorCodeInvalidState},
+			},
+		},
+	})
+}
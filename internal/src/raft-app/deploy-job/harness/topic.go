@@ -0,0 +1,40 @@
+package harness
+
+import (
+	"context"
+	"errors"
+
+	"github.com/desain-gratis/common/lib/notifier"
+)
+
+var _ notifier.Topic = &recordingTopic{}
+
+// recordingTopic keep the broadcasted events in order, instead of sending it to subscribers.
+// The raft app only broadcast, so subscription is not supported.
+type recordingTopic struct {
+	events []any
+}
+
+func (t *recordingTopic) Subscribe(ctx context.Context, fn notifier.CreateSubscription) (notifier.Subscription, error) {
+	return nil, errors.New("subscribe is not supported in harness")
+}
+
+func (t *recordingTopic) GetSubscription(id string) (notifier.Subscription, error) {
+	return nil, errors.New("subscription not found")
+}
+
+func (t *recordingTopic) RemoveSubscription(id string) error {
+	return nil
+}
+
+func (t *recordingTopic) Broadcast(ctx context.Context, message any) error {
+	t.events = append(t.events, message)
+	return nil
+}
+
+// drain returns the events since the last drain
+func (t *recordingTopic) drain() []any {
+	events := t.events
+	t.events = nil
+	return events
+}
@@ -19,10 +19,62 @@ type (
 	// Lets go deploy
 	EventRestartConfirmed HostRestartConfirmationResponse
 
+	// Canary hosts restarted; start baking
+	EventCanaryStarted struct {
+		Job         entity.DeploymentJob `json:"job"`
+		TriggerHost string               `json:"trigger_host"`
+	}
+
 	EventDeploymentJobCancelled struct {
 		Job entity.DeploymentJob
 		// can add other event messages..
 	}
+
+	EventDeploymentJobTimedOut struct {
+		Job entity.DeploymentJob
+	}
+
+	// New hosts are deployed; removed hosts start uninstalling (CHANGE_HOSTS job)
+	EventDecommissionStarted struct {
+		Job entity.DeploymentJob `json:"job"`
+	}
+
+	// All hosts deployed; deployed hosts start pruning old releases
+	EventCleanupStarted struct {
+		Job entity.DeploymentJob `json:"job"`
+	}
+
+	// All hosts finished clean up; job is SUCCESS
+	EventCleanupFinished struct {
+		Job entity.DeploymentJob `json:"job"`
+	}
+
+	// Service instances removed & its ports released
+	EventServiceRemoved struct {
+		Ns        string                        `json:"namespace"`
+		Service   string                        `json:"service"`
+		Instances []*entity.ServiceInstanceHost `json:"instances"`
+	}
+
+	// Host no longer match the latest deployment of the service
+	EventHostDriftDetected struct {
+		Instance entity.ServiceInstanceHost `json:"instance"`
+	}
+
+	// A single host is asked to redo its phase
+	EventHostRetry struct {
+		Phase      RetryPhase           `json:"phase"`
+		TargetHost string               `json:"target_host"`
+		Job        entity.DeploymentJob `json:"job"`
+	}
+)
+
+type RetryPhase string
+
+const (
+	RetryPhaseConfiguration RetryPhase = "configuration"
+	RetryPhaseDeployment    RetryPhase = "deployment"
+	RetryPhaseDecommission  RetryPhase = "decommission"
 )
 
 type CancelJobRequest struct {
@@ -31,14 +83,27 @@ type CancelJobRequest struct {
 	Service string `json:"service"`
 
 	Reason string `json:"reason"`
+	Agent  string `json:"agent"` // who cancel it
 
-	// todo: actor
+	// rollback the hosts that already restarted to the previous deployed version
+	Rollback bool `json:"rollback"`
 
 	IsBelieve bool      `json:"is_believe"`
 	URL       string    `json:"url"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+type RollbackJobRequest struct {
+	Ns      string `json:"namespace"`
+	Service string `json:"service"`
+
+	TimeoutSeconds *uint32 `json:"timeout_seconds,omitempty"`
+
+	Agent     string    `json:"agent"` // who rollback it
+	IsBelieve bool      `json:"is_believe"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 type ConfigurationUpdateRequest struct {
 	Ns      string `json:"namespace"`
 	JobId   string `json:"job_id"`
@@ -48,6 +113,9 @@ type ConfigurationUpdateRequest struct {
 	Status       entity.HostConfigurationStatus `json:"status"`
 	ErrorMessage *string                        `json:"error_message,omitempty"`
 
+	// optional; if the status is the same as the current one, only the progress is updated (no audit & event)
+	Progress *entity.HostProgress `json:"progress,omitempty"`
+
 	URL       string    `json:"url"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -58,6 +126,18 @@ type ConfigurationUpdateResponse struct {
 	Job                *entity.DeploymentJob `json:"job"`
 }
 
+type CanaryUpdateRequest struct {
+	Ns      string `json:"namespace"`
+	JobId   string `json:"job_id"`
+	Service string `json:"service"`
+
+	HostName     string  `json:"host_name"`
+	ActiveState  string  `json:"active_state"` // systemd unit active state
+	ErrorMessage *string `json:"error_message,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 type RestartConfirmation struct {
 	Ns      string `json:"namespace"`
 	JobId   string `json:"job_id"`
@@ -72,6 +152,90 @@ type RestartConfirmation struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+type JobTimeoutRequest struct {
+	Ns      string `json:"namespace"`
+	JobId   string `json:"job_id"`
+	Service string `json:"service"`
+
+	Agent     string    `json:"agent"`      // the leader who propose it
+	CreatedAt time.Time `json:"created_at"` // leader's clock; compared with the job deadline
+}
+
+type DecommissionUpdateRequest struct {
+	Ns      string `json:"namespace"`
+	JobId   string `json:"job_id"`
+	Service string `json:"service"`
+
+	HostName     string                        `json:"host_name"`
+	Status       entity.HostDecommissionStatus `json:"status"`
+	ErrorMessage *string                       `json:"error_message,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CleanupUpdateRequest struct {
+	Ns      string `json:"namespace"`
+	JobId   string `json:"job_id"`
+	Service string `json:"service"`
+
+	HostName     string                   `json:"host_name"`
+	Status       entity.HostCleanupStatus `json:"status"`
+	ErrorMessage *string                  `json:"error_message,omitempty"`
+	Removed      []string                 `json:"removed,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// HostCancelUpdateRequest is the host acknowledgement of a cancelled job.
+// Empty status means the host has nothing to report for that phase.
+type HostCancelUpdateRequest struct {
+	Ns      string `json:"namespace"`
+	JobId   string `json:"job_id"`
+	Service string `json:"service"`
+
+	HostName            string                         `json:"host_name"`
+	ConfigurationStatus entity.HostConfigurationStatus `json:"configuration_status,omitempty"`
+	DeploymentStatus    entity.HostDeploymentStatus    `json:"deployment_status,omitempty"`
+	ErrorMessage        *string                        `json:"error_message,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type FreezeRequest struct {
+	Ns     string     `json:"namespace"`
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until,omitempty"` // empty means until unfrozen
+
+	Agent     string    `json:"agent"` // who freeze it
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UnfreezeRequest struct {
+	Ns string `json:"namespace"`
+
+	Agent     string    `json:"agent"` // who unfreeze it
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RemoveServiceRequest struct {
+	Ns      string `json:"namespace"`
+	Service string `json:"service"`
+
+	Agent     string    `json:"agent"` // who remove it
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RetryHostRequest struct {
+	Ns      string `json:"namespace"`
+	JobId   string `json:"job_id"`
+	Service string `json:"service"`
+
+	HostName string `json:"host_name"`
+
+	Agent     string    `json:"agent"` // who retry it
+	CreatedAt time.Time `json:"created_at"`
+}
+
 type HostRestartServiceUpdateResponse struct {
 	Step              int                  `json:"current_step"`
 	TargetHost        string               `json:"target_host"`
@@ -84,7 +248,8 @@ type HostRestartServiceUpdateResponse struct {
 
 type HostRestartConfirmationResponse struct {
 	Step        int                  `json:"current_step"`
-	TargetHost  string               `json:"target_host"`
+	TargetHost  string               `json:"target_host"` // first host of the batch
+	TargetHosts []string             `json:"target_hosts"`
 	Job         entity.DeploymentJob `json:"job"`
 	TriggerHost string               `json:"trigger_host"`
 	Message     string               `json:"message"`
@@ -99,8 +264,36 @@ type HostRestartServiceUpdateRequest struct {
 	Status       entity.HostDeploymentStatus `json:"status"`
 	ErrorMessage *string                     `json:"message,omitempty"`
 
+	// optional; if the status is the same as the current one, only the progress is updated (no audit & event)
+	Progress *entity.HostProgress `json:"progress,omitempty"`
+
 	Order *int `json:"order"`
 
 	URL       string    `json:"url"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// PlanQuery is a read-only query (raft Lookup) of what the submit job request would do
+type PlanQuery struct {
+	Request entity.SubmitDeploymentJobRequest
+}
+
+// PlanResponse is returned as is by the Lookup; the submit error (if any) is in Err
+type PlanResponse struct {
+	Plan entity.DeploymentPlan
+	Err  *Error
+}
+
+// DriftUpdateRequest is reported by the host reconciler when the drift of a service instance changed.
+// Empty Items means the host match the latest deployment again.
+type DriftUpdateRequest struct {
+	Ns       string             `json:"namespace"`
+	Service  string             `json:"service"`
+	HostName string             `json:"host_name"`
+	Items    []entity.DriftItem `json:"items,omitempty"`
+
+	// the drift is repaired by the host; Items is what was repaired
+	Repaired bool `json:"repaired,omitempty"`
+
+	CheckedAt time.Time `json:"checked_at"`
+}
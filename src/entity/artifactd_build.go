@@ -1,7 +1,9 @@
 package entity
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/desain-gratis/common/delivery/mycontent-api/mycontent"
@@ -74,3 +76,25 @@ func (a *BuildArtifact) WithURL(url string) mycontent.Data {
 func (a *BuildArtifact) WithEventID(id uint64) mycontent.VersionedData {
 	return a
 }
+
+// the archive digest is stored in the attachment hash, eg. "sha256:<hex>".
+// the optional signature is an attachment tag "ed25519:<base64>", signing the digest string.
+const (
+	ArchiveDigestPrefix    = "sha256:"
+	ArchiveSignaturePrefix = "ed25519:"
+)
+
+// ArchiveDigest format the sha-256 sum of the archive
+func ArchiveDigest(sum []byte) string {
+	return ArchiveDigestPrefix + hex.EncodeToString(sum)
+}
+
+// ArchiveSignature returns the base64 signature in the archive tags; empty if not signed
+func ArchiveSignature(tags []string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, ArchiveSignaturePrefix) {
+			return strings.TrimPrefix(tag, ArchiveSignaturePrefix)
+		}
+	}
+	return ""
+}
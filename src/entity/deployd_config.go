@@ -18,6 +18,9 @@ type Host struct {
 	RaftConfig   DeploydRaftConfig `json:"raft_config"`
 	FQDN         string            `json:"fqdn"`
 
+	// deployd HTTP address of the host, reachable from the other hosts (eg. http://mb1:9600)
+	Address string `json:"address,omitempty"`
+
 	PublishedAt time.Time `json:"published_at" ch:"published_at"`
 	URLx        string    `json:"url"`
 }
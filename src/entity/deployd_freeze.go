@@ -0,0 +1,75 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/desain-gratis/common/delivery/mycontent-api/mycontent"
+)
+
+var _ mycontent.Data = &Freeze{}
+
+// FreezeID is the ID of the freeze record; one per namespace
+const FreezeID = "current"
+
+// Freeze blocks the deployment in a namespace (eg. during incident / holiday),
+// unless the job explicitly override it (SubmitDeploymentJobRequest.OverrideFreeze).
+type Freeze struct {
+	Ns       string     `json:"namespace"`
+	Id       string     `json:"id"`
+	Reason   string     `json:"reason"`
+	FrozenBy string     `json:"frozen_by"`
+	FrozenAt time.Time  `json:"frozen_at"`
+	Until    *time.Time `json:"until,omitempty"` // empty means until unfrozen
+
+	PublishedAt time.Time `json:"published_at"`
+	URLx        string    `json:"url"`
+}
+
+// IsActive returns true if the freeze is still in effect at t
+func (a *Freeze) IsActive(t time.Time) bool {
+	return a.Until == nil || t.Before(*a.Until)
+}
+
+func (a *Freeze) CreatedTime() time.Time {
+	return a.PublishedAt
+}
+
+func (a *Freeze) ID() string {
+	return a.Id
+}
+
+func (a *Freeze) Namespace() string {
+	return a.Ns
+}
+
+func (a *Freeze) RefIDs() []string {
+	return nil
+}
+
+func (a *Freeze) URL() string {
+	return a.URLx
+}
+
+func (a *Freeze) Validate() error {
+	return nil
+}
+
+func (a *Freeze) WithCreatedTime(t time.Time) mycontent.Data {
+	a.PublishedAt = t
+	return a
+}
+
+func (a *Freeze) WithID(id string) mycontent.Data {
+	a.Id = id
+	return a
+}
+
+func (a *Freeze) WithNamespace(id string) mycontent.Data {
+	a.Ns = id
+	return a
+}
+
+func (a *Freeze) WithURL(url string) mycontent.Data {
+	a.URLx = url
+	return a
+}
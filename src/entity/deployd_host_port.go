@@ -0,0 +1,76 @@
+package entity
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/desain-gratis/common/delivery/mycontent-api/mycontent"
+)
+
+var _ mycontent.Data = &HostPort{}
+
+type HostPortPurpose string
+
+const (
+	HostPortPurposeRaft  HostPortPurpose = "raft"  // allocated by deployd
+	HostPortPurposeBound HostPortPurpose = "bound" // from the service BoundAddresses
+)
+
+// HostPort is a port used by a service in a host; the port registry.
+type HostPort struct {
+	Ns      string          `json:"namespace"`
+	Host    string          `json:"host"`
+	Port    uint16          `json:"port"` // the ID
+	Service string          `json:"service"`
+	Purpose HostPortPurpose `json:"purpose"`
+
+	PublishedAt time.Time `json:"published_at"`
+	URLx        string    `json:"url"`
+}
+
+func (a *HostPort) CreatedTime() time.Time {
+	return a.PublishedAt
+}
+
+func (a *HostPort) ID() string {
+	return strconv.FormatUint(uint64(a.Port), 10)
+}
+
+func (a *HostPort) Namespace() string {
+	return a.Ns
+}
+
+func (a *HostPort) RefIDs() []string {
+	return []string{a.Host}
+}
+
+func (a *HostPort) URL() string {
+	return a.URLx
+}
+
+func (a *HostPort) Validate() error {
+	return nil
+}
+
+func (a *HostPort) WithCreatedTime(t time.Time) mycontent.Data {
+	a.PublishedAt = t
+	return a
+}
+
+func (a *HostPort) WithID(id string) mycontent.Data {
+	port, err := strconv.ParseUint(id, 10, 16)
+	if err == nil {
+		a.Port = uint16(port)
+	}
+	return a
+}
+
+func (a *HostPort) WithNamespace(id string) mycontent.Data {
+	a.Ns = id
+	return a
+}
+
+func (a *HostPort) WithURL(url string) mycontent.Data {
+	a.URLx = url
+	return a
+}
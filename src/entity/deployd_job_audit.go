@@ -0,0 +1,77 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/desain-gratis/common/delivery/mycontent-api/mycontent"
+)
+
+var _ mycontent.Data = &JobAudit{}
+
+// JobAuditNoJob is the job reference of command that is not about a single job (eg. remove service)
+const JobAuditNoJob = "-"
+
+// JobAudit is an append-only record of a command applied to a deployment job.
+type JobAudit struct {
+	Ns      string `json:"namespace"`
+	Service string `json:"service"`
+	JobId   string `json:"job_id"`
+	Id      string `json:"id"` // incremental per job
+
+	Command        string              `json:"command"`
+	Actor          string              `json:"actor"`
+	PreviousStatus DeploymentJobStatus `json:"previous_status,omitempty"`
+	NextStatus     DeploymentJobStatus `json:"next_status,omitempty"`
+	Host           string              `json:"host,omitempty"`
+	Message        string              `json:"message,omitempty"`
+
+	RaftIndex uint64    `json:"raft_index"`
+	Timestamp time.Time `json:"timestamp"` // from the command, not the replica clock
+
+	PublishedAt time.Time `json:"published_at"`
+	URLx        string    `json:"url"`
+}
+
+func (a *JobAudit) CreatedTime() time.Time {
+	return a.PublishedAt
+}
+
+func (a *JobAudit) ID() string {
+	return a.Id
+}
+
+func (a *JobAudit) Namespace() string {
+	return a.Ns
+}
+
+func (a *JobAudit) RefIDs() []string {
+	return []string{a.Service, a.JobId}
+}
+
+func (a *JobAudit) URL() string {
+	return a.URLx
+}
+
+func (a *JobAudit) Validate() error {
+	return nil
+}
+
+func (a *JobAudit) WithCreatedTime(t time.Time) mycontent.Data {
+	a.PublishedAt = t
+	return a
+}
+
+func (a *JobAudit) WithID(id string) mycontent.Data {
+	a.Id = id
+	return a
+}
+
+func (a *JobAudit) WithNamespace(id string) mycontent.Data {
+	a.Ns = id
+	return a
+}
+
+func (a *JobAudit) WithURL(url string) mycontent.Data {
+	a.URLx = url
+	return a
+}
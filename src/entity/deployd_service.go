@@ -33,6 +33,10 @@ type ServiceDefinition struct {
 
 	BoundAddresses []BoundAddress `json:"bound_addresses"`
 
+	// Number of distinct approvers needed before the rollout starts; the namespace may ask for more (deployd config).
+	// Approvers are identified by the trusted X-Agent header and can't include the job submitter.
+	RequiredApprovals uint `json:"required_approvals,omitempty"`
+
 	PublishedAt time.Time `json:"published_at"`
 	URLx        string    `json:"url"`
 }
@@ -20,10 +20,34 @@ type ServiceInstanceHost struct {
 	ActiveDeployment *HostDeploymentJob `json:"active_deployment,omitempty"`
 	LatestDeployment *HostDeploymentJob `json:"latest_deployment,omitempty"`
 
+	// host no longer match the LatestDeployment; reported by the host reconciler. Empty if no drift.
+	Drift *HostDrift `json:"drift,omitempty"`
+
 	PublishedAt time.Time `json:"published_at"`
 	URLx        string    `json:"url"`
 }
 
+// HostDrift is what is changed on the host outside of deployd
+type HostDrift struct {
+	Items      []DriftItem `json:"items"`
+	DetectedAt time.Time   `json:"detected_at"`
+}
+
+type DriftKind string
+
+const (
+	DriftKindUnit         DriftKind = "UNIT"          // systemd unit file is edited / removed
+	DriftKindBuildLink    DriftKind = "BUILD_LINK"    // /opt/<svc>/current point to other release
+	DriftKindEnvLink      DriftKind = "ENV_LINK"      // /etc/<svc>/env point to other release
+	DriftKindServiceState DriftKind = "SERVICE_STATE" // service is not running
+)
+
+type DriftItem struct {
+	Kind     DriftKind `json:"kind"`
+	Expected string    `json:"expected"`
+	Actual   string    `json:"actual"`
+}
+
 type RaftConfig struct {
 	RaftPort       uint16 `json:"raft_port"`
 	ReplicaID      uint64 `json:"replica_id"`
@@ -0,0 +1,83 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/desain-gratis/common/delivery/mycontent-api/mycontent"
+)
+
+var _ mycontent.Data = &ServiceJob{}
+
+// ServiceJob points to the jobs of a service that are still relevant, one per service.
+// The job table only returns the latest updated jobs, so the active / queued job
+// (and the known-good releases) can be out of it after many updates.
+type ServiceJob struct {
+	Ns      string `json:"namespace"`
+	Service string `json:"service"` // the ID
+
+	// the latest submitted job
+	LatestJobId string `json:"latest_job_id,omitempty"`
+
+	// non-terminal, non-queued job; at most one
+	ActiveJobId string `json:"active_job_id,omitempty"`
+
+	// oldest first
+	QueuedJobIds []string `json:"queued_job_ids,omitempty"`
+
+	// the latest DEPLOYED / SUCCESS job of each release, latest first; used for rollback
+	Releases []ServiceRelease `json:"releases,omitempty"`
+
+	PublishedAt time.Time `json:"published_at"`
+	URLx        string    `json:"url"`
+}
+
+// ServiceRelease is a build & env version combination known to be deployed successfully
+type ServiceRelease struct {
+	JobId        string `json:"job_id"`
+	BuildVersion uint64 `json:"build_version"`
+	EnvVersion   uint64 `json:"env_version"`
+}
+
+func (a *ServiceJob) CreatedTime() time.Time {
+	return a.PublishedAt
+}
+
+func (a *ServiceJob) ID() string {
+	return a.Service
+}
+
+func (a *ServiceJob) Namespace() string {
+	return a.Ns
+}
+
+func (a *ServiceJob) RefIDs() []string {
+	return nil
+}
+
+func (a *ServiceJob) URL() string {
+	return a.URLx
+}
+
+func (a *ServiceJob) Validate() error {
+	return nil
+}
+
+func (a *ServiceJob) WithCreatedTime(t time.Time) mycontent.Data {
+	a.PublishedAt = t
+	return a
+}
+
+func (a *ServiceJob) WithID(id string) mycontent.Data {
+	a.Service = id
+	return a
+}
+
+func (a *ServiceJob) WithNamespace(id string) mycontent.Data {
+	a.Ns = id
+	return a
+}
+
+func (a *ServiceJob) WithURL(url string) mycontent.Data {
+	a.URLx = url
+	return a
+}
@@ -20,13 +20,19 @@ const (
 	// All host configured
 	DeploymentJobStatusConfigured DeploymentJobStatus = "CONFIGURED"
 
-	// Wait for each host to restart service (one by one)
+	// Wait for each host to restart service (batch by batch, see RolloutStrategy)
 	DeploymentJobStatusDeploying DeploymentJobStatus = "DEPLOYING"
 
-	// All hosts finished restart service
+	// Canary hosts restarted; baking before promoted to the rest of the hosts
+	DeploymentJobStatusCanary DeploymentJobStatus = "CANARY"
+
+	// Removed hosts stop & uninstall the service (CHANGE_HOSTS job)
+	DeploymentJobStatusDecommissioning DeploymentJobStatus = "DECOMMISSIONING"
+
+	// All hosts finished restart service; hosts are cleaning up old releases
 	DeploymentJobStatusDeployed DeploymentJobStatus = "DEPLOYED"
 
-	// Clean up & archived
+	// Clean up finished
 	DeploymentJobStatusSuccess DeploymentJobStatus = "SUCCESS"
 
 	// Cancelled
@@ -39,6 +45,18 @@ const (
 	DeploymentJobStatusFailed DeploymentJobStatus = "FAILED"
 )
 
+// IsTerminal returns true if the job will not progress anymore
+func (s DeploymentJobStatus) IsTerminal() bool {
+	switch s {
+	case DeploymentJobStatusSuccess,
+		DeploymentJobStatusCancelled,
+		DeploymentJobStatusTimeOut,
+		DeploymentJobStatusFailed:
+		return true
+	}
+	return false
+}
+
 type HostDeploymentJob struct {
 	*DeploymentJob
 	Status HostDeploymentStatus `json:"status"`
@@ -55,34 +73,200 @@ type DeploymentJob struct {
 	Request       SubmitDeploymentJobRequest `json:"request"`
 	Deployment    Deployment                 `json:"deployment"`
 	Configuration Configuration              `json:"configuration"`
+	Decommission  Decommission               `json:"decommission"`
+	Approval      Approval                   `json:"approval"`
+	Cleanup       Cleanup                    `json:"cleanup"`
+	Cancellation  *Cancellation              `json:"cancellation,omitempty"`
+
+	// Restart confirmation held until the deploy window opens (see SubmitDeploymentJobRequest.Schedule) or the namespace freeze is over
+	PendingSchedule *PendingSchedule `json:"pending_schedule,omitempty"`
+
+	// Deadline of the current phase; if passed, the leader will time out the job.
+	// Empty if the job is waiting for user (eg. restart confirmation) or already finished.
+	Deadline *time.Time `json:"deadline,omitempty"`
 
 	Url         string    `json:"url"`
 	PublishedAt time.Time `json:"published_at"`
 }
 
+// Cancellation records who cancel the job and why
+type Cancellation struct {
+	Reason      string    `json:"reason"`
+	CancelledBy string    `json:"cancelled_by"`
+	CancelledAt time.Time `json:"cancelled_at"`
+
+	// Rollback the hosts that already switched to this job version, to RollbackJobId version
+	Rollback             bool   `json:"rollback,omitempty"`
+	RollbackJobId        string `json:"rollback_job_id,omitempty"`
+	RollbackBuildVersion uint64 `json:"rollback_build_version,omitempty"`
+	RollbackEnvVersion   uint64 `json:"rollback_env_version,omitempty"`
+}
+
+// Approval of the rollout; CONFIGURED job only start deploying once Required distinct approvers confirmed it
+type Approval struct {
+	Required  uint       `json:"required,omitempty"`
+	Approvals []Approver `json:"approvals,omitempty"`
+}
+
+type Approver struct {
+	Agent      string    `json:"agent"`
+	Message    string    `json:"message,omitempty"`
+	ApprovedAt time.Time `json:"approved_at"`
+}
+
+// IsApproved returns true if there are enough approvers
+func (a Approval) IsApproved() bool {
+	return uint(len(a.Approvals)) >= a.Required
+}
+
+// HasApproved returns true if the agent already approved
+func (a Approval) HasApproved(agent string) bool {
+	for _, approver := range a.Approvals {
+		if approver.Agent == agent {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingSchedule is a restart confirmation that arrived outside of the deploy window (or while the namespace is frozen).
+// The leader confirm it again at OpenAt.
+type PendingSchedule struct {
+	OpenAt      time.Time `json:"open_at"`
+	ConfirmedBy string    `json:"confirmed_by"`
+	Message     string    `json:"message,omitempty"`
+	ConfirmedAt time.Time `json:"confirmed_at"`
+}
+
 type Configuration struct {
 	Status map[string]HostConfigurationStatusInfo `json:"status"`
 }
 
+// Decommission of the hosts removed from the service
+type Decommission struct {
+	Status map[string]HostDecommissionStatusInfo `json:"status,omitempty"`
+}
+
+// Cleanup of the old releases after deployed
+type Cleanup struct {
+	Status map[string]HostCleanupStatusInfo `json:"status,omitempty"`
+
+	// The known-good release before this job; kept regardless of the retention, so the job can be rolled back to it
+	KeepBuildVersion uint64 `json:"keep_build_version,omitempty"`
+	KeepEnvVersion   uint64 `json:"keep_env_version,omitempty"`
+}
+
+// IsFinished returns true if all hosts finished cleaning up (successfully or not)
+func (c Cleanup) IsFinished() bool {
+	for _, info := range c.Status {
+		switch info.Status {
+		case HostCleanupStatusSuccess, HostCleanupStatusFailed, HostCleanupStatusTimeOut:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 type Deployment struct {
 	ConfirmedBy  string                              `json:"confirmed_by,omitempty"`
-	CurrentOrder *uint                               `json:"current_order,omitempty"`
+	CurrentOrder *uint                               `json:"current_order,omitempty"` // index of the first host of the current batch
+	BatchSize    uint                                `json:"batch_size,omitempty"`    // number of host restarted together; decided when deployment start
 	HostOrder    []string                            `json:"host_order"`
 	Status       map[string]HostDeploymentStatusInfo `json:"status"`
+
+	// Canary; the first CanaryHosts of HostOrder are restarted first as a single batch
+	CanaryHosts uint       `json:"canary_hosts,omitempty"`
+	BakeUntil   *time.Time `json:"bake_until,omitempty"`
+	PromotedBy  string     `json:"promoted_by,omitempty"`
+}
+
+// CurrentBatch returns the hosts that are restarted in the current step
+func (d Deployment) CurrentBatch() []string {
+	if d.CurrentOrder == nil || int(*d.CurrentOrder) >= len(d.HostOrder) {
+		return nil
+	}
+
+	batchSize := d.BatchSize
+	if batchSize == 0 {
+		// job created before batching; one by one
+		batchSize = 1
+	}
+
+	start := int(*d.CurrentOrder)
+	end := min(start+int(batchSize), len(d.HostOrder))
+	if start < int(d.CanaryHosts) {
+		end = min(int(d.CanaryHosts), len(d.HostOrder))
+	}
+
+	return d.HostOrder[start:end]
+}
+
+// IsCanary returns true if the host is one of the canary hosts
+func (d Deployment) IsCanary(host string) bool {
+	for _, canaryHost := range d.HostOrder[:min(int(d.CanaryHosts), len(d.HostOrder))] {
+		if canaryHost == host {
+			return true
+		}
+	}
+	return false
+}
+
+// InCurrentBatch returns true if the host is restarted in the current step
+func (d Deployment) InCurrentBatch(host string) bool {
+	for _, batchHost := range d.CurrentBatch() {
+		if batchHost == host {
+			return true
+		}
+	}
+	return false
 }
 
 type HostDeploymentStatusInfo struct {
 	ErrorMessage *string              `json:"error_message,omitempty"`
 	Status       HostDeploymentStatus `json:"status"`
+	RetryCount   uint8                `json:"retry_count,omitempty"`
+	Progress     *HostProgress        `json:"progress,omitempty"`
 }
 
 type HostConfigurationStatusInfo struct {
 	ErrorMessage *string                 `json:"error_message,omitempty"`
 	Status       HostConfigurationStatus `json:"status"`
+	RetryCount   uint8                   `json:"retry_count,omitempty"`
+	Progress     *HostProgress           `json:"progress,omitempty"`
+}
+
+// HostProgress is the step progress of the host while configuring / restarting.
+// Reported periodically (throttled) by the host; it can be behind the actual progress.
+type HostProgress struct {
+	Step        string `json:"step,omitempty"` // running step(s), comma separated
+	CurrentStep uint8  `json:"current_step"`   // finished steps
+	TotalSteps  uint8  `json:"total_steps"`
+
+	// artifact download; zero if there is nothing to download
+	BytesDownloaded uint64 `json:"bytes_downloaded,omitempty"`
+	BytesTotal      uint64 `json:"bytes_total,omitempty"`
+
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type HostDecommissionStatusInfo struct {
+	ErrorMessage *string                `json:"error_message,omitempty"`
+	Status       HostDecommissionStatus `json:"status"`
+	RetryCount   uint8                  `json:"retry_count,omitempty"`
+}
+
+type HostCleanupStatusInfo struct {
+	ErrorMessage *string           `json:"error_message,omitempty"`
+	Status       HostCleanupStatus `json:"status"`
+	Removed      []string          `json:"removed,omitempty"` // removed paths
 }
 
 type HostDeploymentStatus string
 type HostConfigurationStatus string
+type HostDecommissionStatus string
+type HostCleanupStatus string
 
 const (
 	// mostly for raft service; ordinary service can run on the same port; but for raft, since they lock the directory to a single process, we just restart
@@ -97,6 +281,8 @@ const (
 	HostDeploymentStatusSuccess        HostDeploymentStatus = "SUCCESS"
 	HostDeploymentStatusFailed         HostDeploymentStatus = "FAILED"
 	HostDeploymentStatusTimeOut        HostDeploymentStatus = "TIMEOUT"
+	HostDeploymentStatusCancelled      HostDeploymentStatus = "CANCELLED"   // job cancelled before / while restarting
+	HostDeploymentStatusRolledBack     HostDeploymentStatus = "ROLLED_BACK" // job cancelled after restart; switched back to the previous version
 
 	HostConfigurationStatusPending     HostConfigurationStatus = "PENDING"
 	HostConfigurationStatusConfiguring HostConfigurationStatus = "CONFIGURING"
@@ -104,6 +290,18 @@ const (
 	HostConfigurationStatusFailed      HostConfigurationStatus = "FAILED"
 	HostConfigurationStatusCancelled   HostConfigurationStatus = "CANCELLED"
 	HostConfigurationStatusTimeOut     HostConfigurationStatus = "TIMEOUT"
+
+	HostDecommissionStatusPending      HostDecommissionStatus = "PENDING"
+	HostDecommissionStatusUninstalling HostDecommissionStatus = "UNINSTALLING" // stop service, remove unit & files
+	HostDecommissionStatusSuccess      HostDecommissionStatus = "SUCCESS"
+	HostDecommissionStatusFailed       HostDecommissionStatus = "FAILED"
+	HostDecommissionStatusTimeOut      HostDecommissionStatus = "TIMEOUT"
+
+	HostCleanupStatusPending  HostCleanupStatus = "PENDING"
+	HostCleanupStatusCleaning HostCleanupStatus = "CLEANING" // prune old releases & downloaded artifact
+	HostCleanupStatusSuccess  HostCleanupStatus = "SUCCESS"
+	HostCleanupStatusFailed   HostCleanupStatus = "FAILED"
+	HostCleanupStatusTimeOut  HostCleanupStatus = "TIMEOUT"
 )
 
 func (d *DeploymentJob) CreatedTime() time.Time {
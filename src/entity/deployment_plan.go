@@ -0,0 +1,62 @@
+package entity
+
+// DeploymentPlan is what the job would do if submitted now (dry run); nothing is proposed to raft.
+type DeploymentPlan struct {
+	Ns      string            `json:"namespace"`
+	Service string            `json:"service"`
+	Type    DeploymentJobType `json:"type,omitempty"`
+
+	// SUCCESS or QUEUED; see SubmitJobStatus
+	SubmitStatus string `json:"submit_status"`
+
+	BuildVersion uint64 `json:"build_version"`
+	EnvVersion   uint64 `json:"env_version"`
+
+	// rendered systemd unit; the same for all hosts
+	Unit string `json:"unit"`
+
+	Hosts        []HostPlan    `json:"hosts"`
+	RemovedHosts []string      `json:"removed_hosts,omitempty"` // decommissioned (CHANGE_HOSTS job)
+	Ports        []PlannedPort `json:"ports,omitempty"`
+
+	// freeze that the job override
+	OverriddenFreeze *Freeze `json:"overridden_freeze,omitempty"`
+}
+
+// HostPlan is the plan of a single host; versions are compared with what the cluster and the host think is running
+type HostPlan struct {
+	Host         string `json:"host"`
+	New          bool   `json:"new,omitempty"` // service is not installed on the host yet
+	BuildVersion uint64 `json:"build_version"`
+	EnvVersion   uint64 `json:"env_version"`
+
+	// latest successful deployment according to the cluster
+	DeployedBuildVersion uint64 `json:"deployed_build_version,omitempty"`
+	DeployedEnvVersion   uint64 `json:"deployed_env_version,omitempty"`
+
+	// contributed by the host itself; empty if the host cannot be reached
+	Local      *HostLocalView `json:"local,omitempty"`
+	LocalError string         `json:"local_error,omitempty"`
+}
+
+// HostLocalView is what is actually installed on the host
+type HostLocalView struct {
+	Host string `json:"host"`
+
+	// version linked at /opt/<svc>/current and /etc/<svc>/env; empty if not linked
+	LinkedBuildVersion string `json:"linked_build_version,omitempty"`
+	LinkedEnvVersion   string `json:"linked_env_version,omitempty"`
+
+	// installed systemd unit; empty if not installed
+	InstalledUnit string `json:"installed_unit,omitempty"`
+	// line diff between the installed and the rendered unit; empty if the same
+	UnitDiff string `json:"unit_diff,omitempty"`
+}
+
+// PlannedPort is a port the job will use in a host
+type PlannedPort struct {
+	Host    string          `json:"host"`
+	Port    uint16          `json:"port"`
+	Purpose HostPortPurpose `json:"purpose"`
+	New     bool            `json:"new,omitempty"` // not registered yet; will be allocated / reserved
+}
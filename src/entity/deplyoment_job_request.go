@@ -1,6 +1,9 @@
 package entity
 
 import (
+	"errors"
+	"fmt"
+	"slices"
 	"time"
 )
 
@@ -10,11 +13,24 @@ type CancelJobRequest struct {
 	Service string `json:"service"`
 }
 
+type DeploymentJobType string
+
+const (
+	// Deploy a new version to the service hosts. Empty type is a deploy.
+	DeploymentJobTypeDeploy DeploymentJobType = "DEPLOY"
+
+	// Change the hosts of an already deployed service to TargetHosts:
+	// new hosts are configured & started, removed hosts are stopped & uninstalled
+	DeploymentJobTypeChangeHosts DeploymentJobType = "CHANGE_HOSTS"
+)
+
 type SubmitDeploymentJobRequest struct {
 	Ns      string            `json:"namespace"`
 	Service ServiceDefinition `json:"service"`
 	Id      string            `json:"id"`
 
+	Type DeploymentJobType `json:"type,omitempty"`
+
 	BuildVersion             uint64 `json:"build_version"`
 	SecretVersion            uint64 `json:"secret_version"`
 	EnvVersion               uint64 `json:"env_version"`
@@ -23,7 +39,7 @@ type SubmitDeploymentJobRequest struct {
 
 	ModifyKey *string `json:"-"` // hidden; TODO: to be nice, to lock, only the one who have this key can modify the state.
 
-	// TODO: List of hosts to deploy to; for first time deployment
+	// List of hosts to deploy to; for first time deployment, or the new host set for CHANGE_HOSTS job
 	TargetHosts []*Host `json:"target_hosts,omitempty"`
 
 	// TODO: cloudflared configuration
@@ -36,7 +52,222 @@ type SubmitDeploymentJobRequest struct {
 
 	TimeoutSeconds *uint32 `json:"timeout_seconds,omitempty"`
 
+	// If another job of the service is still active, wait in queue instead of rejected
+	QueueIfBusy bool `json:"queue_if_busy,omitempty"`
+
+	// How the hosts are restarted. Empty means one by one.
+	Strategy *RolloutStrategy `json:"strategy,omitempty"`
+
+	// Deploy even if the namespace is frozen; for emergency fix. Recorded in the audit trail.
+	OverrideFreeze bool `json:"override_freeze,omitempty"`
+
+	// When the restart is allowed; configuration is not held. Empty means anytime.
+	Schedule *DeploySchedule `json:"schedule,omitempty"`
+
+	// How many old releases are kept on the hosts after deployed. Empty means the default.
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+
+	// Job ID of the known-good job this request is rolling back to (if it's a rollback)
+	RollbackOf string `json:"rollback_of,omitempty"`
+
+	Agent       string    `json:"agent,omitempty"` // who submit it
 	IsBelieve   bool      `json:"is_believe"`
 	Url         string    `json:"url"`
 	PublishedAt time.Time `json:"published_at"`
 }
+
+// RetentionPolicy is the number of releases kept under /opt/<ns>_<service>, including the one just deployed.
+// Release that is still in use (current symlink) is never removed.
+type RetentionPolicy struct {
+	BuildReleases uint `json:"build_releases,omitempty"`
+	EnvReleases   uint `json:"env_releases,omitempty"`
+}
+
+// RolloutStrategy limits how many hosts are restarted together.
+// If multiple value are set, the smallest batch is used.
+type RolloutStrategy struct {
+	BatchSize                uint `json:"batch_size,omitempty"`
+	MaxUnavailable           uint `json:"max_unavailable,omitempty"`
+	MaxUnavailablePercentage uint `json:"max_unavailable_percentage,omitempty"` // 1 - 100
+
+	Canary *CanaryStrategy `json:"canary,omitempty"`
+}
+
+// CanaryStrategy restarts the first Hosts of the host order, and watch them for BakeSeconds before continuing.
+type CanaryStrategy struct {
+	Hosts       uint   `json:"hosts"`
+	BakeSeconds uint32 `json:"bake_seconds"`
+	AutoPromote bool   `json:"auto_promote"` // promoted by the leader after bake; otherwise wait for user confirmation
+}
+
+// GetCanaryHosts returns the number of canary hosts for the total host.
+// At least one host is left for promotion, otherwise there is no canary.
+func (r *RolloutStrategy) GetCanaryHosts(totalHost int) uint {
+	if r == nil || r.Canary == nil || totalHost <= 1 {
+		return 0
+	}
+
+	return min(r.Canary.Hosts, uint(totalHost-1))
+}
+
+// GetBatchSize returns the number of host restarted together for the total host
+func (r *RolloutStrategy) GetBatchSize(totalHost int) uint {
+	if r == nil || totalHost <= 0 {
+		return 1
+	}
+
+	var batchSize uint
+	limit := func(size uint) {
+		if size == 0 {
+			return
+		}
+		if batchSize == 0 || size < batchSize {
+			batchSize = size
+		}
+	}
+
+	limit(r.BatchSize)
+	limit(r.MaxUnavailable)
+	if r.MaxUnavailablePercentage > 0 {
+		// at least one host, or we never progress
+		limit(max(uint(totalHost)*min(r.MaxUnavailablePercentage, 100)/100, 1))
+	}
+
+	return min(max(batchSize, 1), uint(totalHost))
+}
+
+// DeploySchedule holds the restart until NotBefore, and only inside one of the Windows (if any).
+type DeploySchedule struct {
+	NotBefore *time.Time     `json:"not_before,omitempty"`
+	Windows   []DeployWindow `json:"windows,omitempty"`
+}
+
+// DeployWindow is a daily maintenance window, eg. 22:00 - 02:00 at UTC+7
+type DeployWindow struct {
+	Days             []time.Weekday `json:"days,omitempty"` // the day the window starts (0 is Sunday); empty means every day
+	Start            string         `json:"start"`          // HH:MM
+	End              string         `json:"end"`            // HH:MM; earlier than start means the window ends the next day
+	UTCOffsetMinutes int            `json:"utc_offset_minutes,omitempty"`
+}
+
+func (s *DeploySchedule) Validate() error {
+	if s == nil {
+		return nil
+	}
+
+	for idx, window := range s.Windows {
+		if _, _, err := window.clock(); err != nil {
+			return fmt.Errorf("window %v: %w", idx, err)
+		}
+		for _, day := range window.Days {
+			if day < time.Sunday || day > time.Saturday {
+				return fmt.Errorf("window %v: invalid day %v", idx, int(day))
+			}
+		}
+	}
+
+	return nil
+}
+
+// IsOpen returns true if the restart is allowed at t
+func (s *DeploySchedule) IsOpen(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+
+	if s.NotBefore != nil && t.Before(*s.NotBefore) {
+		return false
+	}
+
+	if len(s.Windows) == 0 {
+		return true
+	}
+
+	for _, window := range s.Windows {
+		// the window might start yesterday
+		for _, day := range []int{-1, 0} {
+			start, end, ok := window.at(t, day)
+			if ok && !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// NextOpen returns the earliest time, starting from t, the restart is allowed
+func (s *DeploySchedule) NextOpen(t time.Time) (time.Time, bool) {
+	from := t
+	if s != nil && s.NotBefore != nil && s.NotBefore.After(from) {
+		from = *s.NotBefore
+	}
+
+	if s.IsOpen(from) {
+		return from.UTC(), true
+	}
+
+	var next time.Time
+	for _, window := range s.Windows {
+		for day := 0; day <= 7; day++ {
+			start, _, ok := window.at(from, day)
+			if !ok || !start.After(from) {
+				continue
+			}
+			if next.IsZero() || start.Before(next) {
+				next = start
+			}
+			break
+		}
+	}
+
+	return next.UTC(), !next.IsZero()
+}
+
+// at returns the window that starts "day" days from t (in the window time zone), if it's open on that day
+func (w DeployWindow) at(t time.Time, day int) (start, end time.Time, ok bool) {
+	startMinute, endMinute, err := w.clock()
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	local := t.In(time.FixedZone("", w.UTCOffsetMinutes*60))
+	midnight := time.Date(local.Year(), local.Month(), local.Day()+day, 0, 0, 0, 0, local.Location())
+
+	if len(w.Days) > 0 && !slices.Contains(w.Days, midnight.Weekday()) {
+		return time.Time{}, time.Time{}, false
+	}
+
+	if endMinute <= startMinute {
+		endMinute += 24 * 60
+	}
+
+	return midnight.Add(time.Duration(startMinute) * time.Minute), midnight.Add(time.Duration(endMinute) * time.Minute), true
+}
+
+// clock returns the start & end of the window in minutes of the day
+func (w DeployWindow) clock() (start, end int, err error) {
+	start, err = parseClock(w.Start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid start: %w", err)
+	}
+
+	end, err = parseClock(w.End)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid end: %w", err)
+	}
+
+	if start == end {
+		return 0, 0, errors.New("start and end cannot be the same")
+	}
+
+	return start, end, nil
+}
+
+func parseClock(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got '%v'", value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}